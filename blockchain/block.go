@@ -1,17 +1,16 @@
 package blockchain
 
 import (
-	"fmt"
 	"time"
-
-	"github.com/vmihailenco/msgpack"
 )
 
 type BlockHeader struct {
+	Version    uint32
 	Height     int64
 	Hash       []byte
 	PrecHash   []byte
 	MerkelHash []byte
+	UtxoRoot   []byte
 	Target     []byte
 	Timestamp  int64
 	Nonce      int64
@@ -23,68 +22,50 @@ type Block struct {
 }
 
 func NewBlock(bc *Blockchain) *Block {
+	height := bc.headers[len(bc.headers)-1].Height + 1
+
 	block := &Block{
 		Header: BlockHeader{
-			Height:    bc.headers[len(bc.headers)-1].Height + 1,
+			Version:   BLOCK_VERSION,
+			Height:    height,
 			PrecHash:  bc.headers[len(bc.headers)-1].Hash,
 			Timestamp: time.Now().Unix(),
 			Target:    bc.lastTarget,
 			Hash:      []byte{},
+
+			// Computed once the block is filled, has the same size
+			UtxoRoot: NewHash(nil),
 		},
-		Transactions: bc.pendingTransactions,
+		Transactions: []Transaction{*NewCoinBaseTransaction(bc, height)},
 	}
 
-	cbTx := NewCoinBaseTransaction(bc)
-	block.Transactions = append([]Transaction{*cbTx}, block.Transactions...)
-
+	// Fill the block with what remains once the header and the coinbase are counted
 	block.processMerkelTree()
 
-	return block
-}
+	size := len(EncodeBlock(block))
 
-var originalBlock *Block
-
-func OriginBlock(bc *Blockchain) *Block {
-	if originalBlock == nil {
-		originalBlock = &Block{
-			Header: BlockHeader{
-				Height:    0,
-				PrecHash:  []byte{},
-				Timestamp: 0,
-				Target:    bc.lastTarget,
-				Hash:      []byte{},
-			},
-			Transactions: []Transaction{},
-		}
+	block.Transactions = append(block.Transactions, bc.mempool.BlockTemplate(bc.params.MaxBlockSize-size, bc.params.MaxBlockTxs-1)...)
 
-		hash, _ := msgpack.Marshal(&originalBlock.Header)
-		newHash := NewHash(hash)
+	block.processMerkelTree()
 
-		originalBlock.Header.Hash = newHash
-	}
+	block.Header.UtxoRoot = bc.utxos.RootAfter(block)
+
+	return block
+}
 
-	return originalBlock
+// Hash of the canonical encoding of the header, see encoding.go
+func (this *BlockHeader) ComputeHash() []byte {
+	return NewHash(EncodeHeader(this))
 }
 
 func (this *Block) Mine(stats *Stats, mustStop *bool) {
-	tmp, _ := msgpack.Marshal(this.Header)
-	newHash := NewHash(tmp)
+	newHash := this.Header.ComputeHash()
 
 	for !*mustStop && compare(newHash, this.Header.Target) >= 0 {
 		this.Header.Nonce++
 		this.Header.Timestamp = time.Now().Unix()
 
-		var err error
-
-		tmp, err = msgpack.Marshal(&this.Header)
-
-		if err != nil {
-			fmt.Println("ERROR", err)
-
-			return
-		}
-
-		newHash = NewHash(tmp)
+		newHash = this.Header.ComputeHash()
 
 		stats.lastHashes++
 	}
@@ -109,31 +90,81 @@ func (this *Block) VerifyOld(bc *Blockchain) bool {
 }
 
 func (this *Block) verifyCommon(bc *Blockchain) bool {
-	hash := this.Header.Hash
-	this.Header.Hash = []byte{}
+	if this.Header.Version != BLOCK_VERSION {
+		bc.logger.Error("Block verify: Unknown version", this.Header.Version)
 
-	tmp, _ := msgpack.Marshal(&this.Header)
-	newHash := NewHash(tmp)
-
-	this.Header.Hash = hash
+		return false
+	}
 
-	if compare(newHash, hash) != 0 {
+	if compare(this.Header.ComputeHash(), this.Header.Hash) != 0 {
 		bc.logger.Error("Block verify: Hashes does not match")
 
 		return false
 	}
 
+	// At least the coinbase
+	if len(this.Transactions) == 0 || len(this.Transactions) > bc.params.MaxBlockTxs {
+		bc.logger.Error("Block verify: Bad number of transactions")
+
+		return false
+	}
+
+	if len(EncodeBlock(this)) > bc.params.MaxBlockSize {
+		bc.logger.Error("Block verify: Block too big")
+
+		return false
+	}
+
 	if len(this.Transactions[0].Ins) > 0 || len(this.Transactions[0].Outs) != 1 {
 		bc.logger.Error("Block verify: Bad coinbase transaction")
 		return false
 	}
 
-	for _, tx := range this.Transactions {
-		if !tx.Verify(bc) {
+	// Outs created by the previous transactions of the block
+	created := make(map[string]*UnspentTxOut)
+
+	ctx := txContext{
+		height:    this.Header.Height,
+		timestamp: this.Header.Timestamp,
+		skipSigs:  bc.isAssumedValid(&this.Header),
+		lookup: func(wallet []byte, in *TxIn) *UnspentTxOut {
+			out, ok := created[outpointKey(in.PrevHash, in.PrevIdx)]
+
+			if ok && string(out.Out.Address) == in.OwnerAddress(wallet) {
+				return out
+			}
+
+			return bc.getCorrespondingOutTx(wallet, in)
+		},
+	}
+
+	for i, tx := range this.Transactions {
+		// Only the first transaction creates coins
+		if i > 0 && len(tx.Ins) == 0 {
+			bc.logger.Error("Block verify: Coinbase after the first transaction")
+
+			return false
+		}
+
+		if !tx.verifyInContext(bc, ctx) {
 			bc.logger.Error("Block verify: Bad transaction")
 
 			return false
 		}
+
+		for i, out := range tx.Outs {
+			if out.IsData() {
+				continue
+			}
+
+			created[outpointKey(tx.Stamp.Hash, i)] = &UnspentTxOut{
+				Out:        out,
+				TxHash:     tx.Stamp.Hash,
+				InIdx:      i,
+				IsCoinbase: len(tx.Ins) == 0,
+				Height:     this.Header.Height,
+			}
+		}
 	}
 
 	if !this.verifyMerkelTree() {
@@ -152,6 +183,18 @@ func (this *Block) Verify(bc *Blockchain) bool {
 		return false
 	}
 
+	if this.Header.Timestamp > time.Now().Unix()+MAX_FUTURE_BLOCK_TIME {
+		bc.logger.Error("Block verify: Timestamp too far in the future")
+
+		return false
+	}
+
+	if !bc.params.CheckCheckpoint(this.Header.Height, this.Header.Hash) {
+		bc.logger.Error("Block verify: Conflicts with a checkpoint")
+
+		return false
+	}
+
 	if !this.verifyCommon(bc) {
 		return false
 	}
@@ -174,6 +217,12 @@ func (this *Block) Verify(bc *Blockchain) bool {
 		return false
 	}
 
+	if compare(this.Header.UtxoRoot, bc.utxos.RootAfter(this)) != 0 {
+		bc.logger.Error("Block verify: Bad UTXO root")
+
+		return false
+	}
+
 	return true
 }
 
@@ -0,0 +1,140 @@
+package blockchain
+
+import (
+	"strconv"
+	"testing"
+)
+
+// Mine the block once its transactions are changed, without adding it
+func remineTestBlock(bc *Blockchain, block *Block) {
+	stop := false
+
+	block.processMerkelTree()
+	block.Header.UtxoRoot = bc.utxos.RootAfter(block)
+	block.Mine(bc.stats, &stop)
+}
+
+// A second coinbase would mint the subsidy twice
+func TestSecondCoinbase(t *testing.T) {
+	bc := newTestNode(t, RegtestParams)
+	dest := newTestWallet(t, "dest")
+
+	mineTestBlock(t, bc)
+
+	block := NewBlock(bc)
+	coinbase := NewCoinBaseTransaction(bc, block.Header.Height)
+	coinbase.Outs[0].Address = []byte(SanitizePubKey(dest.pub))
+
+	if err := coinbase.Sign(dest, bc.params.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	block.Transactions = append(block.Transactions, *coinbase)
+	remineTestBlock(bc, block)
+
+	if bc.AddBlock(block) {
+		t.Fatal("block with two coinbases accepted")
+	}
+
+	if bc.BlocksHeight() != 1 || bc.GetAvailableFunds(dest.pub) != 0 {
+		t.Fatal("second coinbase minted")
+	}
+}
+
+// A node with `count` pending transactions, each spending an out of its own
+func newTestBusyNode(t *testing.T, count int) *Blockchain {
+	bc := newTestNode(t, RegtestParams)
+	dest := newTestWallet(t, "dest")
+
+	for i := 0; i < count; i++ {
+		giveTestOut(bc, bc.wallets["main.key"], "out"+strconv.Itoa(i), 100, 0, false)
+	}
+
+	for i := 0; i < count; i++ {
+		if _, err := bc.SendTo([]string{"50:" + SanitizePubKey(dest.pub)}, SendOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return bc
+}
+
+func TestBlockWithoutTransactions(t *testing.T) {
+	bc := newTestNode(t, RegtestParams)
+	block := NewBlock(bc)
+	stop := false
+
+	block.Transactions = nil
+	block.Mine(bc.stats, &stop)
+
+	decoded, err := DecodeBlock(EncodeBlock(block))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bc.AddBlock(decoded) {
+		t.Fatal("block without transactions accepted")
+	}
+}
+
+func TestBlockLimits(t *testing.T) {
+	bc := newTestBusyNode(t, 30)
+	block := NewBlock(bc)
+	stop := false
+
+	block.Mine(bc.stats, &stop)
+
+	if len(block.Transactions) != 31 {
+		t.Fatal("bad template:", len(block.Transactions))
+	}
+
+	tests := []struct {
+		name  string
+		limit func(params *ChainParams)
+	}{
+		{"too many transactions", func(params *ChainParams) { params.MaxBlockTxs = len(block.Transactions) - 1 }},
+		{"too big", func(params *ChainParams) { params.MaxBlockSize = len(EncodeBlock(block)) - 1 }},
+	}
+
+	for _, test := range tests {
+		params := *bc.params
+		test.limit(bc.params)
+
+		if bc.AddBlock(block) {
+			t.Fatalf("%s: block accepted", test.name)
+		}
+
+		*bc.params = params
+	}
+
+	if !bc.AddBlock(block) {
+		t.Fatal("block within the limits refused")
+	}
+}
+
+// The template leaves out what does not fit, and the block is still valid
+func TestBlockTemplateLimits(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit func(params *ChainParams, full *Block)
+	}{
+		{"max transactions", func(params *ChainParams, full *Block) { params.MaxBlockTxs = 10 }},
+		{"max size", func(params *ChainParams, full *Block) { params.MaxBlockSize = len(EncodeBlock(full)) / 2 }},
+	}
+
+	for _, test := range tests {
+		bc := newTestBusyNode(t, 30)
+		test.limit(bc.params, NewBlock(bc))
+
+		block := mineTestBlock(t, bc)
+
+		if len(block.Transactions) > bc.params.MaxBlockTxs || len(EncodeBlock(block)) > bc.params.MaxBlockSize {
+			t.Fatalf("%s: template over the limits", test.name)
+		}
+
+		if len(block.Transactions) < 2 || len(block.Transactions) == 31 {
+			t.Fatalf("%s: template of %d transactions", test.name, len(block.Transactions))
+		}
+	}
+}
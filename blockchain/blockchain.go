@@ -6,7 +6,6 @@ import (
 	"math/big"
 	"os"
 	"strconv"
-	"strings"
 	"sync"
 	"time"
 
@@ -21,45 +20,52 @@ const (
 	COMMAND_CUSTOM_NEW_BLOCK
 )
 
-var EXPECTED_10_BLOCKS_TIME int64 = 600
-
 type UnspentTxOut struct {
 	Out        TxOut
 	TxHash     []byte
 	InIdx      int
 	IsTargeted bool
-}
-
-type HistoryTx struct {
-	Address   string `json:"address"`
-	Timestamp int64  `json:"timestamp"`
-	Amount    int    `json:"amount"`
+	IsCoinbase bool
+	Height     int64
 }
 
 type Blockchain struct {
 	sync.RWMutex
-	client              *dht.Dht
-	logger              *logging.Logger
-	options             BlockchainOptions
-	headers             []BlockHeader
-	baseTarget          []byte
-	lastTarget          []byte
-	wallets             map[string]*Wallet
-	unspentTxOut        map[string][]UnspentTxOut
-	pendingTransactions []Transaction
-	miningBlock         *Block
-	synced              bool
-	mustStop            bool
-	stats               *Stats
-	running             bool
-	history             []HistoryTx
+	client        *dht.Dht
+	logger        *logging.Logger
+	options       BlockchainOptions
+	params        *ChainParams
+	headers       []BlockHeader
+	baseTarget    []byte
+	lastTarget    []byte
+	wallets       map[string]*Wallet
+	unspentTxOut  map[string][]UnspentTxOut
+	utxos         *UtxoTree
+	mempool       *Mempool
+	rebroadcaster *Rebroadcaster
+	miningBlock   *Block
+	synced        bool
+	mustStop      bool
+	stats         *Stats
+	running       bool
+	history       map[string]*History
+	multisigs     map[string]*Multisig
+	secrets       map[string][]byte
+	dataIndex     *DataIndex
+	assumedValid  map[int64][]byte
+	assumedLock   sync.RWMutex
 }
 
 type BlockchainOptions struct {
 	BootstrapAddr string
 	ListenAddr    string
 	Folder        string
-	Send          string
+	Chain         string
+
+	// Chain to join instead of Chain, with custom params. Kept in a subfolder named after it
+	Params *ChainParams
+
+	Send          []string
 	Interactif    bool
 	Wallets       bool
 	Stats         bool
@@ -67,30 +73,141 @@ type BlockchainOptions struct {
 	Mine          bool
 	NoGui         bool
 	Cluster       int
+	SwapDemo      bool
+	Wallet        string
+	History       bool
+	Page          int
+	PageSize      int
+	CoinSelection string
+	Fee           int
+	Replaceable   bool
+	LockTime      int64
+	Sequence      int64
+	Broadcast     string
+	Sweep         string
+	BumpFee       string
+	Cancel        string
+
+	// Multisig commands, see multisig.go
+	MultisigCreate  string
+	MultisigSend    string
+	MultisigSign    string
+	MultisigCombine []string
+
+	// Locking script to show the address of, see script/
+	ScriptAddress string
+
+	// Hash time-locked contract commands, see htlc.go
+	HTLCInitiate string
+	HTLCRedeem   string
+	HTLCRefund   string
+	HTLCAudit    string
+
+	// Added to the checkpoints of the chain, and overrides its assumed valid block
+	Checkpoints map[int64][]byte
+	AssumeValid []byte
+
+	Supply bool
+
+	// Write a snapshot of the unspent outs to a file, or start from one whose
+	// hash is SnapshotHash or known by the chain, see snapshot.go
+	SnapshotDump string
+	SnapshotLoad string
+	SnapshotHash []byte
+
+	// Keep only the headers, see light.go. Transactions are checked with Merkel
+	// proofs, see proof.go
+	Light       bool
+	TxProof     string
+	VerifyProof string
+
+	// Proofs that an out is unspent, see utxoproof.go
+	UnspentProof       string
+	VerifyUnspentProof string
+
+	// Bytes attached to the sent transaction
+	Data       []byte
+	SearchData string
+
+	MempoolMaxSize  int
+	MempoolMaxCount int
+	MempoolExpiry   time.Duration
 }
 
-func New(options BlockchainOptions) *Blockchain {
-	target, _ := hex.DecodeString("000000FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF")
-	// target, _ := hex.DecodeString("000000FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF")
+func New(options BlockchainOptions) (*Blockchain, error) {
+	params, err := GetChainParams(options.Chain)
+
+	if err != nil && options.Params == nil {
+		return nil, err
+	}
+
+	if options.Params != nil {
+		params = options.Params
+	}
+
+	// Each chain but the main one is kept in its own subfolder
+	if params != &MainParams {
+		options.Folder += "/" + params.Name
+	}
+
+	// The options can add checkpoints to a copy of the params. The consensus rules
+	// are those of the chain, so its ID stays valid
+	custom := *params
+
+	custom.Checkpoints = make(map[int64][]byte)
+
+	for height, hash := range params.Checkpoints {
+		custom.Checkpoints[height] = hash
+	}
+
+	for height, hash := range options.Checkpoints {
+		custom.Checkpoints[height] = hash
+	}
+
+	if options.AssumeValid != nil {
+		custom.AssumeValid = options.AssumeValid
+	}
+
+	params = &custom
 
 	if options.Stats {
 		options.Verbose = 2
 	}
 
+	if options.MempoolMaxSize <= 0 {
+		options.MempoolMaxSize = MEMPOOL_MAX_SIZE
+	}
+
+	if options.MempoolMaxCount <= 0 {
+		options.MempoolMaxCount = MEMPOOL_MAX_COUNT
+	}
+
+	if options.MempoolExpiry <= 0 {
+		options.MempoolExpiry = MEMPOOL_EXPIRY
+	}
+
 	bc := &Blockchain{
-		options:             options,
-		baseTarget:          target,
-		lastTarget:          target,
-		wallets:             make(map[string]*Wallet),
-		unspentTxOut:        make(map[string][]UnspentTxOut),
-		mustStop:            false,
-		stats:               &Stats{},
-		pendingTransactions: []Transaction{},
+		options:       options,
+		params:        params,
+		baseTarget:    params.BaseTarget,
+		lastTarget:    params.BaseTarget,
+		wallets:       make(map[string]*Wallet),
+		unspentTxOut:  make(map[string][]UnspentTxOut),
+		utxos:         NewUtxoTree(nil),
+		mustStop:      false,
+		stats:         &Stats{},
+		mempool:       NewMempool(options.MempoolMaxSize, options.MempoolMaxCount, options.MempoolExpiry),
+		history:       make(map[string]*History),
+		multisigs:     make(map[string]*Multisig),
+		secrets:       make(map[string][]byte),
+		dataIndex:     NewDataIndex([]DataRef{}),
+		assumedValid:  make(map[int64][]byte),
+		rebroadcaster: NewRebroadcaster(),
 	}
 
 	bc.Init()
 
-	return bc
+	return bc, nil
 }
 
 func (this *Blockchain) Init() {
@@ -101,8 +218,6 @@ func (this *Blockchain) Init() {
 		OnStore: func(pack dht.Packet) bool {
 			this.Lock()
 			defer this.Unlock()
-			block := Block{}
-
 			// var cmd dht.StoreInst
 
 			cmd := pack.GetStore()
@@ -115,14 +230,34 @@ func (this *Blockchain) Init() {
 			// 	return false
 			// }
 
-			err := msgpack.Unmarshal(cmd.Header.Data, &block)
+			if data, err := this.openStored(cmd.Header.Data, STORED_HEADERS); err == nil {
+				return this.verifyStoredHeaders(data)
+			}
+
+			if _, err := this.openStored(cmd.Header.Data, STORED_HELLO); err == nil {
+				return this.checkHello(cmd.Header.Data) == nil
+			}
+
+			data, err := this.openStored(cmd.Header.Data, STORED_BLOCK)
+
+			if err != nil {
+				this.logger.Warning("ONSTORE Refused block:", err.Error())
+
+				return false
+			}
+
+			block, err := DecodeBlock(data)
 
 			if err != nil {
-				this.logger.Critical("ONSTORE Unmarshal error", err.Error())
+				this.logger.Critical("ONSTORE Decode error", err.Error())
 
 				return false
 			}
 
+			if this.options.Light {
+				return this.verifyLightBlock(block)
+			}
+
 			if block.Header.Height == this.headers[len(this.headers)-1].Height+1 {
 				return block.Verify(this)
 			} else if block.Header.Height <= this.headers[len(this.headers)-1].Height {
@@ -171,10 +306,10 @@ func (this *Blockchain) Init() {
 		return
 	}
 
-	OriginBlock(this)
+	genesis := this.params.Genesis()
 
-	this.headers = append(this.headers, originalBlock.Header)
-	this.miningBlock = originalBlock
+	this.headers = append(this.headers, genesis.Header)
+	this.miningBlock = genesis
 
 	if err := LoadStoredHeaders(this); err != nil {
 		this.logger.Critical("Cannot load stored headers", err)
@@ -182,18 +317,54 @@ func (this *Blockchain) Init() {
 		return
 	}
 
+	this.assumeValid(this.headers)
+
 	if err := LoadUnspent(this); err != nil {
 		this.logger.Critical("Cannot load unspent tx", err)
 
 		return
 	}
 
+	if err := LoadHistory(this); err != nil {
+		this.logger.Critical("Cannot load history", err)
+
+		return
+	}
+
+	if err := LoadMultisigs(this); err != nil {
+		this.logger.Critical("Cannot load multisig addresses", err)
+
+		return
+	}
+
+	if err := LoadDataIndex(this); err != nil {
+		this.logger.Critical("Cannot load data index", err)
+
+		return
+	}
+
+	this.pruneDataIndex()
+
+	if err := LoadSecrets(this); err != nil {
+		this.logger.Critical("Cannot load secrets", err)
+
+		return
+	}
+
+	if err := LoadMempool(this); err != nil {
+		this.logger.Warning("Cannot load pending transactions", err)
+	}
+
 }
 
 func (this *Blockchain) Stop() {
 	this.client.Stop()
 	StoreLastHeaders(this)
 	StoreUnspent(this)
+	StoreHistory(this)
+	StoreSecrets(this)
+	StoreDataIndex(this)
+	StoreMempool(this)
 }
 
 func (this *Blockchain) Start() error {
@@ -201,11 +372,25 @@ func (this *Blockchain) Start() error {
 		return err
 	}
 
+	if err := this.handshake(); err != nil {
+		this.client.Stop()
+
+		return err
+	}
+
 	if this.options.Stats {
 		go this.StatsLoop()
 	}
 
 	go func() {
+		if len(this.options.SnapshotLoad) > 0 {
+			if err := this.LoadSnapshot(this.options.SnapshotLoad); err != nil {
+				this.logger.Critical("Cannot load snapshot", err)
+
+				return
+			}
+		}
+
 		this.Sync()
 
 		if !this.synced {
@@ -214,18 +399,254 @@ func (this *Blockchain) Start() error {
 			return
 		}
 
+		go this.RebroadcastLoop()
+
 		if this.options.Wallets {
 			this.ShowWallets()
 
 		}
 
-		if len(this.options.Send) > 0 {
-			if err := this.SendTo(this.options.Send); err != nil {
+		if this.options.History {
+			this.ShowHistory(this.options.Wallet, this.options.Page, this.options.PageSize)
+
+			os.Exit(0)
+		}
+
+		if this.options.Supply {
+			this.ShowSupply()
+
+			os.Exit(0)
+		}
+
+		if len(this.options.TxProof) > 0 {
+			if err := this.ShowTxProof(this.options.TxProof); err != nil {
+				this.logger.Error("Unable to prove the transaction", err)
+			}
+
+			os.Exit(0)
+		}
+
+		if len(this.options.VerifyProof) > 0 {
+			if err := this.ShowProofCheck(this.options.VerifyProof); err != nil {
+				this.logger.Error("Bad proof", err)
+			}
+
+			os.Exit(0)
+		}
+
+		if len(this.options.UnspentProof) > 0 {
+			if err := this.ShowUnspentProof(this.options.UnspentProof); err != nil {
+				this.logger.Error("Unable to prove the out", err)
+			}
+
+			os.Exit(0)
+		}
+
+		if len(this.options.VerifyUnspentProof) > 0 {
+			if err := this.ShowUnspentProofCheck(this.options.VerifyUnspentProof); err != nil {
+				this.logger.Error("Bad proof", err)
+			}
+
+			os.Exit(0)
+		}
+
+		if len(this.options.SnapshotDump) > 0 {
+			if err := this.DumpSnapshot(this.options.SnapshotDump); err != nil {
+				this.logger.Error("Cannot dump snapshot", err)
+			}
+
+			os.Exit(0)
+		}
+
+		if len(this.options.SearchData) > 0 {
+			this.ShowDataSearch(this.options.SearchData)
+
+			os.Exit(0)
+		}
+
+		if (len(this.options.Send) > 0 || len(this.options.Data) > 0) && len(this.options.MultisigSend) == 0 {
+			tx, err := this.SendTo(this.options.Send, SendOptions{
+				Strategy:    this.options.CoinSelection,
+				Fee:         this.options.Fee,
+				Replaceable: this.options.Replaceable,
+				LockTime:    this.options.LockTime,
+				Sequence:    this.options.Sequence,
+				Data:        this.options.Data,
+			})
+
+			if err != nil {
 				this.logger.Error("Unable to Send", err)
 
 				return
 			}
 
+			if !this.isFinal(tx) {
+				this.ShowLockedTransaction(tx)
+			}
+
+			time.Sleep(time.Second * 5)
+			os.Exit(0)
+		}
+
+		if len(this.options.ScriptAddress) > 0 {
+			this.ShowScriptAddress(this.options.ScriptAddress)
+
+			os.Exit(0)
+		}
+
+		if len(this.options.HTLCInitiate) > 0 {
+			contract, err := this.InitiateHTLCTo(this.options.HTLCInitiate)
+
+			if err != nil {
+				this.logger.Error("Unable to initiate the contract", err)
+
+				return
+			}
+
+			this.ShowHTLC(contract)
+
+			time.Sleep(time.Second * 5)
+			os.Exit(0)
+		}
+
+		if len(this.options.HTLCRedeem) > 0 {
+			if _, err := this.RedeemHTLCTo(this.options.HTLCRedeem); err != nil {
+				this.logger.Error("Unable to redeem the contract", err)
+
+				return
+			}
+
+			time.Sleep(time.Second * 5)
+			os.Exit(0)
+		}
+
+		if len(this.options.HTLCRefund) > 0 {
+			tx, err := this.RefundHTLCTo(this.options.HTLCRefund)
+
+			if err != nil {
+				this.logger.Error("Unable to refund the contract", err)
+
+				return
+			}
+
+			if !this.isFinal(tx) {
+				this.ShowLockedTransaction(tx)
+			}
+
+			time.Sleep(time.Second * 5)
+			os.Exit(0)
+		}
+
+		if len(this.options.HTLCAudit) > 0 {
+			contract, err := ParseHTLC(this.options.HTLCAudit)
+
+			if err != nil {
+				this.logger.Error("Unable to audit the contract", err)
+
+				return
+			}
+
+			this.ShowHTLC(contract)
+
+			os.Exit(0)
+		}
+
+		if len(this.options.MultisigCreate) > 0 {
+			multisig, err := this.CreateMultisig(this.options.MultisigCreate)
+
+			if err != nil {
+				this.logger.Error("Unable to create the multisig address", err)
+
+				return
+			}
+
+			this.ShowMultisig(multisig)
+
+			os.Exit(0)
+		}
+
+		if len(this.options.MultisigSend) > 0 {
+			tx, err := this.NewMultisigTransaction(this.options.MultisigSend, this.options.Send, this.options.Fee)
+
+			if err != nil {
+				this.logger.Error("Unable to create the multisig transaction", err)
+
+				return
+			}
+
+			this.ShowPartialTransaction(tx)
+
+			os.Exit(0)
+		}
+
+		if len(this.options.MultisigSign) > 0 {
+			tx, err := this.SignPartialTransaction(this.options.MultisigSign)
+
+			if err != nil {
+				this.logger.Error("Unable to sign the transaction", err)
+
+				return
+			}
+
+			this.ShowPartialTransaction(tx)
+
+			os.Exit(0)
+		}
+
+		if len(this.options.MultisigCombine) > 0 {
+			tx, err := this.CombinePartialTransactions(this.options.MultisigCombine)
+
+			if err != nil {
+				this.logger.Error("Unable to combine the transactions", err)
+
+				return
+			}
+
+			this.ShowPartialTransaction(tx)
+
+			os.Exit(0)
+		}
+
+		if len(this.options.Broadcast) > 0 {
+			if err := this.BroadcastRawTransaction(this.options.Broadcast); err != nil {
+				this.logger.Error("Unable to broadcast", err)
+
+				return
+			}
+
+			time.Sleep(time.Second * 5)
+			os.Exit(0)
+		}
+
+		if len(this.options.BumpFee) > 0 {
+			if err := this.BumpFeeTo(this.options.BumpFee); err != nil {
+				this.logger.Error("Unable to bump the fee", err)
+
+				return
+			}
+
+			time.Sleep(time.Second * 5)
+			os.Exit(0)
+		}
+
+		if len(this.options.Cancel) > 0 {
+			if err := this.CancelTo(this.options.Cancel); err != nil {
+				this.logger.Error("Unable to cancel", err)
+
+				return
+			}
+
+			time.Sleep(time.Second * 5)
+			os.Exit(0)
+		}
+
+		if len(this.options.Sweep) > 0 {
+			if err := this.SweepTo(this.options.Sweep, this.options.Wallet); err != nil {
+				this.logger.Error("Unable to Sweep", err)
+
+				return
+			}
+
 			time.Sleep(time.Second * 5)
 			os.Exit(0)
 		}
@@ -238,55 +659,77 @@ func (this *Blockchain) Start() error {
 	return nil
 }
 
-func (this *Blockchain) SendTo(value string) error {
-	splited := strings.Split(value, ":")
+// Send coins from main.key to every given 'amount:destAddress' in one transaction.
+// A transaction locked in the future is only signed and returned, to be broadcast later
+func (this *Blockchain) SendTo(values []string, options SendOptions) (*Transaction, error) {
+	if len(values) == 0 && len(options.Data) == 0 {
+		return nil, errors.New("No payment to send")
+	}
 
-	if len(splited) != 2 {
-		return errors.New("Bad send format")
+	if len(options.Data) > this.params.MaxDataSize {
+		return nil, errors.New("Data too big, max " + strconv.Itoa(this.params.MaxDataSize) + " bytes")
 	}
 
-	amount, err := strconv.Atoi(splited[0])
+	payments, err := ParsePayments(values)
 
-	if err != nil || amount <= 0 {
-		return errors.New("Invalid amount: " + splited[0])
+	if err != nil {
+		return nil, err
 	}
 
-	// pub := UnsanitizePubKey(splited[1])
+	if _, err := GetCoinSelector(options.Strategy); err != nil {
+		return nil, err
+	}
 
-	tx := NewTransaction(amount, []byte(splited[1]), this)
+	if options.Fee < 0 {
+		return nil, errors.New("Invalid fee: " + strconv.Itoa(options.Fee))
+	}
 
-	if tx == nil || !this.AddTransationToWaiting(tx) {
-		return errors.New("Unable to create the transaction")
+	if options.LockTime < 0 || options.Sequence < 0 {
+		return nil, errors.New("Invalid lock")
 	}
 
-	this.mustStop = true
+	tx := NewTransaction(payments, options, this)
 
-	serie, err := msgpack.Marshal(tx)
+	if tx == nil {
+		return nil, errors.New("Unable to create the transaction")
+	}
 
-	if err != nil {
-		return errors.New("Cannot marshal transaction: " + err.Error())
+	if !this.isFinal(tx) {
+		return tx, nil
 	}
 
-	this.client.Broadcast(dht.Custom{
-		Command: COMMAND_CUSTOM_NEW_TRANSACTION,
-		Data:    serie,
-	})
+	if err := this.SubmitTransaction(tx); err != nil {
+		return nil, errors.New("Unable to create the transaction: " + err.Error())
+	}
 
-	return nil
+	return tx, nil
 }
 
-func (this *Blockchain) Logger() *logging.Logger {
-	return this.logger
-}
+func (this *Blockchain) BroadcastTransaction(tx *Transaction) error {
+	// Nodes not connected to a network keep their transactions local
+	if this.client != nil {
+		this.client.Broadcast(dht.Custom{
+			Command: COMMAND_CUSTOM_NEW_TRANSACTION,
+			Data:    this.params.Seal(EncodeTransaction(tx)),
+		})
+	}
 
-func (this *Blockchain) hasPending(tx *Transaction) bool {
-	for _, t := range this.pendingTransactions {
-		if compare(t.Stamp.Hash, tx.Stamp.Hash) == 0 {
-			return true
-		}
+	if this.isOwnTransaction(tx) {
+		now := time.Now().Unix()
+
+		this.rebroadcaster.Track(tx, now)
+		this.rebroadcaster.Broadcasted(tx.Stamp.Hash, now)
 	}
 
-	return false
+	return nil
+}
+
+func (this *Blockchain) isOwnTransaction(tx *Transaction) bool {
+	return this.walletByPub(tx.Stamp.Pub) != nil
+}
+
+func (this *Blockchain) Logger() *logging.Logger {
+	return this.logger
 }
 
 func (this *Blockchain) Dispatch(cmd *dht.Custom) interface{} {
@@ -295,85 +738,62 @@ func (this *Blockchain) Dispatch(cmd *dht.Custom) interface{} {
 
 	switch cmd.Command {
 	case COMMAND_CUSTOM_NEW_TRANSACTION:
-		var tx Transaction
-
-		msgpack.Unmarshal(cmd.Data, &tx)
-
-		if !this.AddTransationToWaiting(&tx) {
+		// Light nodes cannot check transactions without the unspent outs
+		if this.options.Light {
 			return nil
 		}
 
-	case COMMAND_CUSTOM_NEW_BLOCK:
-	}
-
-	return nil
-}
+		data, err := this.params.Open(cmd.Data)
 
-func (this *Blockchain) Wait() {
-	this.client.Wait()
-}
+		if err != nil {
+			this.logger.Warning("Refused transaction:", err)
 
-func (this *Blockchain) doSync() error {
-	blob, err := this.client.Fetch(NewHash(this.headers[len(this.headers)-1].Hash))
+			return nil
+		}
 
-	if err != nil {
-		return err
-	}
+		tx, err := DecodeTransaction(data)
 
-	var block Block
+		if err != nil {
+			this.logger.Warning("Received bad transaction:", err)
 
-	msgpack.Unmarshal(blob, &block)
+			return nil
+		}
 
-	if !this.AddBlock(&block) {
-		this.logger.Warning("Sync: Received bad block")
+		if !this.AddTransationToWaiting(tx) {
+			return nil
+		}
 
-		return errors.New("Cannot add block")
+	case COMMAND_CUSTOM_NEW_BLOCK:
 	}
 
 	return nil
 }
 
-func (this *Blockchain) Sync() {
-	this.logger.Info("Start syncing at", len(this.headers)-1)
-
-	for this.doSync() == nil {
-	}
-
-	this.synced = true
-
-	go func() {
-		for {
-			if err := this.doSync(); err != nil {
-				time.Sleep(time.Second * 5)
-
-				continue
-			}
-
-			this.mustStop = true
-
-			time.Sleep(time.Second * 5)
-		}
-	}()
+func (this *Blockchain) Wait() {
+	this.client.Wait()
 }
 
 func (this *Blockchain) AddBlock(block *Block) bool {
+	this.Lock()
+	defer this.Unlock()
+
 	if !block.Verify(this) {
 		this.logger.Error("Cannot add block: bad block")
 
 		return false
 	}
 
-	this.Lock()
 	this.headers = append(this.headers, block.Header)
 	if err := StoreLastHeaders(this); err != nil {
 		this.logger.Warning("Cannot store last headers", err)
 	}
-	this.Unlock()
 
 	this.UpdateUnspentTxOuts(block)
 	this.RemovePendingTransaction(block.Transactions)
+	this.confirmOwnTransactions(block)
+	this.expirePendingTransactions()
 
-	if block.Header.Height%10 == 0 {
+	if this.params.RetargetInterval > 0 && block.Header.Height%this.params.RetargetInterval == 0 {
 		this.adjustDifficulty(block)
 	}
 
@@ -381,6 +801,18 @@ func (this *Blockchain) AddBlock(block *Block) bool {
 		this.logger.Warning("Cannot store unspents", err)
 	}
 
+	if err := StoreHistory(this); err != nil {
+		this.logger.Warning("Cannot store history", err)
+	}
+
+	if err := StoreSecrets(this); err != nil {
+		this.logger.Warning("Cannot store secrets", err)
+	}
+
+	if err := StoreDataIndex(this); err != nil {
+		this.logger.Warning("Cannot store data index", err)
+	}
+
 	return true
 }
 
@@ -393,10 +825,10 @@ func (this *Blockchain) adjustDifficulty(block *Block) {
 	oldDiff := big.NewInt(0)
 	oldDiff = oldDiff.Quo(base, actual)
 
-	timePassed := block.Header.Timestamp - this.headers[block.Header.Height-10].Timestamp
+	timePassed := block.Header.Timestamp - this.headers[block.Header.Height-this.params.RetargetInterval].Timestamp
 
 	newDiff := big.NewInt(0)
-	newDiff = newDiff.Mul(oldDiff, big.NewInt(EXPECTED_10_BLOCKS_TIME/timePassed))
+	newDiff = newDiff.Mul(oldDiff, big.NewInt(this.params.TargetTimespan/timePassed))
 
 	test := big.NewInt(0)
 	if newDiff.Int64() > test.Mul(oldDiff, big.NewInt(4)).Int64() {
@@ -447,9 +879,7 @@ func (this *Blockchain) Mine() {
 
 			this.logger.Info("Found block !", hex.EncodeToString(this.miningBlock.Header.Hash))
 
-			serie, _ := msgpack.Marshal(this.miningBlock)
-
-			_, nb, err := this.client.StoreAt(NewHash(this.headers[len(this.headers)-1].Hash), serie)
+			_, nb, err := this.client.StoreAt(NewHash(this.headers[len(this.headers)-1].Hash), this.sealStored(STORED_BLOCK, EncodeBlock(this.miningBlock)))
 
 			if err != nil || nb == 0 {
 				this.logger.Warning("ERROR STORING BLOCK IN THE DHT !", hex.EncodeToString(this.miningBlock.Header.Hash))
@@ -460,7 +890,9 @@ func (this *Blockchain) Mine() {
 
 			this.stats.foundBlocks++
 
-			this.doSync()
+			if this.doSync() == nil {
+				this.storeHeaders()
+			}
 		}
 	}()
 }
@@ -503,6 +935,15 @@ func (this *Blockchain) BlocksHeight() int64 {
 	return this.headers[len(this.headers)-1].Height
 }
 
+// Coins created so far by the coinbases
+func (this *Blockchain) TotalSupply() int {
+	return this.params.SupplyAt(this.BlocksHeight())
+}
+
+func (this *Blockchain) MaxSupply() int {
+	return this.params.MaxSupply()
+}
+
 func (this *Blockchain) TimeSinceLastBlock() int64 {
 	return time.Now().Unix() - this.headers[len(this.headers)-1].Timestamp
 }
@@ -512,18 +953,31 @@ func (this *Blockchain) StoredKeys() int {
 }
 
 func (this *Blockchain) WaitingTransactionCount() int {
-	return len(this.pendingTransactions)
+	return this.mempool.Count()
 }
 
 func (this *Blockchain) GetOwnHistory() []HistoryTx {
-	return this.history
+	res, _ := this.GetHistory("main.key", 0, 0)
+
+	// Oldest first
+	for i, j := 0, len(res)-1; i < j; i, j = i+1, j-1 {
+		res[i], res[j] = res[j], res[i]
+	}
+
+	return res
 }
 
 func (this *Blockchain) GetOwnWaitingTx() []HistoryTx {
 	res := []HistoryTx{}
 
-	for _, tx := range this.pendingTransactions {
+	for _, entry := range this.mempool.Entries() {
+		tx := entry.Tx
 		txValue := 0
+		firstSeen := entry.Time
+
+		if ownTx, ok := this.rebroadcaster.Get(tx.Stamp.Hash); ok {
+			firstSeen = ownTx.FirstSeen
+		}
 
 		own := false
 
@@ -535,6 +989,10 @@ func (this *Blockchain) GetOwnWaitingTx() []HistoryTx {
 		}
 
 		for _, out := range tx.Outs {
+			if out.IsData() {
+				continue
+			}
+
 			if own && compare(out.Address, ownAddrStr) != 0 {
 				txValue -= out.Value
 				addr = string(out.Address)
@@ -549,12 +1007,21 @@ func (this *Blockchain) GetOwnWaitingTx() []HistoryTx {
 			}
 		}
 
-		if txValue != 0 {
-			res = append(res, HistoryTx{
+		data := tx.GetData()
+
+		if txValue != 0 || (own && data != nil) {
+			entry := HistoryTx{
+				TxHash:    hex.EncodeToString(tx.Stamp.Hash),
 				Address:   addr,
-				Timestamp: time.Now().Unix(),
+				Timestamp: firstSeen,
 				Amount:    txValue,
-			})
+			}
+
+			if data != nil {
+				entry.Data = DataString(data)
+			}
+
+			res = append(res, entry)
 		}
 	}
 
@@ -589,24 +1056,24 @@ func (this *Blockchain) NextDifficulty() int64 {
 
 	timePassed := (time.Now().Unix() - this.headers[len(this.headers)-1].Timestamp)
 
-	if timePassed == 0 {
+	if timePassed == 0 || this.params.RetargetInterval == 0 {
 		return oldDiff.Int64()
 	}
 
-	nbBlocks := int64((len(this.headers) - 1) % 10)
+	nbBlocks := int64(len(this.headers)-1) % this.params.RetargetInterval
 
 	if nbBlocks == 0 {
 		nbBlocks = 1
 	}
 
-	timePassed = (timePassed / nbBlocks) * 10
+	timePassed = (timePassed / nbBlocks) * this.params.RetargetInterval
 
 	if timePassed == 0 {
 		timePassed = 1
 	}
 
 	newDiff := big.NewInt(0)
-	newDiff = newDiff.Mul(oldDiff, big.NewInt((EXPECTED_10_BLOCKS_TIME / timePassed)))
+	newDiff = newDiff.Mul(oldDiff, big.NewInt((this.params.TargetTimespan / timePassed)))
 
 	test := big.NewInt(0)
 	if newDiff.Int64() > test.Mul(oldDiff, big.NewInt(4)).Int64() {
@@ -0,0 +1,125 @@
+package blockchain
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	logging "github.com/op/go-logging"
+)
+
+// A node of the chain with only its genesis block, that never touches the disk
+// nor the network
+func newTestBlockchain(params ChainParams) *Blockchain {
+	genesis := params.Genesis()
+
+	return &Blockchain{
+		logger:        logging.MustGetLogger("test"),
+		params:        &params,
+		headers:       []BlockHeader{genesis.Header},
+		baseTarget:    params.BaseTarget,
+		lastTarget:    params.BaseTarget,
+		wallets:       make(map[string]*Wallet),
+		unspentTxOut:  make(map[string][]UnspentTxOut),
+		utxos:         NewUtxoTree(nil),
+		stats:         &Stats{},
+		mempool:       NewMempool(MEMPOOL_MAX_SIZE, MEMPOOL_MAX_COUNT, MEMPOOL_EXPIRY),
+		history:       make(map[string]*History),
+		multisigs:     make(map[string]*Multisig),
+		secrets:       make(map[string][]byte),
+		dataIndex:     NewDataIndex([]DataRef{}),
+		assumedValid:  make(map[int64][]byte),
+		rebroadcaster: NewRebroadcaster(),
+		miningBlock:   genesis,
+	}
+}
+
+func newTestWallet(t *testing.T, name string) *Wallet {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	x509EncodedPub, err := x509.MarshalPKIXPublicKey(key.Public())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Wallet{
+		name: name,
+		key:  key,
+		pub:  pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: x509EncodedPub}),
+	}
+}
+
+// Give the wallet an unspent out of `value`, created by a block at `height`
+func giveTestOut(bc *Blockchain, wallet *Wallet, txHash string, value int, height int64, coinbase bool) UnspentTxOut {
+	address := SanitizePubKey(wallet.pub)
+
+	out := UnspentTxOut{
+		Out:        TxOut{Value: value, Address: []byte(address)},
+		TxHash:     NewHash([]byte(txHash)),
+		IsCoinbase: coinbase,
+		Height:     height,
+	}
+
+	bc.addUnspentOut(out)
+
+	return out
+}
+
+// A transaction of the wallet spending `outs` to pay `value` to `to`
+func newTestSpend(t *testing.T, bc *Blockchain, wallet *Wallet, outs []UnspentTxOut, value int, to string) *Transaction {
+	ins := []TxIn{}
+
+	for _, out := range outs {
+		ins = append(ins, TxIn{PrevHash: out.TxHash, PrevIdx: out.InIdx})
+	}
+
+	tx, err := NewSignedTransaction(ins, []TxOut{{Value: value, Address: []byte(to)}}, wallet, bc.params.ID)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tx
+}
+
+func testContext(bc *Blockchain, height int64, timestamp int64) txContext {
+	return txContext{
+		height:    height,
+		timestamp: timestamp,
+		lookup:    bc.getCorrespondingOutTx,
+	}
+}
+
+// A node keeping its state in a temporary folder, where its main.key wallet is created
+func newTestNode(t *testing.T, params ChainParams) *Blockchain {
+	bc := newTestBlockchain(params)
+	bc.options.Folder = t.TempDir()
+
+	if err := SetupStorage(bc); err != nil {
+		t.Fatal(err)
+	}
+
+	return bc
+}
+
+// Mine a block with the pending transactions of the node, and add it
+func mineTestBlock(t *testing.T, bc *Blockchain) *Block {
+	block := NewBlock(bc)
+	stop := false
+
+	block.Mine(bc.stats, &stop)
+
+	if !bc.AddBlock(block) {
+		t.Fatal("mined block refused at height", block.Header.Height)
+	}
+
+	return block
+}
@@ -0,0 +1,226 @@
+package blockchain
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strconv"
+)
+
+// Consensus rules of a chain. Nodes with different params do not share blocks nor transactions
+type ChainParams struct {
+	Name string
+
+	// Carried by everything sent to other nodes, so nodes of other chains refuse it
+	ID uint32
+
+	// Target of the genesis block, and easiest target of the chain
+	BaseTarget []byte
+
+	// Number of blocks between difficulty adjustments, 0 to never adjust
+	RetargetInterval int64
+
+	// Expected time to mine RetargetInterval blocks, in seconds
+	TargetTimespan int64
+
+	// Value of the coinbase outs, halved every HalvingInterval blocks.
+	// A HalvingInterval of 0 never halves it, so the supply is not capped
+	InitialSubsidy  int
+	HalvingInterval int64
+
+	// Default number of blocks to wait before spending a coinbase out
+	CoinbaseMaturity int64
+
+	// Max size of an encoded block in bytes, and max number of transactions in it
+	// including the coinbase. A block must fit in a single DHT store
+	MaxBlockSize int
+	MaxBlockTxs  int
+
+	// Max number of bytes carried by the data out of a transaction
+	MaxDataSize int
+
+	GenesisTimestamp int64
+
+	// Block hashes by height. Chains with another block at one of these heights are refused
+	Checkpoints map[int64][]byte
+
+	// Hash of a block trusted to be valid. The signatures of its ancestors are not
+	// checked when syncing headers first
+	AssumeValid []byte
+
+	// Hashes of the UTXO snapshots trusted by the chain, by height
+	Snapshots map[int64][]byte
+}
+
+func mustDecodeHex(value string) []byte {
+	res, err := hex.DecodeString(value)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return res
+}
+
+var (
+	MainParams = ChainParams{
+		Name:             "main",
+		ID:               0xc47d0001,
+		BaseTarget:       mustDecodeHex("000000FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF"),
+		RetargetInterval: 10,
+		TargetTimespan:   600,
+		InitialSubsidy:   100,
+		HalvingInterval:  100000,
+		CoinbaseMaturity: 100,
+		MaxBlockSize:     128 * 1024,
+		MaxBlockTxs:      1000,
+		MaxDataSize:      80,
+		GenesisTimestamp: 0,
+	}
+
+	TestParams = ChainParams{
+		Name:             "test",
+		ID:               0xc47d0002,
+		BaseTarget:       mustDecodeHex("00000FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF"),
+		RetargetInterval: 10,
+		TargetTimespan:   600,
+		InitialSubsidy:   100,
+		HalvingInterval:  100000,
+		CoinbaseMaturity: 100,
+		MaxBlockSize:     128 * 1024,
+		MaxBlockTxs:      1000,
+		MaxDataSize:      80,
+		GenesisTimestamp: 1514764800,
+	}
+
+	// Local networks for development: blocks are found instantly and coinbases
+	// can be spent right away
+	RegtestParams = ChainParams{
+		Name:             "regtest",
+		ID:               0xc47d0003,
+		BaseTarget:       mustDecodeHex("7FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF"),
+		RetargetInterval: 0,
+		TargetTimespan:   600,
+		InitialSubsidy:   100,
+		HalvingInterval:  150,
+		CoinbaseMaturity: 1,
+		MaxBlockSize:     128 * 1024,
+		MaxBlockTxs:      1000,
+		MaxDataSize:      80,
+		GenesisTimestamp: 1514764801,
+	}
+
+	CHAINS = []*ChainParams{&MainParams, &TestParams, &RegtestParams}
+)
+
+// Params of the chain named `name`. An empty name is the main chain
+func GetChainParams(name string) (*ChainParams, error) {
+	if len(name) == 0 {
+		return &MainParams, nil
+	}
+
+	for _, params := range CHAINS {
+		if params.Name == name {
+			return params, nil
+		}
+	}
+
+	return nil, errors.New("Unknown chain: " + name)
+}
+
+// Value of the coinbase out of the block at `height`
+func (this *ChainParams) Subsidy(height int64) int {
+	if height <= 0 {
+		return 0
+	}
+
+	if this.HalvingInterval <= 0 {
+		return this.InitialSubsidy
+	}
+
+	halvings := height / this.HalvingInterval
+
+	if halvings >= 63 {
+		return 0
+	}
+
+	return this.InitialSubsidy >> uint(halvings)
+}
+
+// Coins created by the blocks up to `height`
+func (this *ChainParams) SupplyAt(height int64) int {
+	supply := 0
+
+	for start := int64(1); start <= height; {
+		subsidy := this.Subsidy(start)
+
+		if subsidy == 0 {
+			break
+		}
+
+		// Last block with the same subsidy
+		end := height
+
+		if this.HalvingInterval > 0 {
+			if last := (start/this.HalvingInterval+1)*this.HalvingInterval - 1; last < end {
+				end = last
+			}
+		}
+
+		supply += subsidy * int(end-start+1)
+		start = end + 1
+	}
+
+	return supply
+}
+
+// Coins that will ever be created, or 0 if the supply is not capped
+func (this *ChainParams) MaxSupply() int {
+	if this.HalvingInterval <= 0 {
+		return 0
+	}
+
+	return this.SupplyAt(63 * this.HalvingInterval)
+}
+
+// The first block of the chain, that every node has
+func (this *ChainParams) Genesis() *Block {
+	block := &Block{
+		Header: BlockHeader{
+			Version:   BLOCK_VERSION,
+			Height:    0,
+			PrecHash:  []byte{},
+			Timestamp: this.GenesisTimestamp,
+			Target:    this.BaseTarget,
+			UtxoRoot:  UtxoRoot(nil),
+			Hash:      []byte{},
+		},
+		Transactions: []Transaction{},
+	}
+
+	block.Header.Hash = block.Header.ComputeHash()
+
+	return block
+}
+
+// Prefix the payload with the chain ID
+func (this *ChainParams) Seal(payload []byte) []byte {
+	res := make([]byte, 4, 4+len(payload))
+
+	binary.BigEndian.PutUint32(res, this.ID)
+
+	return append(res, payload...)
+}
+
+// The payload sealed by Seal, if it was by a node of this chain
+func (this *ChainParams) Open(data []byte) ([]byte, error) {
+	if len(data) < 4 {
+		return nil, errors.New("Missing chain ID")
+	}
+
+	if id := binary.BigEndian.Uint32(data); id != this.ID {
+		return nil, errors.New("Wrong chain ID " + strconv.FormatUint(uint64(id), 16))
+	}
+
+	return data[4:], nil
+}
@@ -0,0 +1,199 @@
+package blockchain
+
+import "testing"
+
+func TestChainsAreDistinct(t *testing.T) {
+	ids := make(map[uint32]bool)
+	genesis := make(map[string]bool)
+
+	for _, params := range CHAINS {
+		hash := params.Genesis().Header.Hash
+
+		if compare(hash, params.Genesis().Header.ComputeHash()) != 0 {
+			t.Errorf("%s: bad genesis hash", params.Name)
+		}
+
+		if ids[params.ID] || genesis[string(hash)] {
+			t.Errorf("%s: shares its ID or its genesis with another chain", params.Name)
+		}
+
+		ids[params.ID] = true
+		genesis[string(hash)] = true
+	}
+}
+
+func TestGetChainParams(t *testing.T) {
+	tests := []struct {
+		name   string
+		params *ChainParams
+	}{
+		{"", &MainParams},
+		{"main", &MainParams},
+		{"test", &TestParams},
+		{"regtest", &RegtestParams},
+		{"nope", nil},
+	}
+
+	for _, test := range tests {
+		params, err := GetChainParams(test.name)
+
+		if params != test.params || (err == nil) != (test.params != nil) {
+			t.Errorf("%q: got %v, %v", test.name, params, err)
+		}
+	}
+
+	if _, err := New(BlockchainOptions{Chain: "nope"}); err == nil {
+		t.Fatal("node started on an unknown chain")
+	}
+}
+
+func TestSealOpen(t *testing.T) {
+	data := MainParams.Seal([]byte("payload"))
+
+	if payload, err := MainParams.Open(data); err != nil || string(payload) != "payload" {
+		t.Fatal("cannot open:", err)
+	}
+
+	if _, err := TestParams.Open(data); err == nil {
+		t.Fatal("opened the payload of another chain")
+	}
+
+	if _, err := MainParams.Open(data[:3]); err == nil {
+		t.Fatal("opened without chain ID")
+	}
+}
+
+// Nodes only accept the hellos of their chain
+func TestCheckHello(t *testing.T) {
+	other := RegtestParams
+	other.GenesisTimestamp++
+
+	bc := newTestBlockchain(RegtestParams)
+
+	tests := []struct {
+		params ChainParams
+		ok     bool
+	}{
+		{RegtestParams, true},
+		{MainParams, false},
+		// Same ID, another genesis block
+		{other, false},
+	}
+
+	for _, test := range tests {
+		hello := newTestBlockchain(test.params).hello()
+
+		if err := bc.checkHello(hello); (err == nil) != test.ok {
+			t.Errorf("%s: hello accepted: %v", test.params.Name, err == nil)
+		}
+	}
+
+	if bc.checkHello(bc.sealStored(STORED_BLOCK, bc.params.Genesis().Header.Hash)) == nil {
+		t.Fatal("block accepted as a hello")
+	}
+}
+
+func TestSubsidy(t *testing.T) {
+	tests := []struct {
+		height  int64
+		subsidy int
+	}{
+		{0, 0},
+		{1, 100},
+		{99999, 100},
+		{100000, 50},
+		{199999, 50},
+		{200000, 25},
+		{600000, 1},
+		{699999, 1},
+		{700000, 0},
+		{63 * 100000, 0},
+		{1 << 62, 0},
+	}
+
+	for _, test := range tests {
+		if subsidy := MainParams.Subsidy(test.height); subsidy != test.subsidy {
+			t.Errorf("Subsidy(%d) = %d, want %d", test.height, subsidy, test.subsidy)
+		}
+	}
+}
+
+func TestSupplyAt(t *testing.T) {
+	params := RegtestParams
+
+	for _, height := range []int64{0, 1, 5, 149, 150, 151, 300, 1000, 1049, 1050, 5000} {
+		supply := 0
+
+		for i := int64(1); i <= height; i++ {
+			supply += params.Subsidy(i)
+		}
+
+		if params.SupplyAt(height) != supply {
+			t.Errorf("SupplyAt(%d) = %d, want %d", height, params.SupplyAt(height), supply)
+		}
+	}
+}
+
+func TestMaxSupply(t *testing.T) {
+	// 100 × 99999 + (50 + 25 + 12 + 6 + 3 + 1) × 100000
+	if MainParams.MaxSupply() != 19699900 {
+		t.Fatal("bad max supply:", MainParams.MaxSupply())
+	}
+
+	for _, params := range CHAINS {
+		if params.SupplyAt(1<<62) != params.MaxSupply() {
+			t.Errorf("%s: supply never reaches the max supply", params.Name)
+		}
+
+		// No block after the subsidy reached zero creates coins
+		if params.SupplyAt(64*params.HalvingInterval) != params.MaxSupply() {
+			t.Errorf("%s: supply grows after the last halving", params.Name)
+		}
+	}
+
+	uncapped := ChainParams{InitialSubsidy: 10}
+
+	if uncapped.MaxSupply() != 0 || uncapped.SupplyAt(10) != 100 {
+		t.Fatal("bad supply of an uncapped chain")
+	}
+}
+
+// A transaction signed for a chain cannot be replayed on another
+func TestTransactionOfAnotherChain(t *testing.T) {
+	bc := newTestBlockchain(MainParams)
+	wallet := newTestWallet(t, "main.key")
+	out := giveTestOut(bc, wallet, "out", 100, 0, false)
+
+	sign := func(chainID uint32) *Transaction {
+		tx, err := NewSignedTransaction([]TxIn{{PrevHash: out.TxHash}}, []TxOut{{Value: 100, Address: []byte("dest")}}, wallet, chainID)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return tx
+	}
+
+	if !sign(MainParams.ID).verifyInContext(bc, testContext(bc, 1, 0)) {
+		t.Fatal("transaction of the chain refused")
+	}
+
+	tx := sign(TestParams.ID)
+
+	if tx.verifyInContext(bc, testContext(bc, 1, 0)) {
+		t.Fatal("transaction signed for another chain accepted")
+	}
+
+	// The chain ID is signed
+	tx.ChainID = MainParams.ID
+
+	if tx.verifyInContext(bc, testContext(bc, 1, 0)) {
+		t.Fatal("transaction moved to another chain accepted")
+	}
+
+	tx.Stamp.Hash = tx.SigningHash()
+
+	if tx.verifyInContext(bc, testContext(bc, 1, 0)) {
+		t.Fatal("signature of another chain accepted")
+	}
+}
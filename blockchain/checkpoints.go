@@ -0,0 +1,97 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Parse a checkpoint of the form 'height:blockHash'
+func ParseCheckpoint(value string) (int64, []byte, error) {
+	splited := strings.Split(value, ":")
+
+	if len(splited) != 2 {
+		return 0, nil, errors.New("Bad checkpoint format, must be 'height:blockHash'")
+	}
+
+	height, err := strconv.ParseInt(splited[0], 10, 64)
+
+	if err != nil || height <= 0 {
+		return 0, nil, errors.New("Invalid checkpoint height: " + splited[0])
+	}
+
+	hash, err := hex.DecodeString(splited[1])
+
+	if err != nil || len(hash) != len(NewHash(nil)) {
+		return 0, nil, errors.New("Invalid checkpoint hash: " + splited[1])
+	}
+
+	return height, hash, nil
+}
+
+// False if the chain has a checkpoint at `height` with another hash
+func (this *ChainParams) CheckCheckpoint(height int64, hash []byte) bool {
+	checkpoint, ok := this.Checkpoints[height]
+
+	return !ok || compare(checkpoint, hash) == 0
+}
+
+// Remember the ancestors of the assumed valid block among the headers, whichever
+// way they came: the assumed valid block itself, or a header already known as one of
+// its ancestors, and the headers it follows. Their blocks are added without checking
+// their signatures
+func (this *Blockchain) assumeValid(headers []BlockHeader) {
+	if this.params.AssumeValid == nil {
+		return
+	}
+
+	this.assumedLock.Lock()
+	defer this.assumedLock.Unlock()
+
+	byHash := make(map[string]*BlockHeader)
+	var last *BlockHeader
+
+	for i := range headers {
+		header := &headers[i]
+
+		byHash[string(header.Hash)] = header
+
+		known, ok := this.assumedValid[header.Height]
+
+		if compare(header.Hash, this.params.AssumeValid) == 0 || (ok && compare(known, header.Hash) == 0) {
+			last = header
+		}
+	}
+
+	if last == nil {
+		return
+	}
+
+	for header := last; header != nil; header = byHash[string(header.PrecHash)] {
+		this.assumedValid[header.Height] = header.Hash
+	}
+
+	if compare(last.Hash, this.params.AssumeValid) == 0 {
+		this.logger.Info("Assuming valid the signatures up to block", last.Height)
+	}
+}
+
+// Whether the block is an ancestor of the assumed valid block, or that block itself,
+// and follows our headers. Must be called with the lock held
+func (this *Blockchain) isAssumedValid(header *BlockHeader) bool {
+	this.assumedLock.RLock()
+	defer this.assumedLock.RUnlock()
+
+	hash, ok := this.assumedValid[header.Height]
+
+	if !ok || compare(hash, header.Hash) != 0 {
+		return false
+	}
+
+	if header.Height < 1 || header.Height > int64(len(this.headers)) {
+		return false
+	}
+
+	return compare(this.headers[header.Height-1].Hash, header.PrecHash) == 0
+}
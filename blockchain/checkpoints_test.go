@@ -0,0 +1,105 @@
+package blockchain
+
+import "testing"
+
+// Headers following `prec`, linked by their hashes
+func newTestHeaders(prec *BlockHeader, n int, nonce int64) []BlockHeader {
+	headers := []BlockHeader{}
+
+	for i := 0; i < n; i++ {
+		header := BlockHeader{
+			Version:  BLOCK_VERSION,
+			Height:   prec.Height + 1,
+			PrecHash: prec.Hash,
+			Nonce:    nonce,
+		}
+
+		header.Hash = header.ComputeHash()
+		headers = append(headers, header)
+		prec = &headers[len(headers)-1]
+	}
+
+	return headers
+}
+
+func TestParseCheckpoint(t *testing.T) {
+	hash := "4d12e1c0bb09afd2c1c252654aa1e3a14ef06f508566d6b16fef6dbe956a4f80"
+
+	tests := []struct {
+		value string
+		ok    bool
+	}{
+		{"10:" + hash, true},
+		{"0:" + hash, false},
+		{"x:" + hash, false},
+		{"10:abcd", false},
+		{"10", false},
+	}
+
+	for _, test := range tests {
+		if _, _, err := ParseCheckpoint(test.value); (err == nil) != test.ok {
+			t.Errorf("%s: %v", test.value, err)
+		}
+	}
+}
+
+func TestCheckCheckpoint(t *testing.T) {
+	hash := NewHash([]byte("block"))
+	params := ChainParams{Checkpoints: map[int64][]byte{10: hash}}
+
+	if !params.CheckCheckpoint(9, []byte{1}) || params.CheckCheckpoint(10, []byte{1}) || !params.CheckCheckpoint(10, hash) {
+		t.Fatal("bad checkpoint check")
+	}
+}
+
+// The ancestry of the assumed valid block is built from any chunk of headers
+func TestAssumeValid(t *testing.T) {
+	params := RegtestParams
+	genesis := params.Genesis().Header
+	headers := newTestHeaders(&genesis, 5, 0)
+	params.AssumeValid = headers[3].Hash
+
+	bc := newTestBlockchain(params)
+
+	// The chunk with the assumed valid block, then the one before
+	bc.assumeValid(headers[2:5])
+	bc.assumeValid(headers[:3])
+
+	tests := []struct {
+		header *BlockHeader
+		ok     bool
+	}{
+		{&headers[0], true},
+		// Does not follow our headers yet
+		{&headers[1], false},
+		// After the assumed valid block
+		{&headers[4], false},
+	}
+
+	for i, test := range tests {
+		if bc.isAssumedValid(test.header) != test.ok {
+			t.Errorf("%d: assumed valid: %v", i, !test.ok)
+		}
+	}
+
+	bc.headers = append(bc.headers, headers[:3]...)
+
+	if !bc.isAssumedValid(&headers[3]) {
+		t.Fatal("assumed valid block not assumed valid")
+	}
+
+	// A fork at the same height
+	fork := newTestHeaders(&headers[2], 1, 1)
+
+	if bc.isAssumedValid(&fork[0]) {
+		t.Fatal("fork assumed valid")
+	}
+
+	// Headers unrelated to the assumed valid block are ignored
+	other := newTestBlockchain(params)
+	other.assumeValid(headers[:3])
+
+	if len(other.assumedValid) != 0 {
+		t.Fatal("headers not leading to the assumed valid block remembered")
+	}
+}
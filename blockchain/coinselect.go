@@ -0,0 +1,160 @@
+package blockchain
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+)
+
+const (
+	COIN_SELECTION_BNB      = "bnb"
+	COIN_SELECTION_LARGEST  = "largest"
+	COIN_SELECTION_SMALLEST = "smallest"
+	COIN_SELECTION_RANDOM   = "random"
+)
+
+// Max number of branches explored before giving up on an exact match
+var BNB_MAX_TRIES = 100000
+
+// A CoinSelector picks some outs from `unspents` whose total is at least `value`.
+// It returns an empty slice if it cannot reach the value
+type CoinSelector func(unspents []UnspentTxOut, value int) []UnspentTxOut
+
+var coinSelectors = map[string]CoinSelector{
+	COIN_SELECTION_BNB:      SelectBranchAndBound,
+	COIN_SELECTION_LARGEST:  SelectLargestFirst,
+	COIN_SELECTION_SMALLEST: SelectSmallestFirst,
+	COIN_SELECTION_RANDOM:   SelectRandom,
+}
+
+// Returns the selector registered under given name.
+// An empty name gives the default branch and bound selector
+func GetCoinSelector(name string) (CoinSelector, error) {
+	if len(name) == 0 {
+		name = COIN_SELECTION_BNB
+	}
+
+	selector, ok := coinSelectors[name]
+
+	if !ok {
+		return nil, errors.New("Unknown coin selection strategy: " + name)
+	}
+
+	return selector, nil
+}
+
+// Try to find a set of outs matching exactly the value, to avoid creating a change out.
+// Fallback to largest first if there is no such set
+func SelectBranchAndBound(unspents []UnspentTxOut, value int) []UnspentTxOut {
+	sorted := sortUnspents(unspents, true)
+
+	// remaining[i] is the total of sorted[i:]
+	remaining := make([]int, len(sorted)+1)
+	for i := len(sorted) - 1; i >= 0; i-- {
+		remaining[i] = remaining[i+1] + sorted[i].Out.Value
+	}
+
+	selected := make([]bool, len(sorted))
+	tries := 0
+
+	var search func(depth, total int) bool
+
+	search = func(depth, total int) bool {
+		tries++
+
+		if total == value {
+			return true
+		}
+
+		if tries > BNB_MAX_TRIES || total > value || depth >= len(sorted) || total+remaining[depth] < value {
+			return false
+		}
+
+		selected[depth] = true
+
+		if search(depth+1, total+sorted[depth].Out.Value) {
+			return true
+		}
+
+		selected[depth] = false
+
+		// Omitting an out equal to an already omitted one leads to the same sums
+		next := depth + 1
+		for next < len(sorted) && sorted[next].Out.Value == sorted[depth].Out.Value {
+			next++
+		}
+
+		return search(next, total)
+	}
+
+	if !search(0, 0) {
+		return SelectLargestFirst(unspents, value)
+	}
+
+	res := []UnspentTxOut{}
+
+	for i, unspent := range sorted {
+		if selected[i] {
+			res = append(res, unspent)
+		}
+	}
+
+	return res
+}
+
+// Use as few outs as possible
+func SelectLargestFirst(unspents []UnspentTxOut, value int) []UnspentTxOut {
+	return accumulateUnspents(sortUnspents(unspents, true), value)
+}
+
+// Use as many small outs as possible, consolidating the wallet on the way
+func SelectSmallestFirst(unspents []UnspentTxOut, value int) []UnspentTxOut {
+	return accumulateUnspents(sortUnspents(unspents, false), value)
+}
+
+// Pick outs in a random order, so the selection does not leak the wallet structure
+func SelectRandom(unspents []UnspentTxOut, value int) []UnspentTxOut {
+	shuffled := make([]UnspentTxOut, len(unspents))
+
+	for i, j := range rand.Perm(len(unspents)) {
+		shuffled[i] = unspents[j]
+	}
+
+	return accumulateUnspents(shuffled, value)
+}
+
+func sortUnspents(unspents []UnspentTxOut, desc bool) []UnspentTxOut {
+	sorted := make([]UnspentTxOut, len(unspents))
+	copy(sorted, unspents)
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if desc {
+			return sorted[i].Out.Value > sorted[j].Out.Value
+		}
+
+		return sorted[i].Out.Value < sorted[j].Out.Value
+	})
+
+	return sorted
+}
+
+func accumulateUnspents(unspents []UnspentTxOut, value int) []UnspentTxOut {
+	res := []UnspentTxOut{}
+
+	total := 0
+	for _, unspent := range unspents {
+		if total >= value {
+			break
+		}
+
+		total += unspent.Out.Value
+
+		res = append(res, unspent)
+	}
+
+	if total < value {
+		return []UnspentTxOut{}
+	}
+
+	return res
+}
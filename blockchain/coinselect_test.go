@@ -0,0 +1,85 @@
+package blockchain
+
+import (
+	"testing"
+)
+
+func newTestUnspents(values ...int) []UnspentTxOut {
+	unspents := []UnspentTxOut{}
+
+	for i, value := range values {
+		unspents = append(unspents, UnspentTxOut{Out: TxOut{Value: value}, InIdx: i})
+	}
+
+	return unspents
+}
+
+func totalValue(unspents []UnspentTxOut) int {
+	total := 0
+
+	for _, unspent := range unspents {
+		total += unspent.Out.Value
+	}
+
+	return total
+}
+
+func TestCoinSelectors(t *testing.T) {
+	tests := []struct {
+		name     string
+		selector CoinSelector
+		values   []int
+		value    int
+		total    int
+		count    int
+	}{
+		{"bnb exact match", SelectBranchAndBound, []int{5, 10, 3, 7}, 15, 15, 2},
+		{"bnb single out", SelectBranchAndBound, []int{5, 10, 3}, 10, 10, 1},
+		{"bnb falls back to largest", SelectBranchAndBound, []int{5, 10, 3}, 11, 15, 2},
+		{"bnb not enough", SelectBranchAndBound, []int{5, 10, 3}, 19, 0, 0},
+		{"largest first", SelectLargestFirst, []int{5, 10, 3}, 12, 15, 2},
+		{"smallest first", SelectSmallestFirst, []int{5, 10, 3}, 8, 8, 2},
+		{"smallest first over", SelectSmallestFirst, []int{5, 10, 3}, 9, 18, 3},
+		{"random not enough", SelectRandom, []int{5, 10, 3}, 100, 0, 0},
+		{"random everything", SelectRandom, []int{5, 10, 3}, 18, 18, 3},
+	}
+
+	for _, test := range tests {
+		selected := test.selector(newTestUnspents(test.values...), test.value)
+
+		if totalValue(selected) != test.total || len(selected) != test.count {
+			t.Errorf("%s: selected %d outs of total %d, expected %d of %d", test.name, len(selected), totalValue(selected), test.count, test.total)
+		}
+	}
+}
+
+// Equal outs do not blow up the search
+func TestBranchAndBoundManyOuts(t *testing.T) {
+	values := []int{}
+
+	for i := 0; i < 5000; i++ {
+		values = append(values, 100)
+	}
+
+	values = append(values, 37, 250)
+
+	if selected := SelectBranchAndBound(newTestUnspents(values...), 1337); totalValue(selected) != 1337 {
+		t.Fatal("no exact match, total", totalValue(selected))
+	}
+
+	if selected := SelectBranchAndBound(newTestUnspents(values...), 1338); totalValue(selected) < 1338 {
+		t.Fatal("not enough selected, total", totalValue(selected))
+	}
+}
+
+func TestGetCoinSelector(t *testing.T) {
+	for _, name := range []string{"", COIN_SELECTION_BNB, COIN_SELECTION_LARGEST, COIN_SELECTION_SMALLEST, COIN_SELECTION_RANDOM} {
+		if _, err := GetCoinSelector(name); err != nil {
+			t.Errorf("%q: %v", name, err)
+		}
+	}
+
+	if _, err := GetCoinSelector("unknown"); err == nil {
+		t.Fatal("unknown selector found")
+	}
+}
@@ -0,0 +1,242 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A data out carries bytes instead of coins. It has no value and no address,
+// so it can never be spent and never enters the unspent outs
+func NewDataOut(data []byte) TxOut {
+	return TxOut{
+		Value:   0,
+		Address: []byte{},
+		Data:    data,
+	}
+}
+
+func (this *TxOut) IsData() bool {
+	return len(this.Data) > 0
+}
+
+// The data attached to the transaction, if any
+func (this *Transaction) GetData() []byte {
+	for _, out := range this.Outs {
+		if out.IsData() {
+			return out.Data
+		}
+	}
+
+	return nil
+}
+
+// Where a data out was mined
+type DataRef struct {
+	Data      string `json:"data"`
+	TxHash    string `json:"txHash"`
+	Height    int64  `json:"height"`
+	BlockHash string `json:"blockHash"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// Data outs of the chain, indexed by their hex encoded data
+type DataIndex struct {
+	sync.RWMutex
+	refs map[string][]DataRef
+	keys []string
+}
+
+func NewDataIndex(refs []DataRef) *DataIndex {
+	index := &DataIndex{
+		refs: make(map[string][]DataRef),
+	}
+
+	for _, ref := range refs {
+		index.Add(ref)
+	}
+
+	return index
+}
+
+func (this *DataIndex) Add(ref DataRef) {
+	this.Lock()
+	defer this.Unlock()
+
+	refs, ok := this.refs[ref.Data]
+
+	for _, existing := range refs {
+		if existing.TxHash == ref.TxHash {
+			return
+		}
+	}
+
+	this.refs[ref.Data] = append(refs, ref)
+
+	if ok {
+		return
+	}
+
+	// Keep the keys sorted to look them up by prefix
+	i := sort.SearchStrings(this.keys, ref.Data)
+
+	this.keys = append(this.keys, "")
+	copy(this.keys[i+1:], this.keys[i:])
+	this.keys[i] = ref.Data
+}
+
+func (this *DataIndex) Remove(ref DataRef) {
+	this.Lock()
+	defer this.Unlock()
+
+	refs := this.refs[ref.Data]
+
+	for i, existing := range refs {
+		if existing.TxHash == ref.TxHash {
+			refs = append(refs[:i], refs[i+1:]...)
+
+			break
+		}
+	}
+
+	if len(refs) > 0 {
+		this.refs[ref.Data] = refs
+
+		return
+	}
+
+	delete(this.refs, ref.Data)
+
+	if i := sort.SearchStrings(this.keys, ref.Data); i < len(this.keys) && this.keys[i] == ref.Data {
+		this.keys = append(this.keys[:i], this.keys[i+1:]...)
+	}
+}
+
+// Data outs whose hex encoded data starts with `prefix`, most recent first.
+// A limit <= 0 means no limit
+func (this *DataIndex) Search(prefix string, limit int) []DataRef {
+	this.RLock()
+	defer this.RUnlock()
+
+	prefix = strings.ToLower(prefix)
+	res := []DataRef{}
+
+	for i := sort.SearchStrings(this.keys, prefix); i < len(this.keys); i++ {
+		if !strings.HasPrefix(this.keys[i], prefix) {
+			break
+		}
+
+		res = append(res, this.refs[this.keys[i]]...)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Height != res[j].Height {
+			return res[i].Height > res[j].Height
+		}
+
+		return res[i].TxHash < res[j].TxHash
+	})
+
+	if limit > 0 && len(res) > limit {
+		res = res[:limit]
+	}
+
+	return res
+}
+
+func (this *DataIndex) All() []DataRef {
+	this.RLock()
+	defer this.RUnlock()
+
+	res := []DataRef{}
+
+	for _, key := range this.keys {
+		res = append(res, this.refs[key]...)
+	}
+
+	return res
+}
+
+// Search the data outs by hex prefix, or by text if the query is not hex. Only the
+// outs of blocks still in our chain are found
+func (this *Blockchain) SearchData(query string, limit int) []DataRef {
+	this.RLock()
+	defer this.RUnlock()
+
+	if !isHex(query) {
+		query = hex.EncodeToString([]byte(query))
+	}
+
+	res := []DataRef{}
+
+	for _, ref := range this.dataIndex.Search(query, 0) {
+		if limit > 0 && len(res) >= limit {
+			break
+		}
+
+		if this.isInChain(&ref) {
+			res = append(res, ref)
+		}
+	}
+
+	return res
+}
+
+// Whether the block of the data out is still in our chain, and not one replaced
+// by a reorg. Refs stored without their block hash only need a known height.
+// Must be called with the lock held
+func (this *Blockchain) isInChain(ref *DataRef) bool {
+	if ref.Height <= 0 || ref.Height > this.BlocksHeight() {
+		return false
+	}
+
+	return len(ref.BlockHash) == 0 || ref.BlockHash == hex.EncodeToString(this.headers[ref.Height].Hash)
+}
+
+// Drop the data outs of the blocks that left our chain. Must be called with the
+// lock held
+func (this *Blockchain) pruneDataIndex() {
+	for _, ref := range this.dataIndex.All() {
+		if !this.isInChain(&ref) {
+			this.dataIndex.Remove(ref)
+		}
+	}
+}
+
+func (this *Blockchain) indexData(block *Block, tx *Transaction) {
+	data := tx.GetData()
+
+	if data == nil {
+		return
+	}
+
+	this.dataIndex.Add(DataRef{
+		Data:      hex.EncodeToString(data),
+		TxHash:    hex.EncodeToString(tx.Stamp.Hash),
+		Height:    block.Header.Height,
+		BlockHash: hex.EncodeToString(block.Header.Hash),
+		Timestamp: block.Header.Timestamp,
+	})
+}
+
+// Printable form of some data: the text if it is, hex otherwise
+func DataString(data []byte) string {
+	for _, c := range string(data) {
+		if c < ' ' || c == 0x7f || c == 0xfffd {
+			return hex.EncodeToString(data)
+		}
+	}
+
+	return string(data)
+}
+
+func isHex(value string) bool {
+	for _, c := range strings.ToLower(value) {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+
+	return len(value) > 0
+}
@@ -0,0 +1,160 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+// The max size of the data outs is a rule of the chain
+func TestDataOutSize(t *testing.T) {
+	custom := RegtestParams
+	custom.MaxDataSize = 10
+
+	tests := []struct {
+		params ChainParams
+		size   int
+		ok     bool
+	}{
+		{MainParams, 80, true},
+		{MainParams, 81, false},
+		{custom, 10, true},
+		{custom, 11, false},
+	}
+
+	for i, test := range tests {
+		bc := newTestBlockchain(test.params)
+		wallet := newTestWallet(t, "main.key")
+		out := giveTestOut(bc, wallet, "out", 100, 0, false)
+
+		data := NewDataOut(bytes.Repeat([]byte("x"), test.size))
+		tx, err := NewSignedTransaction([]TxIn{{PrevHash: out.TxHash}}, []TxOut{data}, wallet, bc.params.ID)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if tx.verifyInContext(bc, testContext(bc, 1, 0)) != test.ok {
+			t.Errorf("%d: %d bytes of data on %s accepted: %v", i, test.size, test.params.Name, !test.ok)
+		}
+
+		bc.wallets["main.key"] = wallet
+
+		if _, err := bc.SendTo(nil, SendOptions{Data: data.Data}); (err == nil) != test.ok {
+			t.Errorf("%d: sending %d bytes of data on %s: %v", i, test.size, test.params.Name, err)
+		}
+	}
+}
+
+func TestDataIndexOnBlock(t *testing.T) {
+	bc := newTestNode(t, RegtestParams)
+
+	mineTestBlock(t, bc)
+
+	for _, memo := range []string{"hello", "help", "other"} {
+		if _, err := bc.SendTo(nil, SendOptions{Data: []byte(memo)}); err != nil {
+			t.Fatal(err)
+		}
+
+		mineTestBlock(t, bc)
+	}
+
+	tests := []struct {
+		query   string
+		limit   int
+		heights []int64
+	}{
+		{"hel", 0, []int64{3, 2}},
+		{"hel", 1, []int64{3}},
+		{"hello", 0, []int64{2}},
+		{hex.EncodeToString([]byte("oth")), 0, []int64{4}},
+		{"nothing", 0, []int64{}},
+	}
+
+	for _, test := range tests {
+		heights := []int64{}
+
+		for _, ref := range bc.SearchData(test.query, test.limit) {
+			heights = append(heights, ref.Height)
+		}
+
+		if !reflect.DeepEqual(heights, test.heights) {
+			t.Errorf("%q: found at %v, want %v", test.query, heights, test.heights)
+		}
+	}
+
+	// Pending data is not indexed
+	if _, err := bc.SendTo(nil, SendOptions{Data: []byte("pending")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(bc.SearchData("pending", 0)) != 0 {
+		t.Fatal("pending data found")
+	}
+}
+
+func TestDataIndexReorg(t *testing.T) {
+	bc := newTestNode(t, RegtestParams)
+
+	mineTestBlock(t, bc)
+
+	if _, err := bc.SendTo(nil, SendOptions{Data: []byte("hello")}); err != nil {
+		t.Fatal(err)
+	}
+
+	mineTestBlock(t, bc)
+
+	// Refs stored before the block hash was, still in the chain or not
+	bc.dataIndex.Add(DataRef{Data: hex.EncodeToString([]byte("old")), TxHash: "aa", Height: 1})
+	bc.dataIndex.Add(DataRef{Data: hex.EncodeToString([]byte("older")), TxHash: "bb", Height: 3})
+
+	if len(bc.SearchData("hello", 0)) != 1 || len(bc.SearchData("old", 0)) != 1 {
+		t.Fatal("data not found")
+	}
+
+	// Another block replaces the one with the data
+	other := bc.headers[2]
+	other.Nonce++
+	other.Hash = other.ComputeHash()
+	bc.headers[2] = other
+
+	if len(bc.SearchData("hello", 0)) != 0 {
+		t.Fatal("data of a replaced block found")
+	}
+
+	bc.pruneDataIndex()
+
+	refs := bc.dataIndex.All()
+
+	if len(refs) != 1 || refs[0].TxHash != "aa" {
+		t.Fatal("bad index once pruned:", refs)
+	}
+}
+
+func TestDataIndexRemove(t *testing.T) {
+	index := NewDataIndex([]DataRef{
+		{Data: "aa", TxHash: "1"},
+		{Data: "aa", TxHash: "2"},
+		{Data: "ab", TxHash: "3"},
+	})
+
+	index.Remove(DataRef{Data: "aa", TxHash: "1"})
+
+	if refs := index.Search("a", 0); len(refs) != 2 {
+		t.Fatal("bad refs once one is removed:", refs)
+	}
+
+	index.Remove(DataRef{Data: "aa", TxHash: "2"})
+	index.Remove(DataRef{Data: "cc", TxHash: "4"})
+
+	if refs := index.Search("a", 0); len(refs) != 1 || refs[0].TxHash != "3" {
+		t.Fatal("bad refs once a data is removed:", refs)
+	}
+
+	index.Add(DataRef{Data: "a0", TxHash: "5"})
+
+	if refs := index.Search("a", 0); len(refs) != 2 || !reflect.DeepEqual(index.keys, []string{"a0", "ab"}) {
+		t.Fatal("keys not sorted once removed:", index.keys)
+	}
+}
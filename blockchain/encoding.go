@@ -0,0 +1,501 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strconv"
+)
+
+// Canonical binary encoding of the consensus structures, see docs/encoding.md.
+// Hashes and signatures are computed over it, so it must never change for a
+// given version: new fields need a new version.
+
+var (
+	// Version of the block header format, first field of every header.
+	// Version 2 added the UTXO root
+	BLOCK_VERSION uint32 = 2
+
+	// Version of the transaction format, first field of every transaction.
+	// Version 2 added the chain ID
+	TX_VERSION uint32 = 2
+
+	// Version of the hash time-locked contract format, first field of every contract
+	HTLC_VERSION uint32 = 1
+)
+
+type encoder struct {
+	buf bytes.Buffer
+}
+
+func (this *encoder) uint32(v uint32) {
+	var b [4]byte
+
+	binary.BigEndian.PutUint32(b[:], v)
+	this.buf.Write(b[:])
+}
+
+func (this *encoder) int64(v int64) {
+	var b [8]byte
+
+	binary.BigEndian.PutUint64(b[:], uint64(v))
+	this.buf.Write(b[:])
+}
+
+func (this *encoder) bool(v bool) {
+	if v {
+		this.buf.WriteByte(1)
+	} else {
+		this.buf.WriteByte(0)
+	}
+}
+
+func (this *encoder) bytes(v []byte) {
+	this.uint32(uint32(len(v)))
+	this.buf.Write(v)
+}
+
+func (this *encoder) Bytes() []byte {
+	return this.buf.Bytes()
+}
+
+// Reads what encoder writes. The first error stops the decoding, following
+// reads return zero values
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (this *decoder) fail(msg string) {
+	if this.err == nil {
+		this.err = errors.New(msg)
+	}
+}
+
+func (this *decoder) next(n int) []byte {
+	if this.err != nil {
+		return nil
+	}
+
+	if n > len(this.data) {
+		this.fail("Unexpected end of data")
+
+		return nil
+	}
+
+	res := this.data[:n]
+	this.data = this.data[n:]
+
+	return res
+}
+
+func (this *decoder) uint32() uint32 {
+	b := this.next(4)
+
+	if b == nil {
+		return 0
+	}
+
+	return binary.BigEndian.Uint32(b)
+}
+
+func (this *decoder) int64() int64 {
+	b := this.next(8)
+
+	if b == nil {
+		return 0
+	}
+
+	return int64(binary.BigEndian.Uint64(b))
+}
+
+func (this *decoder) bool() bool {
+	b := this.next(1)
+
+	if b == nil {
+		return false
+	}
+
+	if b[0] > 1 {
+		this.fail("Bad boolean")
+	}
+
+	return b[0] == 1
+}
+
+func (this *decoder) bytes() []byte {
+	size := this.uint32()
+
+	if uint64(size) > uint64(len(this.data)) {
+		this.fail("Unexpected end of data")
+
+		return nil
+	}
+
+	return append([]byte{}, this.next(int(size))...)
+}
+
+// Number of elements of a list. Each takes at least `minSize` bytes, so a bad
+// count cannot make us allocate more than the data size
+func (this *decoder) count(minSize int) int {
+	n := this.uint32()
+
+	if uint64(n)*uint64(minSize) > uint64(len(this.data)) {
+		this.fail("Bad element count")
+
+		return 0
+	}
+
+	return int(n)
+}
+
+func (this *decoder) end() error {
+	if this.err == nil && len(this.data) > 0 {
+		this.fail("Trailing data")
+	}
+
+	return this.err
+}
+
+// The header hash is the hash of this encoding, so it is not part of it
+func EncodeHeader(header *BlockHeader) []byte {
+	enc := &encoder{}
+
+	encodeHeader(enc, header)
+
+	return enc.Bytes()
+}
+
+func encodeHeader(enc *encoder, header *BlockHeader) {
+	enc.uint32(header.Version)
+	enc.int64(header.Height)
+	enc.bytes(header.PrecHash)
+	enc.bytes(header.MerkelHash)
+	enc.bytes(header.UtxoRoot)
+	enc.bytes(header.Target)
+	enc.int64(header.Timestamp)
+	enc.int64(header.Nonce)
+}
+
+func DecodeHeader(data []byte) (*BlockHeader, error) {
+	dec := &decoder{data: data}
+
+	header := decodeHeader(dec)
+
+	if err := dec.end(); err != nil {
+		return nil, errors.New("Bad header: " + err.Error())
+	}
+
+	header.Hash = header.ComputeHash()
+
+	return header, nil
+}
+
+func decodeHeader(dec *decoder) *BlockHeader {
+	header := &BlockHeader{}
+
+	header.Version = dec.uint32()
+
+	if dec.err == nil && header.Version != BLOCK_VERSION {
+		dec.fail("Unknown block version " + strconv.FormatUint(uint64(header.Version), 10))
+	}
+
+	header.Height = dec.int64()
+	header.PrecHash = dec.bytes()
+	header.MerkelHash = dec.bytes()
+	header.UtxoRoot = dec.bytes()
+	header.Target = dec.bytes()
+	header.Timestamp = dec.int64()
+	header.Nonce = dec.int64()
+
+	return header
+}
+
+// A list of headers, used to sync them without their blocks
+func EncodeHeaders(headers []BlockHeader) []byte {
+	enc := &encoder{}
+
+	enc.uint32(uint32(len(headers)))
+
+	for i := range headers {
+		encodeHeader(enc, &headers[i])
+	}
+
+	return enc.Bytes()
+}
+
+func DecodeHeaders(data []byte) ([]BlockHeader, error) {
+	dec := &decoder{data: data}
+
+	// A header takes at least 44 bytes
+	headers := make([]BlockHeader, dec.count(44))
+
+	for i := range headers {
+		headers[i] = *decodeHeader(dec)
+	}
+
+	if err := dec.end(); err != nil {
+		return nil, errors.New("Bad headers: " + err.Error())
+	}
+
+	for i := range headers {
+		headers[i].Hash = headers[i].ComputeHash()
+	}
+
+	return headers, nil
+}
+
+// The stamp hash is the signing hash, so it is not part of the encoding
+func EncodeTransaction(tx *Transaction) []byte {
+	enc := &encoder{}
+
+	encodeTransaction(enc, tx, true)
+
+	return enc.Bytes()
+}
+
+// Without `withSigs`, the signatures are left out: the stamp R and S, the multisig
+// signatures and the unlocking scripts
+func encodeTransaction(enc *encoder, tx *Transaction, withSigs bool) {
+	enc.uint32(TX_VERSION)
+	enc.uint32(tx.ChainID)
+
+	enc.uint32(uint32(len(tx.Ins)))
+
+	for i := range tx.Ins {
+		encodeTxIn(enc, &tx.Ins[i], withSigs)
+	}
+
+	enc.uint32(uint32(len(tx.Outs)))
+
+	for i := range tx.Outs {
+		encodeTxOut(enc, &tx.Outs[i])
+	}
+
+	enc.bool(tx.Replaceable)
+	enc.int64(tx.LockTime)
+
+	enc.bytes(tx.Stamp.Pub)
+	enc.int64(tx.Stamp.Timestamp)
+
+	if withSigs {
+		enc.bytes(tx.Stamp.R)
+		enc.bytes(tx.Stamp.S)
+	} else {
+		enc.bytes(nil)
+		enc.bytes(nil)
+	}
+}
+
+func encodeTxIn(enc *encoder, in *TxIn, withSigs bool) {
+	enc.bytes(in.PrevHash)
+	enc.int64(int64(in.PrevIdx))
+	enc.int64(in.Sequence)
+
+	enc.bool(in.Multisig != nil)
+
+	if in.Multisig != nil {
+		encodeMultisig(enc, &in.Multisig.Multisig)
+
+		if withSigs {
+			enc.uint32(uint32(len(in.Multisig.Sigs)))
+
+			for _, sig := range in.Multisig.Sigs {
+				enc.int64(int64(sig.Idx))
+				enc.bytes(sig.R)
+				enc.bytes(sig.S)
+			}
+		} else {
+			enc.uint32(0)
+		}
+	}
+
+	enc.bool(in.Script != nil)
+
+	if in.Script != nil {
+		enc.bytes(in.Script.Lock)
+
+		if withSigs {
+			enc.bytes(in.Script.Unlock)
+		} else {
+			enc.bytes(nil)
+		}
+	}
+}
+
+func encodeTxOut(enc *encoder, out *TxOut) {
+	enc.int64(int64(out.Value))
+	enc.bytes(out.Address)
+	enc.bytes(out.Data)
+}
+
+func encodeMultisig(enc *encoder, multisig *Multisig) {
+	enc.int64(int64(multisig.M))
+	enc.uint32(uint32(len(multisig.Pubs)))
+
+	for _, pub := range multisig.Pubs {
+		enc.bytes(pub)
+	}
+}
+
+func DecodeTransaction(data []byte) (*Transaction, error) {
+	dec := &decoder{data: data}
+
+	tx := decodeTransaction(dec)
+
+	if err := dec.end(); err != nil {
+		return nil, errors.New("Bad transaction: " + err.Error())
+	}
+
+	tx.Stamp.Hash = tx.SigningHash()
+
+	return tx, nil
+}
+
+func decodeTransaction(dec *decoder) *Transaction {
+	tx := &Transaction{}
+
+	version := dec.uint32()
+
+	if dec.err == nil && version != TX_VERSION {
+		dec.fail("Unknown transaction version " + strconv.FormatUint(uint64(version), 10))
+	}
+
+	tx.ChainID = dec.uint32()
+
+	// An in takes at least 22 bytes and an out 16
+	tx.Ins = make([]TxIn, dec.count(22))
+
+	for i := range tx.Ins {
+		tx.Ins[i] = decodeTxIn(dec)
+	}
+
+	tx.Outs = make([]TxOut, dec.count(16))
+
+	for i := range tx.Outs {
+		tx.Outs[i] = decodeTxOut(dec)
+	}
+
+	tx.Replaceable = dec.bool()
+	tx.LockTime = dec.int64()
+
+	tx.Stamp.Pub = dec.bytes()
+	tx.Stamp.Timestamp = dec.int64()
+	tx.Stamp.R = dec.bytes()
+	tx.Stamp.S = dec.bytes()
+
+	return tx
+}
+
+func decodeTxIn(dec *decoder) TxIn {
+	in := TxIn{}
+
+	in.PrevHash = dec.bytes()
+	in.PrevIdx = int(dec.int64())
+	in.Sequence = dec.int64()
+
+	if dec.bool() {
+		spend := &MultisigSpend{
+			Multisig: decodeMultisig(dec),
+		}
+
+		sigs := dec.count(16)
+
+		for i := 0; i < sigs; i++ {
+			spend.Sigs = append(spend.Sigs, MultisigSig{
+				Idx: int(dec.int64()),
+				R:   dec.bytes(),
+				S:   dec.bytes(),
+			})
+		}
+
+		in.Multisig = spend
+	}
+
+	if dec.bool() {
+		in.Script = &ScriptSpend{
+			Lock:   dec.bytes(),
+			Unlock: dec.bytes(),
+		}
+
+		if len(in.Script.Unlock) == 0 {
+			in.Script.Unlock = nil
+		}
+	}
+
+	return in
+}
+
+func decodeTxOut(dec *decoder) TxOut {
+	out := TxOut{}
+
+	out.Value = int(dec.int64())
+	out.Address = dec.bytes()
+	out.Data = dec.bytes()
+
+	if len(out.Data) == 0 {
+		out.Data = nil
+	}
+
+	return out
+}
+
+func decodeMultisig(dec *decoder) Multisig {
+	multisig := Multisig{}
+
+	multisig.M = int(dec.int64())
+	multisig.Pubs = make([][]byte, dec.count(4))
+
+	for i := range multisig.Pubs {
+		multisig.Pubs[i] = dec.bytes()
+	}
+
+	return multisig
+}
+
+// A block is its header followed by its transactions, each prefixed by its size
+func EncodeBlock(block *Block) []byte {
+	enc := &encoder{}
+
+	encodeHeader(enc, &block.Header)
+
+	enc.uint32(uint32(len(block.Transactions)))
+
+	for i := range block.Transactions {
+		enc.bytes(EncodeTransaction(&block.Transactions[i]))
+	}
+
+	return enc.Bytes()
+}
+
+func DecodeBlock(data []byte) (*Block, error) {
+	dec := &decoder{data: data}
+
+	header := decodeHeader(dec)
+	txs := make([]Transaction, dec.count(4))
+
+	for i := range txs {
+		tx, err := DecodeTransaction(dec.bytes())
+
+		if dec.err != nil {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		txs[i] = *tx
+	}
+
+	if err := dec.end(); err != nil {
+		return nil, errors.New("Bad block: " + err.Error())
+	}
+
+	header.Hash = header.ComputeHash()
+
+	return &Block{
+		Header:       *header,
+		Transactions: txs,
+	}, nil
+}
@@ -0,0 +1,360 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// Test vectors of docs/encoding.md
+const (
+	HEADER_VECTOR = "00000002000000000000000100000020aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa" +
+		"00000020bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb00000020cccccccccccccccc" +
+		"cccccccccccccccccccccccccccccccccccccccccccccccc0000000200ff0000000059682f000000000000000007"
+	HEADER_VECTOR_HASH = "4d12e1c0bb09afd2c1c252654aa1e3a14ef06f508566d6b16fef6dbe956a4f80"
+
+	TX_VECTOR = "00000002c47d000100000001000000201111111111111111111111111111111111111111111111111111111111111111" +
+		"00000000000000010000000000000005000000000002000000000000002a000000026162000000000000000000000000" +
+		"00000000000000026869010000000000000064000000037075620000000059682f00000000020102000000020304"
+	TX_VECTOR_HASH = "f33e31df99b438620ffa72e3ee2a797d516b83481194c2540a866c5b30115534"
+)
+
+func vectorHeader() *BlockHeader {
+	return &BlockHeader{
+		Version:    BLOCK_VERSION,
+		Height:     1,
+		PrecHash:   bytes.Repeat([]byte{0xaa}, 32),
+		MerkelHash: bytes.Repeat([]byte{0xbb}, 32),
+		UtxoRoot:   bytes.Repeat([]byte{0xcc}, 32),
+		Target:     []byte{0x00, 0xff},
+		Timestamp:  1500000000,
+		Nonce:      7,
+	}
+}
+
+func vectorTx() *Transaction {
+	return &Transaction{
+		ChainID: MainParams.ID,
+		Ins: []TxIn{{
+			PrevHash: bytes.Repeat([]byte{0x11}, 32),
+			PrevIdx:  1,
+			Sequence: 5,
+		}},
+		Outs: []TxOut{
+			{Value: 42, Address: []byte("ab")},
+			NewDataOut([]byte("hi")),
+		},
+		Replaceable: true,
+		LockTime:    100,
+		Stamp: Stamp{
+			Pub:       []byte("pub"),
+			Timestamp: 1500000000,
+			R:         []byte{1, 2},
+			S:         []byte{3, 4},
+		},
+	}
+}
+
+// Spends a multisig out and a script out, with their signatures
+func vectorSpendsTx() *Transaction {
+	return &Transaction{
+		ChainID: MainParams.ID,
+		Ins: []TxIn{
+			{
+				PrevHash: []byte{1},
+				Multisig: &MultisigSpend{
+					Multisig: Multisig{M: 1, Pubs: [][]byte{[]byte("key")}},
+					Sigs:     []MultisigSig{{Idx: 0, R: []byte{9}, S: []byte{8}}},
+				},
+			},
+			{PrevHash: []byte{2}, Script: &ScriptSpend{Lock: []byte{0x51}, Unlock: []byte{0x51}}},
+		},
+		Outs:  []TxOut{{Value: 1, Address: []byte("x")}},
+		Stamp: Stamp{Pub: []byte("pub"), R: []byte{1}, S: []byte{2}},
+	}
+}
+
+func TestHeaderVector(t *testing.T) {
+	header := vectorHeader()
+
+	if got := hex.EncodeToString(EncodeHeader(header)); got != HEADER_VECTOR {
+		t.Fatal("bad header encoding:", got)
+	}
+
+	if got := hex.EncodeToString(header.ComputeHash()); got != HEADER_VECTOR_HASH {
+		t.Fatal("bad header hash:", got)
+	}
+
+	data, _ := hex.DecodeString(HEADER_VECTOR)
+	decoded, err := DecodeHeader(data)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hex.EncodeToString(decoded.Hash) != HEADER_VECTOR_HASH {
+		t.Fatal("decoded header has another hash")
+	}
+}
+
+func TestTransactionVector(t *testing.T) {
+	tx := vectorTx()
+
+	if got := hex.EncodeToString(EncodeTransaction(tx)); got != TX_VECTOR {
+		t.Fatal("bad transaction encoding:", got)
+	}
+
+	if got := hex.EncodeToString(tx.SigningHash()); got != TX_VECTOR_HASH {
+		t.Fatal("bad transaction hash:", got)
+	}
+
+	data, _ := hex.DecodeString(TX_VECTOR)
+	decoded, err := DecodeTransaction(data)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hex.EncodeToString(decoded.Stamp.Hash) != TX_VECTOR_HASH {
+		t.Fatal("decoded transaction has another hash")
+	}
+}
+
+// The signatures of the multisig and script spends are not signed
+func TestSigningHashWithoutSigs(t *testing.T) {
+	tx := vectorSpendsTx()
+	hash := tx.SigningHash()
+
+	tx.Ins[0].Multisig.Sigs = nil
+	tx.Ins[1].Script.Unlock = nil
+
+	if compare(hash, tx.SigningHash()) != 0 {
+		t.Fatal("signatures are part of the signing hash")
+	}
+
+	tx.Ins[1].Script.Lock = []byte{0x52}
+
+	if compare(hash, tx.SigningHash()) == 0 {
+		t.Fatal("locking script is not part of the signing hash")
+	}
+}
+
+// Each encoding with its decoder, that re-encodes what it decoded
+var encodings = []struct {
+	name   string
+	encode func() []byte
+	decode func(data []byte) ([]byte, error)
+}{
+	{
+		"header",
+		func() []byte { return EncodeHeader(vectorHeader()) },
+		func(data []byte) ([]byte, error) {
+			header, err := DecodeHeader(data)
+
+			if err != nil {
+				return nil, err
+			}
+
+			return EncodeHeader(header), nil
+		},
+	},
+	{
+		"headers",
+		func() []byte { return EncodeHeaders([]BlockHeader{*vectorHeader(), *vectorHeader()}) },
+		func(data []byte) ([]byte, error) {
+			headers, err := DecodeHeaders(data)
+
+			if err != nil {
+				return nil, err
+			}
+
+			return EncodeHeaders(headers), nil
+		},
+	},
+	{
+		"transaction",
+		func() []byte { return EncodeTransaction(vectorTx()) },
+		func(data []byte) ([]byte, error) {
+			tx, err := DecodeTransaction(data)
+
+			if err != nil {
+				return nil, err
+			}
+
+			return EncodeTransaction(tx), nil
+		},
+	},
+	{
+		"spends",
+		func() []byte { return EncodeTransaction(vectorSpendsTx()) },
+		func(data []byte) ([]byte, error) {
+			tx, err := DecodeTransaction(data)
+
+			if err != nil {
+				return nil, err
+			}
+
+			return EncodeTransaction(tx), nil
+		},
+	},
+	{
+		"block",
+		func() []byte {
+			return EncodeBlock(&Block{Header: *vectorHeader(), Transactions: []Transaction{*vectorTx(), *vectorSpendsTx()}})
+		},
+		func(data []byte) ([]byte, error) {
+			block, err := DecodeBlock(data)
+
+			if err != nil {
+				return nil, err
+			}
+
+			return EncodeBlock(block), nil
+		},
+	},
+	{
+		"snapshot",
+		func() []byte {
+			return EncodeSnapshot(&Snapshot{
+				ChainID: MainParams.ID,
+				Height:  1,
+				TipHash: vectorHeader().ComputeHash(),
+				Headers: []BlockHeader{*vectorHeader()},
+				Outs: []UnspentTxOut{{
+					TxHash:     bytes.Repeat([]byte{0x11}, 32),
+					InIdx:      1,
+					Out:        TxOut{Value: 42, Address: []byte("ab")},
+					IsCoinbase: true,
+					Height:     1,
+				}},
+			})
+		},
+		func(data []byte) ([]byte, error) {
+			snapshot, err := DecodeSnapshot(data)
+
+			if err != nil {
+				return nil, err
+			}
+
+			return EncodeSnapshot(snapshot), nil
+		},
+	},
+	{
+		"proof",
+		func() []byte {
+			return EncodeTxProof(&TxProof{Height: 3, Tx: *vectorTx(), Index: 1, Branch: [][]byte{bytes.Repeat([]byte{0x22}, 32)}})
+		},
+		func(data []byte) ([]byte, error) {
+			proof, err := DecodeTxProof(data)
+
+			if err != nil {
+				return nil, err
+			}
+
+			return EncodeTxProof(proof), nil
+		},
+	},
+	{
+		"unspent proof",
+		func() []byte {
+			return EncodeUnspentProof(&UnspentProof{
+				Height:       3,
+				Out:          UnspentTxOut{TxHash: []byte{1}, Out: TxOut{Value: 42, Address: []byte("ab")}, Height: 2},
+				Index:        1,
+				Branch:       [][]byte{bytes.Repeat([]byte{0x22}, 32)},
+				BucketBranch: [][]byte{bytes.Repeat([]byte{0x33}, 32)},
+			})
+		},
+		func(data []byte) ([]byte, error) {
+			proof, err := DecodeUnspentProof(data)
+
+			if err != nil {
+				return nil, err
+			}
+
+			return EncodeUnspentProof(proof), nil
+		},
+	},
+	{
+		"contract",
+		func() []byte {
+			return EncodeHTLC(&HTLC{
+				Hash:      bytes.Repeat([]byte{0x44}, 32),
+				Recipient: bytes.Repeat([]byte{0x55}, 32),
+				Refund:    bytes.Repeat([]byte{0x66}, 32),
+				Timeout:   100,
+			})
+		},
+		func(data []byte) ([]byte, error) {
+			contract, err := DecodeHTLC(data)
+
+			if err != nil {
+				return nil, err
+			}
+
+			return EncodeHTLC(contract), nil
+		},
+	},
+}
+
+func TestEncodingRoundTrip(t *testing.T) {
+	for _, encoding := range encodings {
+		data := encoding.encode()
+		decoded, err := encoding.decode(data)
+
+		if err != nil {
+			t.Errorf("%s: %v", encoding.name, err)
+
+			continue
+		}
+
+		if !bytes.Equal(decoded, data) {
+			t.Errorf("%s: decoding changed the encoding", encoding.name)
+		}
+	}
+}
+
+func TestDecodeTruncated(t *testing.T) {
+	for _, encoding := range encodings {
+		data := encoding.encode()
+
+		for i := 0; i < len(data); i++ {
+			if _, err := encoding.decode(data[:i]); err == nil {
+				t.Errorf("%s: decoded from %d of %d bytes", encoding.name, i, len(data))
+
+				break
+			}
+		}
+
+		if _, err := encoding.decode(append(data, 0)); err == nil {
+			t.Errorf("%s: decoded with a trailing byte", encoding.name)
+		}
+	}
+}
+
+func TestDecodeBadInput(t *testing.T) {
+	header := EncodeHeader(vectorHeader())
+	header[3] = 3
+
+	tx := EncodeTransaction(vectorTx())
+	tx[3] = 3
+
+	tests := []struct {
+		name   string
+		decode func(data []byte) ([]byte, error)
+		data   []byte
+	}{
+		{"header version", encodings[0].decode, header},
+		{"transaction version", encodings[2].decode, tx},
+		{"header count", encodings[1].decode, []byte{0xff, 0xff, 0xff, 0xff}},
+		{"in count", encodings[2].decode, []byte{0, 0, 0, 2, 0xc4, 0x7d, 0, 1, 0xff, 0xff, 0xff, 0xff}},
+		{"transaction count", encodings[4].decode, append(EncodeHeader(vectorHeader()), 0xff, 0xff, 0xff, 0xff)},
+		{"branch count", encodings[6].decode, []byte{0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff}},
+	}
+
+	for _, test := range tests {
+		if _, err := test.decode(test.data); err == nil {
+			t.Errorf("%s: bad input decoded", test.name)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package blockchain
+
+import "errors"
+
+// The DHT has no handshake of its own: its nodes join whatever network their
+// bootstrap node is part of. Each node then stores a hello under a key shared by
+// every chain, with the ID of its chain and its genesis hash. Before syncing, a
+// node fetches the hello of the network it joins and leaves it if it is of another
+// chain. Nodes refuse to store the hellos of other chains, so the hello of a
+// network stays the one of its chain
+
+// Key of the hellos, the same for every chain
+func helloKey() []byte {
+	return NewHash([]byte("hello"))
+}
+
+func (this *Blockchain) hello() []byte {
+	return this.sealStored(STORED_HELLO, this.params.Genesis().Header.Hash)
+}
+
+// Check that the hello is of our chain
+func (this *Blockchain) checkHello(blob []byte) error {
+	data, err := this.openStored(blob, STORED_HELLO)
+
+	if err != nil {
+		return err
+	}
+
+	if compare(data, this.params.Genesis().Header.Hash) != 0 {
+		return errors.New("Another genesis block")
+	}
+
+	return nil
+}
+
+// Leave the network joined if it is of another chain, else greet it
+func (this *Blockchain) handshake() error {
+	if len(this.options.BootstrapAddr) > 0 {
+		// Nothing is found on new networks
+		if blob, err := this.client.Fetch(helloKey()); err == nil {
+			if err := this.checkHello(blob); err != nil {
+				return errors.New("Network of another chain: " + err.Error())
+			}
+		}
+	}
+
+	if _, _, err := this.client.StoreAt(helloKey(), this.hello()); err != nil {
+		this.logger.Warning("Cannot store the hello", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,188 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"strconv"
+)
+
+type HistoryTx struct {
+	TxHash        string `json:"txHash"`
+	Height        int64  `json:"height"`
+	Address       string `json:"address"`
+	Timestamp     int64  `json:"timestamp"`
+	Amount        int    `json:"amount"`
+	Fee           int    `json:"fee"`
+	Confirmations int64  `json:"confirmations"`
+	Data          string `json:"data,omitempty"`
+}
+
+// Per wallet history, ordered by block height.
+// The index maps a tx hash to its position in Txs
+type History struct {
+	Txs   []HistoryTx
+	index map[string]int
+}
+
+func NewHistory(txs []HistoryTx) *History {
+	history := &History{
+		Txs:   txs,
+		index: make(map[string]int),
+	}
+
+	for i, tx := range txs {
+		history.index[tx.TxHash] = i
+	}
+
+	return history
+}
+
+func (this *History) Add(tx HistoryTx) {
+	if _, ok := this.index[tx.TxHash]; ok {
+		return
+	}
+
+	this.index[tx.TxHash] = len(this.Txs)
+	this.Txs = append(this.Txs, tx)
+}
+
+func (this *History) Get(txHash string) (HistoryTx, bool) {
+	idx, ok := this.index[txHash]
+
+	if !ok {
+		return HistoryTx{}, false
+	}
+
+	return this.Txs[idx], true
+}
+
+func (this *History) Len() int {
+	return len(this.Txs)
+}
+
+// Returns the history of given wallet, most recent first, skipping `offset` entries
+// and returning at most `limit` of them. A limit <= 0 means no limit.
+// The second returned value is the total number of entries for this wallet
+func (this *Blockchain) GetHistory(walletName string, offset, limit int) ([]HistoryTx, int) {
+	this.RLock()
+	defer this.RUnlock()
+
+	history, ok := this.history[walletName]
+
+	if !ok {
+		return []HistoryTx{}, 0
+	}
+
+	total := history.Len()
+	res := []HistoryTx{}
+
+	if offset < 0 {
+		offset = 0
+	}
+
+	for i := total - 1 - offset; i >= 0; i-- {
+		if limit > 0 && len(res) >= limit {
+			break
+		}
+
+		tx := history.Txs[i]
+		tx.Confirmations = this.confirmations(tx.Height)
+
+		res = append(res, tx)
+	}
+
+	return res, total
+}
+
+func (this *Blockchain) GetHistoryTx(walletName string, txHash string) (HistoryTx, bool) {
+	this.RLock()
+	defer this.RUnlock()
+
+	history, ok := this.history[walletName]
+
+	if !ok {
+		return HistoryTx{}, false
+	}
+
+	tx, ok := history.Get(txHash)
+
+	if ok {
+		tx.Confirmations = this.confirmations(tx.Height)
+	}
+
+	return tx, ok
+}
+
+func (this *Blockchain) confirmations(height int64) int64 {
+	return this.headers[len(this.headers)-1].Height - height + 1
+}
+
+// Record the given transaction in the history of every local wallet involved.
+// `insTotal` is the value of the outs spent by the transaction, needed for the fee
+func (this *Blockchain) recordHistory(block *Block, tx *Transaction, insTotal int) {
+	isCoinbase := len(tx.Ins) == 0 && len(tx.Outs) == 1
+
+	outsTotal := 0
+	for _, out := range tx.Outs {
+		outsTotal += out.Value
+	}
+
+	fee := 0
+	if !isCoinbase {
+		fee = insTotal - outsTotal
+	}
+
+	for name, wallet := range this.wallets {
+		ownAddr := []byte(SanitizePubKey(wallet.pub))
+		own := compare(tx.Stamp.Pub, wallet.pub) == 0
+		addr := SanitizePubKey(tx.Stamp.Pub)
+		txValue := 0
+
+		for _, out := range tx.Outs {
+			if out.IsData() {
+				continue
+			}
+
+			if own && compare(out.Address, ownAddr) != 0 {
+				txValue -= out.Value
+				addr = string(out.Address)
+			}
+
+			if !own && compare(out.Address, ownAddr) == 0 {
+				txValue += out.Value
+			}
+
+			if isCoinbase && compare(out.Address, ownAddr) == 0 {
+				txValue = out.Value
+				addr = "Miner fee (Block " + strconv.FormatInt(block.Header.Height, 10) + ")"
+			}
+		}
+
+		data := tx.GetData()
+
+		if txValue == 0 && !(own && (fee > 0 || data != nil)) {
+			continue
+		}
+
+		entry := HistoryTx{
+			TxHash:    hex.EncodeToString(tx.Stamp.Hash),
+			Height:    block.Header.Height,
+			Address:   addr,
+			Timestamp: block.Header.Timestamp,
+			Amount:    txValue,
+		}
+
+		if own {
+			entry.Fee = fee
+		}
+
+		if data != nil {
+			entry.Data = DataString(data)
+		}
+
+		if _, ok := this.history[name]; !ok {
+			this.history[name] = NewHistory([]HistoryTx{})
+		}
+
+		this.history[name].Add(entry)
+	}
+}
@@ -0,0 +1,170 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// A transaction of `from` paying each value to its address, already hashed
+func newTestPayment(from *Wallet, pays map[string]int) *Transaction {
+	tx := &Transaction{
+		Ins:   []TxIn{{PrevHash: NewHash([]byte("prev"))}},
+		Stamp: Stamp{Pub: from.pub},
+	}
+
+	for address, value := range pays {
+		tx.Outs = append(tx.Outs, TxOut{Value: value, Address: []byte(address)})
+	}
+
+	tx.Stamp.Hash = tx.SigningHash()
+
+	return tx
+}
+
+func TestRecordHistory(t *testing.T) {
+	bc := newTestBlockchain(RegtestParams)
+	alice := newTestWallet(t, "alice")
+	bob := newTestWallet(t, "bob")
+	carol := newTestWallet(t, "carol")
+
+	bc.wallets["alice"] = alice
+	bc.wallets["bob"] = bob
+
+	aliceAddr := SanitizePubKey(alice.pub)
+	bobAddr := SanitizePubKey(bob.pub)
+	block := &Block{Header: BlockHeader{Height: 3, Timestamp: 42}}
+
+	tests := []struct {
+		name     string
+		tx       *Transaction
+		insTotal int
+		wallet   string
+		amount   int
+		fee      int
+		address  string
+	}{
+		{"incoming", newTestPayment(carol, map[string]int{aliceAddr: 30}), 30, "alice", 30, 0, SanitizePubKey(carol.pub)},
+		{"outgoing", newTestPayment(alice, map[string]int{bobAddr: 20, aliceAddr: 5}), 30, "alice", -20, 5, bobAddr},
+		{"outgoing, received", newTestPayment(alice, map[string]int{bobAddr: 21, aliceAddr: 5}), 30, "bob", 21, 0, aliceAddr},
+		{"self payment", newTestPayment(alice, map[string]int{aliceAddr: 25}), 30, "alice", 0, 5, aliceAddr},
+	}
+
+	for _, test := range tests {
+		bc.recordHistory(block, test.tx, test.insTotal)
+
+		entry, ok := bc.GetHistoryTx(test.wallet, hex.EncodeToString(test.tx.Stamp.Hash))
+
+		if !ok {
+			t.Fatalf("%s: not recorded", test.name)
+		}
+
+		if entry.Amount != test.amount || entry.Fee != test.fee || entry.Address != test.address || entry.Height != 3 {
+			t.Errorf("%s: bad entry %+v", test.name, entry)
+		}
+	}
+
+	// Wallets not involved record nothing
+	if _, ok := bc.GetHistoryTx("bob", hex.EncodeToString(tests[0].tx.Stamp.Hash)); ok {
+		t.Fatal("payment recorded by another wallet")
+	}
+
+	coinbase := &Transaction{Outs: []TxOut{{Value: 100, Address: []byte(aliceAddr)}}, Stamp: Stamp{Pub: alice.pub}}
+	coinbase.Stamp.Hash = coinbase.SigningHash()
+
+	bc.recordHistory(block, coinbase, 0)
+
+	if entry, _ := bc.GetHistoryTx("alice", hex.EncodeToString(coinbase.Stamp.Hash)); entry.Amount != 100 || entry.Fee != 0 || entry.Address != "Miner fee (Block 3)" {
+		t.Fatalf("bad coinbase entry %+v", entry)
+	}
+}
+
+func TestGetHistoryPaging(t *testing.T) {
+	bc := newTestBlockchain(RegtestParams)
+	bc.headers = append(bc.headers, BlockHeader{Height: 5})
+
+	txs := []HistoryTx{}
+
+	for height := int64(1); height <= 5; height++ {
+		txs = append(txs, HistoryTx{TxHash: strconv.FormatInt(height, 10), Height: height})
+	}
+
+	bc.history["alice"] = NewHistory(txs)
+
+	tests := []struct {
+		offset  int
+		limit   int
+		heights []int64
+	}{
+		{0, 0, []int64{5, 4, 3, 2, 1}},
+		{0, 2, []int64{5, 4}},
+		{1, 2, []int64{4, 3}},
+		{4, 10, []int64{1}},
+		{5, 1, []int64{}},
+		{10, 0, []int64{}},
+		{-1, 2, []int64{5, 4}},
+	}
+
+	for _, test := range tests {
+		res, total := bc.GetHistory("alice", test.offset, test.limit)
+		heights := []int64{}
+
+		for _, tx := range res {
+			heights = append(heights, tx.Height)
+		}
+
+		if total != 5 || !reflect.DeepEqual(heights, test.heights) {
+			t.Errorf("offset %d limit %d: got %v of %d", test.offset, test.limit, heights, total)
+		}
+	}
+
+	if res, total := bc.GetHistory("unknown", 0, 0); len(res) != 0 || total != 0 {
+		t.Fatal("history of an unknown wallet")
+	}
+
+	if res, _ := bc.GetHistory("alice", 0, 1); res[0].Confirmations != 1 {
+		t.Fatal("bad confirmations:", res[0].Confirmations)
+	}
+}
+
+func TestStoreHistory(t *testing.T) {
+	bc := newTestNode(t, RegtestParams)
+	names := []string{"main.key", "dir/wallet", "100% sure"}
+
+	for i, name := range names {
+		bc.history[name] = NewHistory([]HistoryTx{
+			{TxHash: "aa" + strconv.Itoa(i), Height: 1, Address: "addr", Amount: -5, Fee: 1, Data: "memo"},
+			{TxHash: "bb" + strconv.Itoa(i), Height: 2, Amount: 10},
+		})
+	}
+
+	if err := StoreHistory(bc); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := newTestBlockchain(RegtestParams)
+	loaded.options.Folder = bc.options.Folder
+
+	if err := LoadHistory(loaded); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(loaded.history) != len(names) {
+		t.Fatal("bad number of histories:", len(loaded.history))
+	}
+
+	for _, name := range names {
+		history, ok := loaded.history[name]
+
+		if !ok || !reflect.DeepEqual(history.Txs, bc.history[name].Txs) {
+			t.Errorf("%s: history not loaded back", name)
+
+			continue
+		}
+
+		if _, ok := history.Get(bc.history[name].Txs[1].TxHash); !ok {
+			t.Errorf("%s: history not indexed", name)
+		}
+	}
+}
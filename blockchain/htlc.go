@@ -0,0 +1,421 @@
+package blockchain
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/champii/crypto-dht/blockchain/script"
+)
+
+// Size of the secrets generated for new contracts
+var HTLC_SECRET_SIZE = 32
+
+// A hash time-locked contract: the outs paying its address go to Recipient with the
+// preimage of Hash, or back to Refund once Timeout is reached. Recipient and Refund
+// are pub key hashes, that is wallet addresses
+type HTLC struct {
+	Hash      []byte
+	Recipient []byte
+	Refund    []byte
+
+	// Block height, or unix timestamp if >= LOCKTIME_THRESHOLD
+	Timeout int64
+}
+
+func (this *HTLC) Script() (script.Script, error) {
+	return script.HashTimeLock(this.Hash, this.Recipient, this.Refund, this.Timeout)
+}
+
+func (this *HTLC) Address() (string, error) {
+	lock, err := this.Script()
+
+	if err != nil {
+		return "", err
+	}
+
+	return lock.Address(), nil
+}
+
+// Canonical encoding of the contract, see docs/encoding.md
+func EncodeHTLC(contract *HTLC) []byte {
+	enc := &encoder{}
+
+	enc.uint32(HTLC_VERSION)
+	enc.bytes(contract.Hash)
+	enc.bytes(contract.Recipient)
+	enc.bytes(contract.Refund)
+	enc.int64(contract.Timeout)
+
+	return enc.Bytes()
+}
+
+func DecodeHTLC(data []byte) (*HTLC, error) {
+	dec := &decoder{data: data}
+	contract := &HTLC{}
+
+	version := dec.uint32()
+
+	if dec.err == nil && version != HTLC_VERSION {
+		dec.fail("Unknown contract version " + strconv.FormatUint(uint64(version), 10))
+	}
+
+	contract.Hash = dec.bytes()
+	contract.Recipient = dec.bytes()
+	contract.Refund = dec.bytes()
+	contract.Timeout = dec.int64()
+
+	if err := dec.end(); err != nil {
+		return nil, errors.New("Bad contract: " + err.Error())
+	}
+
+	for _, hash := range [][]byte{contract.Hash, contract.Recipient, contract.Refund} {
+		if len(hash) != len(NewHash(nil)) {
+			return nil, errors.New("Bad contract: bad hash size")
+		}
+	}
+
+	if _, err := contract.Script(); err != nil {
+		return nil, errors.New("Bad contract: " + err.Error())
+	}
+
+	return contract, nil
+}
+
+// Hex encoded contract, to give to the counterparty
+func (this *HTLC) Encode() string {
+	return hex.EncodeToString(EncodeHTLC(this))
+}
+
+func ParseHTLC(value string) (*HTLC, error) {
+	data, err := hex.DecodeString(value)
+
+	if err != nil {
+		return nil, errors.New("Bad contract: " + err.Error())
+	}
+
+	return DecodeHTLC(data)
+}
+
+// Parse an order of the form 'amount:recipientAddress:timeout[:hash]' and execute it.
+// Without hash, a new secret is generated
+func (this *Blockchain) InitiateHTLCTo(value string) (*HTLC, error) {
+	splited := strings.Split(value, ":")
+
+	if len(splited) < 3 || len(splited) > 4 {
+		return nil, errors.New("Bad format, must be 'amount:recipientAddress:timeout[:hash]'")
+	}
+
+	amount, err := strconv.Atoi(splited[0])
+
+	if err != nil || amount <= 0 {
+		return nil, errors.New("Invalid amount: " + splited[0])
+	}
+
+	timeout, err := strconv.ParseInt(splited[2], 10, 64)
+
+	if err != nil || timeout <= 0 {
+		return nil, errors.New("Invalid timeout: " + splited[2])
+	}
+
+	var hash []byte
+
+	if len(splited) == 4 {
+		hash, err = hex.DecodeString(splited[3])
+
+		if err != nil {
+			return nil, errors.New("Invalid hash: " + splited[3])
+		}
+	}
+
+	contract, _, err := this.InitiateHTLC(amount, splited[1], timeout, hash, this.options.Fee)
+
+	return contract, err
+}
+
+// Parse an order of the form 'contract[:secret]' and execute it. Without secret,
+// the one we generated or saw revealed is used
+func (this *Blockchain) RedeemHTLCTo(value string) (*Transaction, error) {
+	splited := strings.Split(value, ":")
+
+	if len(splited) > 2 {
+		return nil, errors.New("Bad format, must be 'contract[:secret]'")
+	}
+
+	contract, err := ParseHTLC(splited[0])
+
+	if err != nil {
+		return nil, err
+	}
+
+	var secret []byte
+
+	if len(splited) == 2 {
+		secret, err = hex.DecodeString(splited[1])
+
+		if err != nil {
+			return nil, errors.New("Invalid secret: " + splited[1])
+		}
+	}
+
+	return this.RedeemHTLC(contract, secret, this.options.Fee)
+}
+
+func (this *Blockchain) RefundHTLCTo(value string) (*Transaction, error) {
+	contract, err := ParseHTLC(value)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return this.RefundHTLC(contract, this.options.Fee)
+}
+
+// Lock `value` from main.key in a contract paying `recipient` against the preimage of
+// `hash`. If hash is nil, a new secret is generated and kept
+func (this *Blockchain) InitiateHTLC(value int, recipient string, timeout int64, hash []byte, fee int) (*HTLC, *Transaction, error) {
+	recipientHash, err := hex.DecodeString(recipient)
+
+	if err != nil || len(recipientHash) != len(NewHash(nil)) {
+		return nil, nil, errors.New("Invalid recipient address: " + recipient)
+	}
+
+	if hash == nil {
+		secret := make([]byte, HTLC_SECRET_SIZE)
+
+		if _, err := rand.Read(secret); err != nil {
+			return nil, nil, err
+		}
+
+		hash = NewHash(secret)
+
+		if err := this.addSecret(secret); err != nil {
+			return nil, nil, errors.New("Cannot keep the secret: " + err.Error())
+		}
+	} else if err := this.trackHashlock(hash); err != nil {
+		return nil, nil, errors.New("Cannot track the hash: " + err.Error())
+	}
+
+	contract := &HTLC{
+		Hash:      hash,
+		Recipient: recipientHash,
+		Refund:    this.wallets["main.key"].PubHash(),
+		Timeout:   timeout,
+	}
+
+	address, err := contract.Address()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tx, err := this.SendTo([]string{strconv.Itoa(value) + ":" + address}, SendOptions{Fee: fee})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return contract, tx, nil
+}
+
+// Claim the contract funds with the secret, with the recipient wallet
+func (this *Blockchain) RedeemHTLC(contract *HTLC, secret []byte, fee int) (*Transaction, error) {
+	if secret == nil {
+		secret = this.GetSecret(contract.Hash)
+	}
+
+	if secret == nil {
+		return nil, errors.New("Unknown secret")
+	}
+
+	if compare(NewHash(secret), contract.Hash) != 0 {
+		return nil, errors.New("Secret does not match the contract hash")
+	}
+
+	wallet := this.walletByPubHash(contract.Recipient)
+
+	if wallet == nil {
+		return nil, errors.New("None of our wallets is the recipient of the contract")
+	}
+
+	return this.spendHTLC(contract, wallet, 0, fee, func(sig []byte) (script.Script, error) {
+		return script.UnlockHashTimeLockRedeem(sig, wallet.pub, secret)
+	})
+}
+
+// Take back the contract funds after its timeout. Before that, the refund transaction
+// is returned without being broadcast
+func (this *Blockchain) RefundHTLC(contract *HTLC, fee int) (*Transaction, error) {
+	wallet := this.walletByPubHash(contract.Refund)
+
+	if wallet == nil {
+		return nil, errors.New("None of our wallets can be refunded by the contract")
+	}
+
+	return this.spendHTLC(contract, wallet, contract.Timeout, fee, func(sig []byte) (script.Script, error) {
+		return script.UnlockHashTimeLockRefund(sig, wallet.pub)
+	})
+}
+
+// Confirmed funds locked in the contract
+func (this *Blockchain) AuditHTLC(contract *HTLC) (int, error) {
+	address, err := contract.Address()
+
+	if err != nil {
+		return 0, err
+	}
+
+	return this.GetAddressFunds(address), nil
+}
+
+func (this *Blockchain) spendHTLC(contract *HTLC, wallet *Wallet, lockTime int64, fee int, unlocker func(sig []byte) (script.Script, error)) (*Transaction, error) {
+	lock, err := contract.Script()
+
+	if err != nil {
+		return nil, err
+	}
+
+	unspents := this.getConfirmedSpendableOuts(lock.Address())
+
+	if len(unspents) == 0 {
+		return nil, errors.New("Nothing to spend at the contract address")
+	}
+
+	ins := []TxIn{}
+	total := 0
+
+	for _, unspent := range unspents {
+		ins = append(ins, TxIn{
+			PrevHash: unspent.TxHash,
+			PrevIdx:  unspent.InIdx,
+			Script:   &ScriptSpend{Lock: lock},
+		})
+
+		total += unspent.Out.Value
+	}
+
+	if total <= fee {
+		return nil, errors.New("Fee exceeds the contract amount")
+	}
+
+	tx := &Transaction{
+		Ins: ins,
+		Outs: []TxOut{TxOut{
+			Value:   total - fee,
+			Address: []byte(SanitizePubKey(wallet.pub)),
+		}},
+		LockTime: lockTime,
+	}
+
+	if err := tx.Sign(wallet, this.params.ID); err != nil {
+		return nil, err
+	}
+
+	// The unlocking scripts are not part of the signed hash
+	sig, err := tx.ScriptSignature(wallet)
+
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := unlocker(sig)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range tx.Ins {
+		tx.Ins[i].Script.Unlock = unlock
+	}
+
+	if !this.isFinal(tx) {
+		return tx, nil
+	}
+
+	if err := this.SubmitTransaction(tx); err != nil {
+		return nil, err
+	}
+
+	return tx, nil
+}
+
+// The preimage of `hash`, if we generated it or saw it revealed by a script spend
+func (this *Blockchain) GetSecret(hash []byte) []byte {
+	if secret := this.secrets[string(hash)]; secret != nil {
+		return secret
+	}
+
+	for _, entry := range this.mempool.Entries() {
+		for _, in := range entry.Tx.Ins {
+			if in.Script == nil {
+				continue
+			}
+
+			if secret := findPreimage(in.Script.Unlock, hash); secret != nil {
+				return secret
+			}
+		}
+	}
+
+	return nil
+}
+
+func (this *Blockchain) addSecret(secret []byte) error {
+	this.secrets[string(NewHash(secret))] = secret
+
+	return StoreSecrets(this)
+}
+
+// Wait for the preimage of `hash` to be revealed, as the counterparty of a swap.
+// It is kept without preimage until then
+func (this *Blockchain) trackHashlock(hash []byte) error {
+	if _, ok := this.secrets[string(hash)]; ok {
+		return nil
+	}
+
+	this.secrets[string(hash)] = nil
+
+	return StoreSecrets(this)
+}
+
+// Keep the secrets revealed by the script spends of a block for the hashes we
+// track, so the counterparty of a swap can redeem its side
+func (this *Blockchain) recordRevealedSecrets(tx *Transaction) {
+	for _, in := range tx.Ins {
+		if in.Script == nil {
+			continue
+		}
+
+		ops, err := script.Script(in.Script.Unlock).Ops()
+
+		if err != nil {
+			continue
+		}
+
+		for _, op := range ops {
+			hash := string(NewHash(op.Data))
+
+			if secret, ok := this.secrets[hash]; ok && secret == nil {
+				this.secrets[hash] = op.Data
+			}
+		}
+	}
+}
+
+func findPreimage(unlock []byte, hash []byte) []byte {
+	ops, err := script.Script(unlock).Ops()
+
+	if err != nil {
+		return nil
+	}
+
+	for _, op := range ops {
+		if len(op.Data) > 0 && compare(NewHash(op.Data), hash) == 0 {
+			return op.Data
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,246 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/champii/crypto-dht/blockchain/script"
+)
+
+// A regtest chain of its own, as each side of a swap
+func newTestChain(name string, idx uint32) ChainParams {
+	params := RegtestParams
+
+	params.Name = name
+	params.ID = RegtestParams.ID + idx<<8
+	params.GenesisTimestamp += int64(idx)
+
+	return params
+}
+
+func TestHTLCEncoding(t *testing.T) {
+	alice, bob := newTestWallet(t, "alice"), newTestWallet(t, "bob")
+
+	contract := &HTLC{Hash: NewHash([]byte("secret")), Recipient: bob.PubHash(), Refund: alice.PubHash(), Timeout: 50}
+
+	encoded := contract.Encode()
+
+	if encoded != hex.EncodeToString(EncodeHTLC(contract)) {
+		t.Fatal("contract not hex encoded")
+	}
+
+	decoded, err := ParseHTLC(encoded)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	address, _ := contract.Address()
+	decodedAddress, _ := decoded.Address()
+
+	if address != decodedAddress {
+		t.Fatal("decoded contract has another address")
+	}
+
+	if _, err := ParseHTLC(encoded[:len(encoded)-2]); err == nil {
+		t.Fatal("truncated contract decoded")
+	}
+
+	if _, err := ParseHTLC("zz"); err == nil {
+		t.Fatal("contract decoded from bad hex")
+	}
+
+	// A contract whose script cannot be built
+	if _, err := DecodeHTLC(EncodeHTLC(&HTLC{Hash: []byte("short"), Recipient: bob.PubHash(), Refund: alice.PubHash(), Timeout: 50})); err == nil {
+		t.Fatal("bad contract decoded")
+	}
+}
+
+// Only the preimages of the hashes we track are kept
+func TestRecordRevealedSecrets(t *testing.T) {
+	bc := newTestNode(t, RegtestParams)
+
+	tracked := []byte("0123456789abcdef0123456789abcdef")
+	other := []byte("fedcba9876543210fedcba9876543210")
+
+	if err := bc.trackHashlock(NewHash(tracked)); err != nil {
+		t.Fatal(err)
+	}
+
+	if bc.GetSecret(NewHash(tracked)) != nil {
+		t.Fatal("secret known before being revealed")
+	}
+
+	unlock, err := script.NewBuilder().AddData(other).AddData(tracked).AddData([]byte("x")).Script()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bc.recordRevealedSecrets(&Transaction{Ins: []TxIn{{Script: &ScriptSpend{Unlock: unlock}}}})
+
+	if len(bc.secrets) != 1 || compare(bc.GetSecret(NewHash(tracked)), tracked) != 0 {
+		t.Fatal("kept", len(bc.secrets), "secrets")
+	}
+
+	if findPreimage(unlock, NewHash(other)) == nil {
+		t.Fatal("preimage not found")
+	}
+
+	// Tracked hashes are stored with the secrets
+	if err := StoreSecrets(bc); err != nil {
+		t.Fatal(err)
+	}
+
+	bc.secrets = make(map[string][]byte)
+
+	if err := LoadSecrets(bc); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(bc.secrets) != 1 || compare(bc.secrets[string(NewHash(tracked))], tracked) != 0 {
+		t.Fatal("secrets not stored")
+	}
+}
+
+// Alice trades coins of the chain A for coins of the chain B owned by bob
+func TestHTLCSwapClaim(t *testing.T) {
+	chainA := newTestNode(t, newTestChain("swap-a", 1))
+	chainB := newTestNode(t, newTestChain("swap-b", 2))
+
+	// Alice mines on A and bob on B, each has a wallet on the other chain
+	bobOnA := newTestWallet(t, "bob.key")
+	chainA.wallets[bobOnA.name] = bobOnA
+
+	aliceOnB := newTestWallet(t, "alice.key")
+	chainB.wallets[aliceOnB.name] = aliceOnB
+
+	mineTestBlock(t, chainA)
+	mineTestBlock(t, chainB)
+
+	contractA, _, err := chainA.InitiateHTLC(100, SanitizePubKey(bobOnA.pub), chainA.BlocksHeight()+20, nil, 0)
+
+	if err != nil {
+		t.Fatal("alice cannot initiate:", err)
+	}
+
+	secret := chainA.GetSecret(contractA.Hash)
+
+	if secret == nil {
+		t.Fatal("secret not kept")
+	}
+
+	mineTestBlock(t, chainA)
+
+	if amount, _ := chainA.AuditHTLC(contractA); amount != 100 {
+		t.Fatal("contract of alice holds", amount)
+	}
+
+	contractB, _, err := chainB.InitiateHTLC(100, SanitizePubKey(aliceOnB.pub), chainB.BlocksHeight()+10, contractA.Hash, 0)
+
+	if err != nil {
+		t.Fatal("bob cannot initiate:", err)
+	}
+
+	mineTestBlock(t, chainB)
+
+	if chainB.GetSecret(contractA.Hash) != nil {
+		t.Fatal("bob knows the secret before alice redeems")
+	}
+
+	if _, err := chainB.RedeemHTLC(contractB, []byte("not the secret"), 0); err == nil {
+		t.Fatal("redeemed with a bad secret")
+	}
+
+	if _, err := chainB.RedeemHTLC(contractB, secret, 0); err != nil {
+		t.Fatal("alice cannot redeem:", err)
+	}
+
+	mineTestBlock(t, chainB)
+
+	// Bob learns the secret from the block
+	revealed := chainB.secrets[string(contractA.Hash)]
+
+	if compare(revealed, secret) != 0 {
+		t.Fatal("secret not revealed")
+	}
+
+	if _, err := chainA.RedeemHTLC(contractA, revealed, 0); err != nil {
+		t.Fatal("bob cannot redeem:", err)
+	}
+
+	mineTestBlock(t, chainA)
+
+	amountA, _ := chainA.AuditHTLC(contractA)
+	amountB, _ := chainB.AuditHTLC(contractB)
+
+	if amountA != 0 || amountB != 0 {
+		t.Fatal("contracts not spent:", amountA, amountB)
+	}
+
+	if chainA.GetAvailableFunds(bobOnA.pub) != 100 || chainB.GetAvailableFunds(aliceOnB.pub) != 100 {
+		t.Fatal("bob has", chainA.GetAvailableFunds(bobOnA.pub), "on A, alice has", chainB.GetAvailableFunds(aliceOnB.pub), "on B")
+	}
+}
+
+// Bob never locks his side: alice takes her coins back after the timeout
+func TestHTLCSwapRefund(t *testing.T) {
+	chainA := newTestNode(t, newTestChain("swap-a", 1))
+
+	bobOnA := newTestWallet(t, "bob.key")
+	chainA.wallets[bobOnA.name] = bobOnA
+	alice := chainA.wallets["main.key"]
+
+	mineTestBlock(t, chainA)
+
+	timeout := chainA.BlocksHeight() + 5
+
+	contract, _, err := chainA.InitiateHTLC(100, SanitizePubKey(bobOnA.pub), timeout, nil, 0)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mineTestBlock(t, chainA)
+
+	funds := chainA.GetAvailableFunds(alice.pub)
+
+	// Before the timeout the refund is only returned
+	refund, err := chainA.RefundHTLC(contract, 0)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if chainA.mempool.Has(refund.Stamp.Hash) || chainA.SubmitTransaction(refund) == nil {
+		t.Fatal("refund accepted before the timeout")
+	}
+
+	// Bob is not the refund key
+	chainA.wallets = map[string]*Wallet{"main.key": alice}
+
+	if _, err := chainA.RedeemHTLC(contract, nil, 0); err == nil {
+		t.Fatal("redeemed without the recipient wallet")
+	}
+
+	mined := 0
+
+	for ; chainA.BlocksHeight() < timeout; mined++ {
+		mineTestBlock(t, chainA)
+	}
+
+	if refund, err = chainA.RefundHTLC(contract, 0); err != nil || !chainA.mempool.Has(refund.Stamp.Hash) {
+		t.Fatal("refund refused after the timeout:", err)
+	}
+
+	mineTestBlock(t, chainA)
+
+	if amount, _ := chainA.AuditHTLC(contract); amount != 0 {
+		t.Fatal("contract still holds", amount)
+	}
+
+	// Every block mined since pays 100 too
+	if got, want := chainA.GetAvailableFunds(alice.pub), funds+100*(mined+1)+100; got != want {
+		t.Fatal("alice has", got, "want", want)
+	}
+}
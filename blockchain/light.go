@@ -0,0 +1,93 @@
+package blockchain
+
+import (
+	"errors"
+	"time"
+)
+
+// Light nodes keep only the headers. They check the proof of work and the targets
+// of the chain but not the transactions, and check the ones they are interested in
+// with Merkel proofs, see proof.go. They have no unspent outs, so they cannot mine
+// nor send coins.
+
+// Add a header following our last one. Must be called without the lock
+func (this *Blockchain) AddHeader(header *BlockHeader) error {
+	this.Lock()
+	defer this.Unlock()
+
+	if err := this.verifyLightHeader(header); err != nil {
+		return err
+	}
+
+	this.headers = append(this.headers, *header)
+
+	if err := StoreLastHeaders(this); err != nil {
+		this.logger.Warning("Cannot store last headers", err)
+	}
+
+	if this.params.RetargetInterval > 0 && header.Height%this.params.RetargetInterval == 0 {
+		this.adjustDifficulty(&Block{Header: *header})
+	}
+
+	return nil
+}
+
+// Check the header follows our last one. Must be called with the lock held
+func (this *Blockchain) verifyLightHeader(header *BlockHeader) error {
+	if header.Version != BLOCK_VERSION {
+		return errors.New("Unknown version")
+	}
+
+	if compare(header.ComputeHash(), header.Hash) != 0 {
+		return errors.New("Hashes does not match")
+	}
+
+	if header.Timestamp > time.Now().Unix()+MAX_FUTURE_BLOCK_TIME {
+		return errors.New("Timestamp too far in the future")
+	}
+
+	if err := this.verifyHeader(&this.headers[len(this.headers)-1], header); err != nil {
+		return err
+	}
+
+	if compare(this.lastTarget, header.Target) != 0 {
+		return errors.New("Bad target")
+	}
+
+	return nil
+}
+
+// Add the headers, in order. Returns the number of added headers
+func (this *Blockchain) addHeaders(headers []BlockHeader) (int, error) {
+	for i := range headers {
+		if err := this.AddHeader(&headers[i]); err != nil {
+			return i, err
+		}
+	}
+
+	return len(headers), nil
+}
+
+// Validate a block another node wants to store, from its header and its Merkel
+// hash only. Must be called with the lock held
+func (this *Blockchain) verifyLightBlock(block *Block) bool {
+	if len(block.Transactions) == 0 || !block.verifyMerkelTree() {
+		this.logger.Warning("ONSTORE Bad Merkel hash")
+
+		return false
+	}
+
+	height := block.Header.Height
+
+	if height > 0 && height < int64(len(this.headers)) {
+		return compare(block.Header.Hash, this.headers[height].Hash) == 0
+	}
+
+	if err := this.verifyLightHeader(&block.Header); err != nil {
+		this.logger.Warning("ONSTORE Bad block header:", err)
+
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,446 @@
+package blockchain
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	MEMPOOL_MAX_SIZE  = 5 * 1024 * 1024
+	MEMPOOL_MAX_COUNT = 5000
+	MEMPOOL_EXPIRY    = 72 * time.Hour
+
+	// Max number of unconfirmed transactions a pending transaction can depend on
+	MEMPOOL_MAX_ANCESTORS = 25
+)
+
+type MempoolEntry struct {
+	Tx   Transaction
+	Fee  int
+	Size int
+	Time int64
+}
+
+// Fee by kB, used to rank the entries
+func (this *MempoolEntry) FeeRate() int {
+	if this.Size == 0 {
+		return 0
+	}
+
+	return this.Fee * 1000 / this.Size
+}
+
+// Pending transactions, indexed by tx hash and by the outs they spend
+type Mempool struct {
+	sync.RWMutex
+	entries  map[string]*MempoolEntry
+	spent    map[string]string
+	size     int
+	maxSize  int
+	maxCount int
+	expiry   time.Duration
+}
+
+func NewMempool(maxSize, maxCount int, expiry time.Duration) *Mempool {
+	return &Mempool{
+		entries:  make(map[string]*MempoolEntry),
+		spent:    make(map[string]string),
+		maxSize:  maxSize,
+		maxCount: maxCount,
+		expiry:   expiry,
+	}
+}
+
+func NewMempoolEntry(tx *Transaction, fee int) *MempoolEntry {
+	return &MempoolEntry{
+		Tx:   *tx,
+		Fee:  fee,
+		Size: len(EncodeTransaction(tx)),
+		Time: time.Now().Unix(),
+	}
+}
+
+func outpointKey(txHash []byte, idx int) string {
+	return string(txHash) + ":" + strconv.Itoa(idx)
+}
+
+func (this *Mempool) Has(txHash []byte) bool {
+	this.RLock()
+	defer this.RUnlock()
+
+	_, ok := this.entries[string(txHash)]
+
+	return ok
+}
+
+func (this *Mempool) Get(txHash []byte) *MempoolEntry {
+	this.RLock()
+	defer this.RUnlock()
+
+	return this.entries[string(txHash)]
+}
+
+// Returns the pending transaction spending given in, if any
+func (this *Mempool) Spender(in *TxIn) *MempoolEntry {
+	this.RLock()
+	defer this.RUnlock()
+
+	hash, ok := this.spent[outpointKey(in.PrevHash, in.PrevIdx)]
+
+	if !ok {
+		return nil
+	}
+
+	return this.entries[hash]
+}
+
+func (this *Mempool) Count() int {
+	this.RLock()
+	defer this.RUnlock()
+
+	return len(this.entries)
+}
+
+func (this *Mempool) Size() int {
+	this.RLock()
+	defer this.RUnlock()
+
+	return this.size
+}
+
+// Returns the entries to evict to make room for `entry`. Only entries with a lower
+// fee rate are candidates, lowest first, `protected` ones excepted (the ancestors of
+// the entry). Evicting an entry evicts its descendants too, so they count in the
+// room made. Fails if there is no way to make enough room
+func (this *Mempool) MakeRoomFor(entry *MempoolEntry, protected map[string]bool) ([]*MempoolEntry, error) {
+	this.RLock()
+	defer this.RUnlock()
+
+	if entry.Size > this.maxSize {
+		return nil, errors.New("Transaction too big for the mempool")
+	}
+
+	count := len(this.entries)
+	size := this.size
+
+	res := []*MempoolEntry{}
+	evicted := make(map[string]bool)
+
+	for _, candidate := range this.byFeeRate(false) {
+		if count < this.maxCount && size+entry.Size <= this.maxSize {
+			break
+		}
+
+		hash := string(candidate.Tx.Stamp.Hash)
+
+		if protected[hash] || evicted[hash] {
+			continue
+		}
+
+		if candidate.FeeRate() >= entry.FeeRate() {
+			return nil, errors.New("Mempool full")
+		}
+
+		res = append(res, candidate)
+
+		for _, removed := range append([]*MempoolEntry{candidate}, this.descendants(candidate.Tx.Stamp.Hash)...) {
+			removedHash := string(removed.Tx.Stamp.Hash)
+
+			if evicted[removedHash] {
+				continue
+			}
+
+			evicted[removedHash] = true
+
+			count--
+			size -= removed.Size
+		}
+	}
+
+	if count >= this.maxCount || size+entry.Size > this.maxSize {
+		return nil, errors.New("Mempool full")
+	}
+
+	return res, nil
+}
+
+func (this *Mempool) Add(entry *MempoolEntry) {
+	this.Lock()
+	defer this.Unlock()
+
+	hash := string(entry.Tx.Stamp.Hash)
+
+	if _, ok := this.entries[hash]; ok {
+		return
+	}
+
+	this.entries[hash] = entry
+	this.size += entry.Size
+
+	for _, in := range entry.Tx.Ins {
+		this.spent[outpointKey(in.PrevHash, in.PrevIdx)] = hash
+	}
+}
+
+func (this *Mempool) Remove(txHash []byte) *MempoolEntry {
+	this.Lock()
+	defer this.Unlock()
+
+	hash := string(txHash)
+
+	entry, ok := this.entries[hash]
+
+	if !ok {
+		return nil
+	}
+
+	delete(this.entries, hash)
+	this.size -= entry.Size
+
+	for _, in := range entry.Tx.Ins {
+		key := outpointKey(in.PrevHash, in.PrevIdx)
+
+		if this.spent[key] == hash {
+			delete(this.spent, key)
+		}
+	}
+
+	return entry
+}
+
+// Returns the entries older than the mempool expiry
+func (this *Mempool) Expired(now int64) []*MempoolEntry {
+	this.RLock()
+	defer this.RUnlock()
+
+	res := []*MempoolEntry{}
+
+	limit := now - int64(this.expiry/time.Second)
+
+	for _, entry := range this.entries {
+		if entry.Time < limit {
+			res = append(res, entry)
+		}
+	}
+
+	return res
+}
+
+// Returns the hashes of the given pending transactions and of all the pending
+// transactions they depend on
+func (this *Mempool) Ancestors(hashes map[string]bool) map[string]bool {
+	this.RLock()
+	defer this.RUnlock()
+
+	res := make(map[string]bool)
+	queue := []string{}
+
+	for hash := range hashes {
+		queue = append(queue, hash)
+	}
+
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+
+		entry, ok := this.entries[hash]
+
+		if !ok || res[hash] {
+			continue
+		}
+
+		res[hash] = true
+
+		for _, in := range entry.Tx.Ins {
+			queue = append(queue, string(in.PrevHash))
+		}
+	}
+
+	return res
+}
+
+// Returns the pending transactions spending the outs of the given one, directly
+// or not. Children come before their own descendants
+func (this *Mempool) Descendants(txHash []byte) []*MempoolEntry {
+	this.RLock()
+	defer this.RUnlock()
+
+	return this.descendants(txHash)
+}
+
+// Same as Descendants. Must be called with the lock held
+func (this *Mempool) descendants(txHash []byte) []*MempoolEntry {
+	res := []*MempoolEntry{}
+	seen := map[string]bool{string(txHash): true}
+	queue := []string{string(txHash)}
+
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+
+		entry, ok := this.entries[hash]
+
+		if !ok {
+			continue
+		}
+
+		for i := range entry.Tx.Outs {
+			child, ok := this.spent[outpointKey(entry.Tx.Stamp.Hash, i)]
+
+			if !ok || seen[child] {
+				continue
+			}
+
+			seen[child] = true
+			res = append(res, this.entries[child])
+			queue = append(queue, child)
+		}
+	}
+
+	return res
+}
+
+// Pending entries, oldest first, but always after the entries they depend on
+func (this *Mempool) Entries() []*MempoolEntry {
+	this.RLock()
+	defer this.RUnlock()
+
+	entries := []*MempoolEntry{}
+
+	for _, entry := range this.entries {
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Time != entries[j].Time {
+			return entries[i].Time < entries[j].Time
+		}
+
+		return compare(entries[i].Tx.Stamp.Hash, entries[j].Tx.Stamp.Hash) < 0
+	})
+
+	res := []*MempoolEntry{}
+	visited := make(map[string]bool)
+
+	var visit func(entry *MempoolEntry)
+
+	visit = func(entry *MempoolEntry) {
+		hash := string(entry.Tx.Stamp.Hash)
+
+		if visited[hash] {
+			return
+		}
+
+		visited[hash] = true
+
+		for _, in := range entry.Tx.Ins {
+			if parent, ok := this.entries[string(in.PrevHash)]; ok {
+				visit(parent)
+			}
+		}
+
+		res = append(res, entry)
+	}
+
+	for _, entry := range entries {
+		visit(entry)
+	}
+
+	return res
+}
+
+// Pending transactions to mine, the best fee rates first but always after the
+// ones they depend on, up to `maxSize` bytes and `maxCount` transactions
+func (this *Mempool) BlockTemplate(maxSize, maxCount int) []Transaction {
+	this.RLock()
+	defer this.RUnlock()
+
+	res := []Transaction{}
+	included := make(map[string]bool)
+	size := 0
+
+	for _, entry := range this.byFeeRate(true) {
+		if included[string(entry.Tx.Stamp.Hash)] {
+			continue
+		}
+
+		// The entry can only be mined with its pending ancestors
+		pack := this.withAncestors(entry, included)
+		packSize := 0
+
+		for _, packed := range pack {
+			// Each transaction is prefixed by its size in the block
+			packSize += packed.Size + 4
+		}
+
+		if size+packSize > maxSize || len(res)+len(pack) > maxCount {
+			continue
+		}
+
+		for _, packed := range pack {
+			included[string(packed.Tx.Stamp.Hash)] = true
+			res = append(res, packed.Tx)
+		}
+
+		size += packSize
+	}
+
+	return res
+}
+
+// The entry and its pending ancestors missing from `excluded`, parents first.
+// Must be called with the lock held
+func (this *Mempool) withAncestors(entry *MempoolEntry, excluded map[string]bool) []*MempoolEntry {
+	res := []*MempoolEntry{}
+	visited := make(map[string]bool)
+
+	var visit func(entry *MempoolEntry)
+
+	visit = func(entry *MempoolEntry) {
+		hash := string(entry.Tx.Stamp.Hash)
+
+		if visited[hash] || excluded[hash] {
+			return
+		}
+
+		visited[hash] = true
+
+		for _, in := range entry.Tx.Ins {
+			if parent, ok := this.entries[string(in.PrevHash)]; ok {
+				visit(parent)
+			}
+		}
+
+		res = append(res, entry)
+	}
+
+	visit(entry)
+
+	return res
+}
+
+// Entries sorted by fee rate, must be called with the lock held
+func (this *Mempool) byFeeRate(desc bool) []*MempoolEntry {
+	res := []*MempoolEntry{}
+
+	for _, entry := range this.entries {
+		res = append(res, entry)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].FeeRate() != res[j].FeeRate() {
+			if desc {
+				return res[i].FeeRate() > res[j].FeeRate()
+			}
+
+			return res[i].FeeRate() < res[j].FeeRate()
+		}
+
+		return compare(res[i].Tx.Stamp.Hash, res[j].Tx.Stamp.Hash) < 0
+	})
+
+	return res
+}
@@ -0,0 +1,350 @@
+package blockchain
+
+import (
+	"testing"
+	"time"
+)
+
+// An entry of `size` bytes paying `fee`, spending the given ins and with `outs` outs
+func newTestEntry(hash string, fee, size, outs int, ins ...TxIn) *MempoolEntry {
+	return &MempoolEntry{
+		Tx: Transaction{
+			Ins:   ins,
+			Outs:  make([]TxOut, outs),
+			Stamp: Stamp{Hash: []byte(hash)},
+		},
+		Fee:  fee,
+		Size: size,
+		Time: 1,
+	}
+}
+
+func entryHashes(entries []*MempoolEntry) string {
+	res := ""
+
+	for _, entry := range entries {
+		res += string(entry.Tx.Stamp.Hash)
+	}
+
+	return res
+}
+
+func TestMempoolAddRemove(t *testing.T) {
+	mempool := NewMempool(1000, 10, time.Hour)
+	in := TxIn{PrevHash: []byte("x"), PrevIdx: 0}
+
+	mempool.Add(newTestEntry("a", 10, 100, 1, in))
+	mempool.Add(newTestEntry("a", 10, 100, 1, in))
+
+	if mempool.Count() != 1 || mempool.Size() != 100 {
+		t.Fatal("count", mempool.Count(), "size", mempool.Size())
+	}
+
+	if mempool.Spender(&in) == nil {
+		t.Fatal("spender not indexed")
+	}
+
+	mempool.Remove([]byte("a"))
+
+	if mempool.Count() != 0 || mempool.Size() != 0 || mempool.Spender(&in) != nil {
+		t.Fatal("entry not removed")
+	}
+}
+
+func TestMempoolMakeRoomFor(t *testing.T) {
+	tests := []struct {
+		maxSize  int
+		maxCount int
+		entry    *MempoolEntry
+		evicted  string
+		ok       bool
+	}{
+		// Room left
+		{1000, 10, newTestEntry("n", 1, 100, 1), "", true},
+		// Lowest fee rate first
+		{1000, 3, newTestEntry("n", 100, 100, 1), "a", true},
+		{340, 10, newTestEntry("n", 100, 100, 1), "a", true},
+		{200, 10, newTestEntry("n", 100, 100, 1), "ab", true},
+		// Only entries with a lower fee rate are evicted
+		{1000, 3, newTestEntry("n", 15, 100, 1), "a", true},
+		{1000, 3, newTestEntry("n", 5, 100, 1), "", false},
+		{200, 10, newTestEntry("n", 15, 100, 1), "", false},
+		// Never fits
+		{250, 10, newTestEntry("n", 100000, 300, 1), "", false},
+	}
+
+	for i, test := range tests {
+		mempool := NewMempool(test.maxSize, test.maxCount, time.Hour)
+
+		mempool.Add(newTestEntry("a", 10, 100, 1))
+		mempool.Add(newTestEntry("b", 20, 100, 1))
+		mempool.Add(newTestEntry("c", 30, 50, 1))
+
+		evicted, err := mempool.MakeRoomFor(test.entry, nil)
+
+		if (err == nil) != test.ok || entryHashes(evicted) != test.evicted {
+			t.Errorf("%d: evicted %q, %v", i, entryHashes(evicted), err)
+		}
+	}
+}
+
+func TestMempoolMakeRoomForProtected(t *testing.T) {
+	mempool := NewMempool(1000, 2, time.Hour)
+
+	mempool.Add(newTestEntry("a", 10, 100, 1))
+	mempool.Add(newTestEntry("b", 20, 100, 1))
+
+	evicted, err := mempool.MakeRoomFor(newTestEntry("n", 100, 100, 1), map[string]bool{"a": true})
+
+	if err != nil || entryHashes(evicted) != "b" {
+		t.Fatal(entryHashes(evicted), err)
+	}
+}
+
+// Evicting an entry evicts its descendants: their size and count make room too
+func TestMempoolMakeRoomForDescendants(t *testing.T) {
+	mempool := NewMempool(400, 10, time.Hour)
+
+	mempool.Add(newTestEntry("p", 10, 100, 1))
+	mempool.Add(newTestEntry("c", 40, 100, 1, TxIn{PrevHash: []byte("p"), PrevIdx: 0}))
+	mempool.Add(newTestEntry("x", 30, 100, 1))
+
+	evicted, err := mempool.MakeRoomFor(newTestEntry("n", 35, 250, 1), nil)
+
+	if err != nil || entryHashes(evicted) != "p" {
+		t.Fatal(entryHashes(evicted), err)
+	}
+
+	mempool = NewMempool(300, 10, time.Hour)
+
+	mempool.Add(newTestEntry("p", 10, 100, 1))
+	mempool.Add(newTestEntry("c", 5, 100, 1, TxIn{PrevHash: []byte("p"), PrevIdx: 0}))
+	mempool.Add(newTestEntry("x", 200, 100, 1))
+
+	// The child goes first, and is not counted again with its parent
+	if evicted, err = mempool.MakeRoomFor(newTestEntry("n", 100, 250, 1), nil); err == nil {
+		t.Fatal("evicted", entryHashes(evicted))
+	}
+}
+
+// Dropping an evicted entry with its descendants keeps the size total right
+func TestEvictionKeepsMempoolSize(t *testing.T) {
+	bc := newTestBlockchain(RegtestParams)
+	bc.mempool = NewMempool(400, 10, time.Hour)
+
+	bc.mempool.Add(newTestEntry("p", 10, 100, 1))
+	bc.mempool.Add(newTestEntry("c", 40, 150, 1, TxIn{PrevHash: []byte("p"), PrevIdx: 0}))
+	bc.mempool.Add(newTestEntry("x", 30, 100, 1))
+
+	evicted, err := bc.mempool.MakeRoomFor(newTestEntry("n", 100, 200, 1), nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, entry := range evicted {
+		bc.dropPendingTransaction(entry.Tx.Stamp.Hash)
+	}
+
+	if bc.mempool.Count() != 1 || bc.mempool.Size() != 100 {
+		t.Fatal("count", bc.mempool.Count(), "size", bc.mempool.Size())
+	}
+}
+
+func TestMempoolRelatives(t *testing.T) {
+	mempool := NewMempool(100000, 100, time.Hour)
+
+	mempool.Add(newTestEntry("a", 1, 10, 0, TxIn{PrevHash: []byte("c"), PrevIdx: 0}))
+	mempool.Add(newTestEntry("c", 1, 10, 1, TxIn{PrevHash: []byte("p"), PrevIdx: 1}))
+	mempool.Add(newTestEntry("p", 1, 10, 2))
+
+	if got := entryHashes(mempool.Entries()); got != "pca" {
+		t.Fatal("entries", got)
+	}
+
+	if got := entryHashes(mempool.Descendants([]byte("p"))); got != "ca" {
+		t.Fatal("descendants", got)
+	}
+
+	if got := mempool.Ancestors(map[string]bool{"a": true}); len(got) != 3 {
+		t.Fatal("ancestors", got)
+	}
+}
+
+func TestMempoolExpired(t *testing.T) {
+	mempool := NewMempool(1000, 10, time.Hour)
+
+	mempool.Add(newTestEntry("a", 1, 10, 1))
+
+	if len(mempool.Expired(1+3600)) != 0 || len(mempool.Expired(2+3600)) != 1 {
+		t.Fatal("bad expiry")
+	}
+}
+
+func TestBlockTemplate(t *testing.T) {
+	mempool := NewMempool(100000, 100, time.Hour)
+
+	mempool.Add(newTestEntry("p", 1, 100, 1))
+	mempool.Add(newTestEntry("c", 500, 100, 1, TxIn{PrevHash: []byte("p"), PrevIdx: 0}))
+	mempool.Add(newTestEntry("x", 100, 100, 1))
+	mempool.Add(newTestEntry("y", 50, 100, 1))
+
+	tests := []struct {
+		maxSize  int
+		maxCount int
+		want     string
+	}{
+		{10000, 100, "pcxy"},
+		{312, 100, "pcx"},
+		{10000, 1, "x"},
+		{200, 100, "x"},
+	}
+
+	for _, test := range tests {
+		got := ""
+
+		for _, tx := range mempool.BlockTemplate(test.maxSize, test.maxCount) {
+			got += string(tx.Stamp.Hash)
+		}
+
+		if got != test.want {
+			t.Errorf("BlockTemplate(%d, %d) = %q, want %q", test.maxSize, test.maxCount, got, test.want)
+		}
+	}
+}
+
+// A pending transaction of the wallet spending the first out of `parent`
+// back to the wallet, with a fee of 1
+func newTestChild(t *testing.T, bc *Blockchain, wallet *Wallet, parent *Transaction) *Transaction {
+	out := UnspentTxOut{TxHash: parent.Stamp.Hash, InIdx: 0}
+
+	return newTestSpend(t, bc, wallet, []UnspentTxOut{out}, parent.Outs[0].Value-1, SanitizePubKey(wallet.pub))
+}
+
+func TestStoreMempool(t *testing.T) {
+	bc := newTestNode(t, RegtestParams)
+	bc.options.MempoolExpiry = MEMPOOL_EXPIRY
+	wallet := bc.wallets["main.key"]
+	address := SanitizePubKey(wallet.pub)
+
+	outs := []UnspentTxOut{}
+
+	for _, name := range []string{"a", "b", "c"} {
+		outs = append(outs, giveTestOut(bc, wallet, name, 100, 0, false))
+	}
+
+	pending := []*Transaction{}
+
+	for _, out := range outs {
+		pending = append(pending, newTestSpend(t, bc, wallet, []UnspentTxOut{out}, 90, address))
+	}
+
+	pending = append(pending, newTestChild(t, bc, wallet, pending[0]))
+
+	for i, tx := range pending {
+		if !bc.AddTransationToWaiting(tx) {
+			t.Fatal(i, "refused")
+		}
+	}
+
+	if err := StoreMempool(bc); err != nil {
+		t.Fatal(err)
+	}
+
+	bc.mempool = NewMempool(MEMPOOL_MAX_SIZE, MEMPOOL_MAX_COUNT, MEMPOOL_EXPIRY)
+
+	if err := LoadMempool(bc); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, tx := range pending {
+		if !bc.mempool.Has(tx.Stamp.Hash) {
+			t.Fatal(i, "not restored")
+		}
+	}
+
+	// The second transaction gets confirmed, and the third one conflicts with the chain
+	conflict := newTestSpend(t, bc, wallet, []UnspentTxOut{outs[2]}, 80, "dest")
+
+	bc.mempool = NewMempool(MEMPOOL_MAX_SIZE, MEMPOOL_MAX_COUNT, MEMPOOL_EXPIRY)
+
+	for _, tx := range []*Transaction{pending[1], conflict} {
+		if !bc.AddTransationToWaiting(tx) {
+			t.Fatal("refused")
+		}
+	}
+
+	mineTestBlock(t, bc)
+
+	bc.mempool = NewMempool(MEMPOOL_MAX_SIZE, MEMPOOL_MAX_COUNT, MEMPOOL_EXPIRY)
+
+	if err := LoadMempool(bc); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		tx      *Transaction
+		pending bool
+	}{
+		{"unconfirmed", pending[0], true},
+		{"confirmed", pending[1], false},
+		{"conflicting", pending[2], false},
+		{"child", pending[3], true},
+	}
+
+	for _, test := range tests {
+		if bc.mempool.Has(test.tx.Stamp.Hash) != test.pending {
+			t.Errorf("%s: pending once restored = %v", test.name, !test.pending)
+		}
+	}
+
+	if bc.mempool.Count() != 2 {
+		t.Fatal("bad number of restored transactions:", bc.mempool.Count())
+	}
+}
+
+func TestUnconfirmedAncestorsLimit(t *testing.T) {
+	bc := newTestNode(t, RegtestParams)
+	wallet := bc.wallets["main.key"]
+	out := giveTestOut(bc, wallet, "out", 1000, 0, false)
+
+	tx := newTestSpend(t, bc, wallet, []UnspentTxOut{out}, 999, SanitizePubKey(wallet.pub))
+
+	// The last transaction has MEMPOOL_MAX_ANCESTORS - 1 unconfirmed ancestors
+	for i := 0; i < MEMPOOL_MAX_ANCESTORS; i++ {
+		if !bc.AddTransationToWaiting(tx) {
+			t.Fatal("transaction refused with", i, "unconfirmed ancestors")
+		}
+
+		tx = newTestChild(t, bc, wallet, tx)
+	}
+
+	if bc.AddTransationToWaiting(tx) {
+		t.Fatal("transaction accepted with too many unconfirmed ancestors")
+	}
+}
+
+// A child paying more than its parent is mined after it
+func TestBlockTemplateParentFirst(t *testing.T) {
+	bc := newTestNode(t, RegtestParams)
+	wallet := bc.wallets["main.key"]
+	out := giveTestOut(bc, wallet, "out", 100, 0, false)
+
+	parent := newTestSpend(t, bc, wallet, []UnspentTxOut{out}, 99, SanitizePubKey(wallet.pub))
+	child := newTestSpend(t, bc, wallet, []UnspentTxOut{{TxHash: parent.Stamp.Hash}}, 50, "dest")
+
+	for _, tx := range []*Transaction{parent, child} {
+		if !bc.AddTransationToWaiting(tx) {
+			t.Fatal("refused")
+		}
+	}
+
+	block := mineTestBlock(t, bc)
+
+	if len(block.Transactions) != 3 ||
+		compare(block.Transactions[1].Stamp.Hash, parent.Stamp.Hash) != 0 ||
+		compare(block.Transactions[2].Stamp.Hash, child.Stamp.Hash) != 0 {
+		t.Fatal("parent not packed before its child")
+	}
+}
@@ -0,0 +1,441 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Max number of keys of a multisig address
+var MULTISIG_MAX_KEYS = 16
+
+// Hashed before the encoded multisig to get its address, so it cannot collide with
+// the address of a pub key or of a script
+const MULTISIG_ADDRESS_TAG = "multisig"
+
+// An m-of-n address: spending its outs needs M signatures from distinct keys of Pubs
+type Multisig struct {
+	M    int
+	Pubs [][]byte
+}
+
+type MultisigSig struct {
+	// Index of the signing key in Pubs
+	Idx int
+	R   []byte
+	S   []byte
+}
+
+// The redeem data of an in spending a multisig out, with the signatures collected so far
+type MultisigSpend struct {
+	Multisig Multisig
+	Sigs     []MultisigSig `msgpack:",omitempty"`
+}
+
+// Keys are sorted, so the address does not depend on the order they are given
+func NewMultisig(m int, pubs [][]byte) (*Multisig, error) {
+	sorted := make([][]byte, len(pubs))
+	copy(sorted, pubs)
+
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i], sorted[j]) < 0
+	})
+
+	multisig := &Multisig{
+		M:    m,
+		Pubs: sorted,
+	}
+
+	if err := multisig.Check(); err != nil {
+		return nil, err
+	}
+
+	return multisig, nil
+}
+
+// Parse a multisig of the form 'm:pub1,pub2,...', with hex encoded pub keys
+func ParseMultisig(value string) (*Multisig, error) {
+	splited := strings.Split(value, ":")
+
+	if len(splited) != 2 {
+		return nil, errors.New("Bad format, must be 'm:pub1,pub2,...'")
+	}
+
+	m, err := strconv.Atoi(splited[0])
+
+	if err != nil {
+		return nil, errors.New("Invalid number of signatures: " + splited[0])
+	}
+
+	pubs := [][]byte{}
+
+	for _, pubHex := range strings.Split(splited[1], ",") {
+		pub, err := hex.DecodeString(strings.TrimSpace(pubHex))
+
+		if err != nil {
+			return nil, errors.New("Invalid pub key: " + pubHex)
+		}
+
+		pubs = append(pubs, pub)
+	}
+
+	return NewMultisig(m, pubs)
+}
+
+func (this *Multisig) Check() error {
+	if len(this.Pubs) == 0 || len(this.Pubs) > MULTISIG_MAX_KEYS {
+		return errors.New("A multisig needs between 1 and " + strconv.Itoa(MULTISIG_MAX_KEYS) + " keys")
+	}
+
+	if this.M < 1 || this.M > len(this.Pubs) {
+		return errors.New("Bad number of signatures: " + strconv.Itoa(this.M) + " of " + strconv.Itoa(len(this.Pubs)))
+	}
+
+	for i, pub := range this.Pubs {
+		if _, err := parsePubKey(pub); err != nil {
+			return err
+		}
+
+		if i > 0 && bytes.Compare(this.Pubs[i-1], pub) >= 0 {
+			return errors.New("Multisig keys must be sorted and distinct")
+		}
+	}
+
+	return nil
+}
+
+// The outs paying this address can only be spent with the multisig
+func (this *Multisig) Address() string {
+	enc := &encoder{}
+
+	encodeMultisig(enc, this)
+
+	return hex.EncodeToString(NewHash(append([]byte(MULTISIG_ADDRESS_TAG), enc.Bytes()...)))
+}
+
+// Index of the pub key in the multisig, or -1
+func (this *Multisig) KeyIndex(pub []byte) int {
+	for i, key := range this.Pubs {
+		if compare(key, pub) == 0 {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Check that the spend carries at least M valid signatures of `hash` from distinct keys
+func (this *MultisigSpend) Verify(hash []byte) error {
+	if err := this.Multisig.Check(); err != nil {
+		return err
+	}
+
+	signed := make(map[int]bool)
+
+	for _, sig := range this.Sigs {
+		if sig.Idx < 0 || sig.Idx >= len(this.Multisig.Pubs) || signed[sig.Idx] {
+			return errors.New("Bad signature index")
+		}
+
+		publicKey, err := parsePubKey(this.Multisig.Pubs[sig.Idx])
+
+		if err != nil {
+			return err
+		}
+
+		var r big.Int
+		r.SetBytes(sig.R)
+
+		var s big.Int
+		s.SetBytes(sig.S)
+
+		if !ecdsa.Verify(publicKey, hash, &r, &s) {
+			return errors.New("Signature does not match")
+		}
+
+		signed[sig.Idx] = true
+	}
+
+	if len(signed) < this.Multisig.M {
+		return errors.New("Got " + strconv.Itoa(len(signed)) + " signatures of " + strconv.Itoa(this.Multisig.M))
+	}
+
+	return nil
+}
+
+func (this *MultisigSpend) hasSigned(idx int) bool {
+	for _, sig := range this.Sigs {
+		if sig.Idx == idx {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Add the wallet signature to every multisig in it is a cosigner of.
+// Returns the number of signatures added
+func (this *Transaction) SignMultisig(wallet *Wallet) (int, error) {
+	hash := this.SigningHash()
+
+	if compare(hash, this.Stamp.Hash) != 0 {
+		return 0, errors.New("Transaction hash does not match")
+	}
+
+	added := 0
+
+	for i := range this.Ins {
+		spend := this.Ins[i].Multisig
+
+		if spend == nil {
+			continue
+		}
+
+		idx := spend.Multisig.KeyIndex(wallet.pub)
+
+		if idx == -1 || spend.hasSigned(idx) {
+			continue
+		}
+
+		r, s, err := ecdsa.Sign(rand.Reader, wallet.key, hash)
+
+		if err != nil {
+			return added, errors.New("Signature error: " + err.Error())
+		}
+
+		spend.Sigs = append(spend.Sigs, MultisigSig{
+			Idx: idx,
+			R:   r.Bytes(),
+			S:   s.Bytes(),
+		})
+
+		added++
+	}
+
+	return added, nil
+}
+
+// Merge the multisig signatures of several copies of the same partially signed transaction
+func CombineTransactions(txs []*Transaction) (*Transaction, error) {
+	if len(txs) == 0 {
+		return nil, errors.New("No transaction to combine")
+	}
+
+	res := *txs[0]
+	res.Ins = make([]TxIn, len(txs[0].Ins))
+
+	for i, in := range txs[0].Ins {
+		if in.Multisig != nil {
+			spend := *in.Multisig
+			spend.Sigs = append([]MultisigSig{}, in.Multisig.Sigs...)
+			in.Multisig = &spend
+		}
+
+		res.Ins[i] = in
+	}
+
+	for _, tx := range txs[1:] {
+		if compare(tx.Stamp.Hash, res.Stamp.Hash) != 0 || len(tx.Ins) != len(res.Ins) {
+			return nil, errors.New("Cannot combine different transactions")
+		}
+
+		for i, in := range tx.Ins {
+			spend := res.Ins[i].Multisig
+
+			if in.Multisig == nil || spend == nil {
+				continue
+			}
+
+			for _, sig := range in.Multisig.Sigs {
+				if !spend.hasSigned(sig.Idx) {
+					spend.Sigs = append(spend.Sigs, sig)
+				}
+			}
+		}
+	}
+
+	return &res, nil
+}
+
+// Create a multisig address of the form 'm:pub1,pub2,...' and watch its outs
+func (this *Blockchain) CreateMultisig(value string) (*Multisig, error) {
+	multisig, err := ParseMultisig(value)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := StoreMultisig(this, multisig); err != nil {
+		return nil, err
+	}
+
+	this.multisigs[multisig.Address()] = multisig
+
+	return multisig, nil
+}
+
+// Create a transaction spending the outs of a known multisig address, with the change
+// going back to it. It is signed by every local wallet of the multisig, and must
+// get the remaining signatures before it can be broadcast
+func (this *Blockchain) NewMultisigTransaction(address string, values []string, fee int) (*Transaction, error) {
+	multisig, ok := this.multisigs[address]
+
+	if !ok {
+		return nil, errors.New("Unknown multisig address: " + address)
+	}
+
+	payments, err := ParsePayments(values)
+
+	if err != nil {
+		return nil, err
+	}
+
+	value := fee
+	for _, payment := range payments {
+		value += payment.Value
+	}
+
+	selected := SelectLargestFirst(this.getConfirmedSpendableOuts(address), value)
+
+	if len(selected) == 0 {
+		return nil, errors.New("Not enough funds on the multisig address")
+	}
+
+	ins := []TxIn{}
+	total := 0
+
+	for _, unspent := range selected {
+		ins = append(ins, TxIn{
+			PrevHash: unspent.TxHash,
+			PrevIdx:  unspent.InIdx,
+			Multisig: &MultisigSpend{Multisig: *multisig},
+		})
+
+		total += unspent.Out.Value
+	}
+
+	outs := payments
+
+	if total > value {
+		outs = append(outs, TxOut{
+			Value:   total - value,
+			Address: []byte(address),
+		})
+	}
+
+	tx, err := NewSignedTransaction(ins, outs, this.wallets["main.key"], this.params.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := this.signWithLocalWallets(tx); err != nil {
+		return nil, err
+	}
+
+	return tx, nil
+}
+
+// Add the signatures of our wallets to a hex encoded partially signed transaction
+func (this *Blockchain) SignPartialTransaction(raw string) (*Transaction, error) {
+	tx, err := DecodeRawTransaction(raw)
+
+	if err != nil {
+		return nil, err
+	}
+
+	added, err := this.signWithLocalWallets(tx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if added == 0 {
+		return nil, errors.New("None of our wallets can sign this transaction")
+	}
+
+	return tx, nil
+}
+
+func (this *Blockchain) CombinePartialTransactions(raws []string) (*Transaction, error) {
+	txs := []*Transaction{}
+
+	for _, raw := range raws {
+		tx, err := DecodeRawTransaction(raw)
+
+		if err != nil {
+			return nil, err
+		}
+
+		txs = append(txs, tx)
+	}
+
+	return CombineTransactions(txs)
+}
+
+func (this *Blockchain) signWithLocalWallets(tx *Transaction) (int, error) {
+	added := 0
+
+	for _, wallet := range this.wallets {
+		nb, err := tx.SignMultisig(wallet)
+
+		if err != nil {
+			return added, err
+		}
+
+		added += nb
+	}
+
+	return added, nil
+}
+
+// Number of signatures still missing before the transaction can be broadcast
+func (this *Transaction) MissingSignatures() int {
+	missing := 0
+
+	for _, in := range this.Ins {
+		if in.Multisig == nil {
+			continue
+		}
+
+		if nb := in.Multisig.Multisig.M - len(in.Multisig.Sigs); nb > missing {
+			missing = nb
+		}
+	}
+
+	return missing
+}
+
+func (this *Blockchain) GetMultisigs() map[string]*Multisig {
+	return this.multisigs
+}
+
+func parsePubKey(pub []byte) (*ecdsa.PublicKey, error) {
+	block, _ := pem.Decode(pub)
+
+	if block == nil {
+		return nil, errors.New("Cannot decode pub key")
+	}
+
+	genericPublicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+
+	if err != nil {
+		return nil, errors.New("Cannot parse pub key: " + err.Error())
+	}
+
+	publicKey, ok := genericPublicKey.(*ecdsa.PublicKey)
+
+	if !ok {
+		return nil, errors.New("Not an ECDSA pub key")
+	}
+
+	return publicKey, nil
+}
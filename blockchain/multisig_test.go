@@ -0,0 +1,125 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestMultisigAddress(t *testing.T) {
+	a, b, c := newTestWallet(t, "a"), newTestWallet(t, "b"), newTestWallet(t, "c")
+
+	multisig, err := NewMultisig(2, [][]byte{c.pub, a.pub, b.pub})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sorted, err := NewMultisig(2, [][]byte{a.pub, b.pub, c.pub})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if multisig.Address() != sorted.Address() {
+		t.Fatal("address depends on the order of the keys")
+	}
+
+	other, _ := NewMultisig(3, [][]byte{a.pub, b.pub, c.pub})
+
+	if multisig.Address() == other.Address() {
+		t.Fatal("address does not depend on M")
+	}
+
+	enc := &encoder{}
+	encodeMultisig(enc, multisig)
+
+	if multisig.Address() == SanitizePubKey(enc.Bytes()) {
+		t.Fatal("address is not tagged")
+	}
+
+	if multisig.Address() != hex.EncodeToString(NewHash(append([]byte("multisig"), enc.Bytes()...))) {
+		t.Fatal("bad address")
+	}
+}
+
+func TestNewMultisigErrors(t *testing.T) {
+	a, b := newTestWallet(t, "a"), newTestWallet(t, "b")
+
+	tests := []struct {
+		m    int
+		pubs [][]byte
+	}{
+		{0, [][]byte{a.pub, b.pub}},
+		{3, [][]byte{a.pub, b.pub}},
+		{2, [][]byte{a.pub, a.pub}},
+		{1, [][]byte{}},
+		{1, [][]byte{[]byte("not a key")}},
+	}
+
+	for i, test := range tests {
+		if _, err := NewMultisig(test.m, test.pubs); err == nil {
+			t.Errorf("%d: bad multisig accepted", i)
+		}
+	}
+}
+
+func TestMultisigSignAndCombine(t *testing.T) {
+	a, b, c := newTestWallet(t, "a"), newTestWallet(t, "b"), newTestWallet(t, "c")
+
+	multisig, err := NewMultisig(2, [][]byte{a.pub, b.pub, c.pub})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx := &Transaction{
+		Ins:  []TxIn{{PrevHash: []byte("x"), Multisig: &MultisigSpend{Multisig: *multisig}}},
+		Outs: []TxOut{{Value: 1, Address: []byte("dest")}},
+	}
+
+	if err := tx.Sign(a, MainParams.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	hash := tx.Stamp.Hash
+
+	if n, err := tx.SignMultisig(a); n != 1 || err != nil {
+		t.Fatal("signed", n, err)
+	}
+
+	if tx.Ins[0].Multisig.Verify(hash) == nil || tx.MissingSignatures() != 1 {
+		t.Fatal("1 of 2 signatures accepted")
+	}
+
+	raw, err := EncodeRawTransaction(tx)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, _ := DecodeRawTransaction(raw)
+	second, _ := DecodeRawTransaction(raw)
+	second.Ins[0].Multisig.Sigs = nil
+
+	if n, err := second.SignMultisig(c); n != 1 || err != nil {
+		t.Fatal("signed", n, err)
+	}
+
+	combined, err := CombineTransactions([]*Transaction{first, second})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if compare(combined.SigningHash(), hash) != 0 {
+		t.Fatal("signatures changed the hash")
+	}
+
+	if err := combined.Ins[0].Multisig.Verify(hash); err != nil || combined.MissingSignatures() != 0 {
+		t.Fatal("2 of 2 signatures refused", err)
+	}
+
+	if len(first.Ins[0].Multisig.Sigs) != 1 {
+		t.Fatal("combining changed its inputs")
+	}
+}
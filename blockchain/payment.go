@@ -0,0 +1,116 @@
+package blockchain
+
+import (
+	"encoding/csv"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Parameters of a transaction created by the wallet
+type SendOptions struct {
+	// Coin selection strategy
+	Strategy string
+
+	// Fee paid by the transaction, in cents
+	Fee int
+
+	// Allow to bump the fee or cancel the transaction while it is pending
+	Replaceable bool
+
+	// Block height or timestamp before which the transaction cannot be mined
+	LockTime int64
+
+	// Number of blocks the spent outs must have been confirmed for
+	Sequence int64
+
+	// Bytes to attach in a data out
+	Data []byte
+}
+
+// Parse a payment of the form 'amount:destAddress', spaces around both ignored
+func ParsePayment(value string) (TxOut, error) {
+	splited := strings.Split(value, ":")
+
+	if len(splited) != 2 {
+		return TxOut{}, errors.New("Bad send format")
+	}
+
+	for i := range splited {
+		splited[i] = strings.TrimSpace(splited[i])
+	}
+
+	amount, err := strconv.Atoi(splited[0])
+
+	if err != nil || amount <= 0 {
+		return TxOut{}, errors.New("Invalid amount: " + splited[0])
+	}
+
+	if len(splited[1]) == 0 {
+		return TxOut{}, errors.New("Missing destination address")
+	}
+
+	return TxOut{
+		Value:   amount,
+		Address: []byte(splited[1]),
+	}, nil
+}
+
+// Parse the payments of one transaction, each address can only be paid once
+func ParsePayments(values []string) ([]TxOut, error) {
+	res := []TxOut{}
+	paid := make(map[string]bool)
+
+	for _, value := range values {
+		payment, err := ParsePayment(value)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if paid[string(payment.Address)] {
+			return nil, errors.New("Address paid twice: " + string(payment.Address))
+		}
+
+		paid[string(payment.Address)] = true
+
+		res = append(res, payment)
+	}
+
+	return res, nil
+}
+
+// Read payments from a CSV file with one 'amount,destAddress' record by line
+func LoadPaymentsFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+
+	records, err := reader.ReadAll()
+
+	if err != nil {
+		return nil, errors.New("Bad payments file: " + err.Error())
+	}
+
+	if len(records) == 0 {
+		return nil, errors.New("Bad payments file: no payment")
+	}
+
+	res := []string{}
+
+	for _, record := range records {
+		res = append(res, record[0]+":"+record[1])
+	}
+
+	return res, nil
+}
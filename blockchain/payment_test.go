@@ -0,0 +1,92 @@
+package blockchain
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParsePayments(t *testing.T) {
+	tests := []struct {
+		values []string
+		outs   []TxOut
+	}{
+		{[]string{"10:a"}, []TxOut{{Value: 10, Address: []byte("a")}}},
+		{[]string{"10:a", "5:b"}, []TxOut{{Value: 10, Address: []byte("a")}, {Value: 5, Address: []byte("b")}}},
+		{[]string{" 10 : a "}, []TxOut{{Value: 10, Address: []byte("a")}}},
+		{[]string{}, []TxOut{}},
+		{[]string{"0:a"}, nil},
+		{[]string{"-3:a"}, nil},
+		{[]string{"ten:a"}, nil},
+		{[]string{"1.5:a"}, nil},
+		{[]string{"10:"}, nil},
+		{[]string{"10"}, nil},
+		{[]string{"10:a:b"}, nil},
+		{[]string{"10:a", "5:a"}, nil},
+		{[]string{"10:a", " 5:a"}, nil},
+	}
+
+	for _, test := range tests {
+		outs, err := ParsePayments(test.values)
+
+		if test.outs == nil && err == nil {
+			t.Errorf("%q: parsed", test.values)
+		}
+
+		if test.outs != nil && (err != nil || !reflect.DeepEqual(outs, test.outs)) {
+			t.Errorf("%q: got %v, %v", test.values, outs, err)
+		}
+	}
+}
+
+func TestLoadPaymentsFile(t *testing.T) {
+	tests := []struct {
+		content  string
+		payments []string
+	}{
+		{"10,a\n5,b\n", []string{"10:a", "5:b"}},
+		{"# payouts\n10,a\n\n  5,  b\n", []string{"10:a", "5:b"}},
+		{"", nil},
+		{"# nothing\n", nil},
+		{"10,a\n5\n", nil},
+		{"10,a,b\n", nil},
+		{"10,\"a\n", nil},
+	}
+
+	for _, test := range tests {
+		path := filepath.Join(t.TempDir(), "payments.csv")
+
+		if err := ioutil.WriteFile(path, []byte(test.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		payments, err := LoadPaymentsFile(path)
+
+		if test.payments == nil && err == nil {
+			t.Errorf("%q: loaded", test.content)
+		}
+
+		if test.payments != nil && (err != nil || !reflect.DeepEqual(payments, test.payments)) {
+			t.Errorf("%q: got %v, %v", test.content, payments, err)
+		}
+	}
+
+	if _, err := LoadPaymentsFile(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Fatal("loaded a missing file")
+	}
+
+	// Trailing spaces are left to the parsing of each payment
+	path := filepath.Join(t.TempDir(), "payments.csv")
+	ioutil.WriteFile(path, []byte("10 ,a \n10,b\n"), 0644)
+
+	payments, err := LoadPaymentsFile(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if outs, err := ParsePayments(payments); err != nil || len(outs) != 2 || string(outs[0].Address) != "a" {
+		t.Fatal("payments with trailing spaces refused:", err)
+	}
+}
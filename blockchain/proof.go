@@ -0,0 +1,260 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Proof that a transaction is in the block at Height: the hashes needed to
+// rebuild the Merkel hash of its header from the transaction hash. Checking one
+// only needs the headers, as light nodes have
+
+type TxProof struct {
+	Height int64
+	Tx     Transaction
+
+	// Position of the transaction in the block
+	Index int
+
+	// Sibling of the transaction hash, then of each computed node up to the root
+	Branch [][]byte
+}
+
+// Siblings of the leaf at `index` on the way to the root, built like the tree itself
+func merkelBranch(leaves [][]byte, index int) [][]byte {
+	branch := [][]byte{}
+	row := leaves
+
+	for len(row) > 1 {
+		sibling := index ^ 1
+
+		// A lone last node is paired with itself
+		if sibling >= len(row) {
+			sibling = index
+		}
+
+		branch = append(branch, row[sibling])
+		row = processOneMerkelTreeRow(row)
+		index /= 2
+	}
+
+	return branch
+}
+
+// Root of the tree holding `leaf` at `index`, given its branch
+func merkelRootFromBranch(leaf []byte, index int, branch [][]byte) []byte {
+	hash := leaf
+
+	for _, sibling := range branch {
+		pair := []byte{}
+
+		if index%2 == 0 {
+			pair = append(append(pair, hash...), sibling...)
+		} else {
+			pair = append(append(pair, sibling...), hash...)
+		}
+
+		hash = NewHash(pair)
+		index /= 2
+	}
+
+	return hash
+}
+
+func (this *Block) TxProof(txHash []byte) (*TxProof, error) {
+	leaves := [][]byte{}
+	index := -1
+
+	for i, tx := range this.Transactions {
+		leaves = append(leaves, tx.Stamp.Hash)
+
+		if compare(tx.Stamp.Hash, txHash) == 0 {
+			index = i
+		}
+	}
+
+	if index < 0 {
+		return nil, errors.New("Transaction not in block")
+	}
+
+	return &TxProof{
+		Height: this.Header.Height,
+		Tx:     this.Transactions[index],
+		Index:  index,
+		Branch: merkelBranch(leaves, index),
+	}, nil
+}
+
+// Fetch the block at `height` from the DHT and build the proof for the transaction
+func (this *Blockchain) ProveTx(txHash []byte, height int64) (*TxProof, error) {
+	this.RLock()
+
+	if height <= 0 || height > this.BlocksHeight() {
+		this.RUnlock()
+
+		return nil, errors.New("Unknown block " + strconv.FormatInt(height, 10))
+	}
+
+	header := this.headers[height]
+	precHash := this.headers[height-1].Hash
+
+	this.RUnlock()
+
+	block, err := this.fetchBlock(precHash)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if compare(block.Header.Hash, header.Hash) != 0 {
+		return nil, errors.New("Block does not match its header")
+	}
+
+	return block.TxProof(txHash)
+}
+
+// Check the proof against our headers. Returns the number of confirmations of
+// the transaction
+func (this *Blockchain) VerifyTxProof(proof *TxProof) (int64, error) {
+	this.RLock()
+	defer this.RUnlock()
+
+	if proof.Height <= 0 || proof.Height > this.BlocksHeight() {
+		return 0, errors.New("Unknown block " + strconv.FormatInt(proof.Height, 10))
+	}
+
+	// A branch of n hashes leads to one of 2^n leaves, so each leaf has one index
+	if proof.Index < 0 || len(proof.Branch) > 30 || proof.Index >= 1<<uint(len(proof.Branch)) {
+		return 0, errors.New("Bad transaction index")
+	}
+
+	root := merkelRootFromBranch(proof.Tx.SigningHash(), proof.Index, proof.Branch)
+
+	if compare(root, this.headers[proof.Height].MerkelHash) != 0 {
+		return 0, errors.New("Transaction not in block " + strconv.FormatInt(proof.Height, 10))
+	}
+
+	return this.BlocksHeight() - proof.Height + 1, nil
+}
+
+func EncodeTxProof(proof *TxProof) []byte {
+	enc := &encoder{}
+
+	enc.int64(proof.Height)
+	enc.bytes(EncodeTransaction(&proof.Tx))
+	enc.int64(int64(proof.Index))
+
+	enc.uint32(uint32(len(proof.Branch)))
+
+	for _, hash := range proof.Branch {
+		enc.bytes(hash)
+	}
+
+	return enc.Bytes()
+}
+
+func DecodeTxProof(data []byte) (*TxProof, error) {
+	dec := &decoder{data: data}
+	proof := &TxProof{}
+
+	proof.Height = dec.int64()
+	txData := dec.bytes()
+	proof.Index = int(dec.int64())
+	proof.Branch = make([][]byte, dec.count(4))
+
+	for i := range proof.Branch {
+		proof.Branch[i] = dec.bytes()
+	}
+
+	if err := dec.end(); err != nil {
+		return nil, errors.New("Bad proof: " + err.Error())
+	}
+
+	tx, err := DecodeTransaction(txData)
+
+	if err != nil {
+		return nil, err
+	}
+
+	proof.Tx = *tx
+
+	return proof, nil
+}
+
+// Parse a proof order of the form 'txHash:height'
+func parseProofOrder(value string) ([]byte, int64, error) {
+	splited := strings.Split(value, ":")
+
+	if len(splited) != 2 {
+		return nil, 0, errors.New("Bad format, must be 'txHash:height'")
+	}
+
+	txHash, err := hex.DecodeString(splited[0])
+
+	if err != nil || len(txHash) == 0 {
+		return nil, 0, errors.New("Invalid transaction hash: " + splited[0])
+	}
+
+	height, err := strconv.ParseInt(splited[1], 10, 64)
+
+	if err != nil || height <= 0 {
+		return nil, 0, errors.New("Invalid height: " + splited[1])
+	}
+
+	return txHash, height, nil
+}
+
+func (this *Blockchain) ShowTxProof(value string) error {
+	txHash, height, err := parseProofOrder(value)
+
+	if err != nil {
+		return err
+	}
+
+	proof, err := this.ProveTx(txHash, height)
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Proof:", hex.EncodeToString(EncodeTxProof(proof)))
+
+	return nil
+}
+
+func (this *Blockchain) ShowProofCheck(value string) error {
+	data, err := hex.DecodeString(value)
+
+	if err != nil {
+		return errors.New("Invalid proof, must be hex encoded")
+	}
+
+	proof, err := DecodeTxProof(data)
+
+	if err != nil {
+		return err
+	}
+
+	confirmations, err := this.VerifyTxProof(proof)
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Transaction:  ", hex.EncodeToString(proof.Tx.Stamp.Hash))
+	fmt.Println("Block:        ", proof.Height)
+	fmt.Println("Confirmations:", confirmations)
+
+	for _, out := range proof.Tx.Outs {
+		if out.IsData() {
+			fmt.Println("Data:         ", hex.EncodeToString(out.Data))
+		} else {
+			fmt.Println("Pays:         ", out.Value, "ctd to", string(out.Address))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,125 @@
+package blockchain
+
+import "testing"
+
+func TestTxProof(t *testing.T) {
+	for count := 1; count <= 9; count++ {
+		block := &Block{Header: BlockHeader{Height: 1}}
+
+		for i := 0; i < count; i++ {
+			block.Transactions = append(block.Transactions, Transaction{
+				Outs:     []TxOut{{Value: i, Address: []byte("a")}},
+				LockTime: int64(i),
+			})
+
+			block.Transactions[i].Stamp.Hash = block.Transactions[i].SigningHash()
+		}
+
+		block.processMerkelTree()
+
+		bc := newTestBlockchain(RegtestParams)
+		bc.headers = append(bc.headers, block.Header)
+
+		for i := 0; i < count; i++ {
+			proof, err := block.TxProof(block.Transactions[i].Stamp.Hash)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			decoded, err := DecodeTxProof(EncodeTxProof(proof))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if confirmations, err := bc.VerifyTxProof(decoded); err != nil || confirmations != 1 {
+				t.Fatalf("%d of %d: proof refused: %v", i, count, err)
+			}
+
+			decoded.Index += 1 << uint(len(decoded.Branch))
+
+			if _, err := bc.VerifyTxProof(decoded); err == nil {
+				t.Fatalf("%d of %d: proof with a too big index accepted", i, count)
+			}
+
+			decoded.Index = i
+			decoded.Tx.Outs[0].Value++
+
+			if _, err := bc.VerifyTxProof(decoded); err == nil {
+				t.Fatalf("%d of %d: proof of a tampered transaction accepted", i, count)
+			}
+		}
+
+		if _, err := block.TxProof(NewHash(nil)); err == nil {
+			t.Fatal("proof of a missing transaction")
+		}
+	}
+}
+
+// A light node follows the headers of a full node and checks its transactions
+// with proofs
+func TestLightNode(t *testing.T) {
+	full := newTestNode(t, RegtestParams)
+	dest := newTestWallet(t, "dest")
+
+	mineTestBlock(t, full)
+
+	tx, err := full.SendTo([]string{"60:" + SanitizePubKey(dest.pub)}, SendOptions{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block := mineTestBlock(t, full)
+	mineTestBlock(t, full)
+
+	light := newTestNode(t, RegtestParams)
+	light.options.Light = true
+
+	if added, err := light.addHeaders(full.headers[1:]); err != nil || added != 3 {
+		t.Fatal("headers refused:", added, err)
+	}
+
+	proof, err := block.TxProof(tx.Stamp.Hash)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if confirmations, err := light.VerifyTxProof(proof); err != nil || confirmations != 2 {
+		t.Fatal("proof refused:", confirmations, err)
+	}
+
+	if !light.verifyLightBlock(block) {
+		t.Fatal("known block refused")
+	}
+
+	// Other blocks at a known height, as decoded from the DHT
+	other := *block
+	other.Transactions = other.Transactions[:1]
+	other.processMerkelTree()
+
+	if decoded, err := DecodeBlock(EncodeBlock(&other)); err != nil || light.verifyLightBlock(decoded) {
+		t.Fatal("other block at a known height accepted")
+	}
+
+	other = *block
+	other.Transactions = append([]Transaction{}, block.Transactions...)
+	other.Transactions[1].Outs = nil
+
+	if decoded, err := DecodeBlock(EncodeBlock(&other)); err != nil || light.verifyLightBlock(decoded) {
+		t.Fatal("block with a bad Merkel hash accepted")
+	}
+
+	// A header not following the last one
+	header := full.headers[2]
+
+	if err := light.AddHeader(&header); err == nil {
+		t.Fatal("header added twice")
+	}
+
+	if _, err := light.ProveUnspent(tx.Stamp.Hash, 0); err == nil {
+		t.Fatal("light node proved an unspent out")
+	}
+}
@@ -0,0 +1,57 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"errors"
+	"strconv"
+)
+
+// Hex encoded transaction, to be kept or sent out of band and broadcast later
+func EncodeRawTransaction(tx *Transaction) (string, error) {
+	return hex.EncodeToString(EncodeTransaction(tx)), nil
+}
+
+func DecodeRawTransaction(raw string) (*Transaction, error) {
+	serie, err := hex.DecodeString(raw)
+
+	if err != nil {
+		return nil, errors.New("Bad raw transaction: " + err.Error())
+	}
+
+	tx, err := DecodeTransaction(serie)
+
+	if err != nil {
+		return nil, errors.New("Bad raw transaction: " + err.Error())
+	}
+
+	return tx, nil
+}
+
+// Add a signed transaction to the mempool and broadcast it
+func (this *Blockchain) SubmitTransaction(tx *Transaction) error {
+	if missing := tx.MissingSignatures(); missing > 0 {
+		return errors.New("Transaction needs " + strconv.Itoa(missing) + " more signatures")
+	}
+
+	if !this.isFinal(tx) {
+		return errors.New("Transaction is locked until " + lockTimeString(tx.LockTime))
+	}
+
+	if !this.AddTransationToWaiting(tx) {
+		return errors.New("Transaction rejected")
+	}
+
+	this.mustStop = true
+
+	return this.BroadcastTransaction(tx)
+}
+
+func (this *Blockchain) BroadcastRawTransaction(raw string) error {
+	tx, err := DecodeRawTransaction(raw)
+
+	if err != nil {
+		return err
+	}
+
+	return this.SubmitTransaction(tx)
+}
@@ -0,0 +1,258 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	OWN_TX_PENDING    = "pending"
+	OWN_TX_CONFIRMED  = "confirmed"
+	OWN_TX_CONFLICTED = "conflicted"
+)
+
+var (
+	REBROADCAST_TICK         = 5 * time.Second
+	REBROADCAST_INTERVAL     = 30 * time.Second
+	REBROADCAST_MAX_INTERVAL = 30 * time.Minute
+
+	// How long confirmed and conflicted transactions are still reported
+	REBROADCAST_KEEP = 24 * time.Hour
+)
+
+// A transaction sent by one of our wallets
+type OwnTx struct {
+	Tx            Transaction `json:"-"`
+	TxHash        string      `json:"txHash"`
+	Status        string      `json:"status"`
+	FirstSeen     int64       `json:"firstSeen"`
+	LastBroadcast int64       `json:"lastBroadcast"`
+	NextBroadcast int64       `json:"nextBroadcast"`
+	Attempts      int         `json:"attempts"`
+	Height        int64       `json:"height"`
+	FinishedAt    int64       `json:"finishedAt"`
+}
+
+// Keep track of our own transactions until they are mined or conflict,
+// and schedule their broadcasts with an exponential backoff
+type Rebroadcaster struct {
+	sync.RWMutex
+	txs map[string]*OwnTx
+}
+
+func NewRebroadcaster() *Rebroadcaster {
+	return &Rebroadcaster{
+		txs: make(map[string]*OwnTx),
+	}
+}
+
+func (this *Rebroadcaster) Track(tx *Transaction, firstSeen int64) {
+	this.Lock()
+	defer this.Unlock()
+
+	hash := string(tx.Stamp.Hash)
+
+	if _, ok := this.txs[hash]; ok {
+		return
+	}
+
+	this.txs[hash] = &OwnTx{
+		Tx:        *tx,
+		TxHash:    hex.EncodeToString(tx.Stamp.Hash),
+		Status:    OWN_TX_PENDING,
+		FirstSeen: firstSeen,
+	}
+}
+
+func (this *Rebroadcaster) Get(txHash []byte) (OwnTx, bool) {
+	this.RLock()
+	defer this.RUnlock()
+
+	ownTx, ok := this.txs[string(txHash)]
+
+	if !ok {
+		return OwnTx{}, false
+	}
+
+	return *ownTx, true
+}
+
+// Record a broadcast, and schedule the next one twice as late as the previous
+func (this *Rebroadcaster) Broadcasted(txHash []byte, now int64) {
+	this.Lock()
+	defer this.Unlock()
+
+	ownTx, ok := this.txs[string(txHash)]
+
+	if !ok {
+		return
+	}
+
+	interval := REBROADCAST_INTERVAL << uint(ownTx.Attempts)
+
+	if interval > REBROADCAST_MAX_INTERVAL || interval <= 0 {
+		interval = REBROADCAST_MAX_INTERVAL
+	}
+
+	ownTx.Attempts++
+	ownTx.LastBroadcast = now
+	ownTx.NextBroadcast = now + int64(interval/time.Second)
+}
+
+func (this *Rebroadcaster) Finish(txHash []byte, status string, height int64, now int64) {
+	this.Lock()
+	defer this.Unlock()
+
+	ownTx, ok := this.txs[string(txHash)]
+
+	if !ok || ownTx.Status != OWN_TX_PENDING {
+		return
+	}
+
+	ownTx.Status = status
+	ownTx.Height = height
+	ownTx.FinishedAt = now
+}
+
+// Pending transactions, oldest first
+func (this *Rebroadcaster) Pending() []OwnTx {
+	res := []OwnTx{}
+
+	for _, ownTx := range this.List() {
+		if ownTx.Status == OWN_TX_PENDING {
+			res = append(res, ownTx)
+		}
+	}
+
+	return res
+}
+
+// All the tracked transactions, oldest first
+func (this *Rebroadcaster) List() []OwnTx {
+	this.RLock()
+	defer this.RUnlock()
+
+	res := []OwnTx{}
+
+	for _, ownTx := range this.txs {
+		res = append(res, *ownTx)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].FirstSeen != res[j].FirstSeen {
+			return res[i].FirstSeen < res[j].FirstSeen
+		}
+
+		return res[i].TxHash < res[j].TxHash
+	})
+
+	return res
+}
+
+// Forget the transactions finished for more than REBROADCAST_KEEP
+func (this *Rebroadcaster) Prune(now int64) {
+	this.Lock()
+	defer this.Unlock()
+
+	limit := now - int64(REBROADCAST_KEEP/time.Second)
+
+	for hash, ownTx := range this.txs {
+		if ownTx.Status != OWN_TX_PENDING && ownTx.FinishedAt < limit {
+			delete(this.txs, hash)
+		}
+	}
+}
+
+func (this *Blockchain) RebroadcastLoop() {
+	for {
+		this.rebroadcastOwnTransactions()
+
+		time.Sleep(REBROADCAST_TICK)
+	}
+}
+
+// Put back in the mempool our transactions that left it without being mined,
+// and broadcast the ones that are due
+func (this *Blockchain) rebroadcastOwnTransactions() {
+	now := time.Now().Unix()
+
+	this.rebroadcaster.Prune(now)
+
+	due := []Transaction{}
+
+	// Blocks are added with the lock held, so a transaction cannot be mined
+	// between the checks below
+	this.Lock()
+
+	for _, ownTx := range this.rebroadcaster.Pending() {
+		tx := ownTx.Tx
+
+		if height, ok := this.ownTxHeight(&tx); ok {
+			this.rebroadcaster.Finish(tx.Stamp.Hash, OWN_TX_CONFIRMED, height, now)
+
+			continue
+		}
+
+		if !this.mempool.Has(tx.Stamp.Hash) && !this.addTransactionToWaiting(&tx, now) {
+			this.logger.Info("Own transaction conflicts", ownTx.TxHash)
+
+			this.rebroadcaster.Finish(tx.Stamp.Hash, OWN_TX_CONFLICTED, 0, now)
+
+			continue
+		}
+
+		if ownTx.NextBroadcast <= now {
+			due = append(due, tx)
+		}
+	}
+
+	this.Unlock()
+
+	for i := range due {
+		if err := this.BroadcastTransaction(&due[i]); err != nil {
+			this.logger.Warning("Cannot rebroadcast transaction", err)
+		}
+	}
+}
+
+// Height of the block that included our transaction, from the history of the
+// wallet that signed it or else from its unspent outs, as transactions moving
+// no value record no history. Must be called with the lock held
+func (this *Blockchain) ownTxHeight(tx *Transaction) (int64, bool) {
+	wallet := this.walletByPub(tx.Stamp.Pub)
+
+	if wallet == nil {
+		return 0, false
+	}
+
+	if history, ok := this.history[wallet.name]; ok {
+		if historyTx, ok := history.Get(hex.EncodeToString(tx.Stamp.Hash)); ok {
+			return historyTx.Height, true
+		}
+	}
+
+	for _, out := range tx.Outs {
+		for _, unspent := range this.unspentTxOut[string(out.Address)] {
+			if compare(unspent.TxHash, tx.Stamp.Hash) == 0 {
+				return unspent.Height, true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+// Mark our transactions included in the block as confirmed
+func (this *Blockchain) confirmOwnTransactions(block *Block) {
+	now := time.Now().Unix()
+
+	for _, tx := range block.Transactions {
+		this.rebroadcaster.Finish(tx.Stamp.Hash, OWN_TX_CONFIRMED, block.Header.Height, now)
+	}
+}
+
+func (this *Blockchain) GetOwnTransactions() []OwnTx {
+	return this.rebroadcaster.List()
+}
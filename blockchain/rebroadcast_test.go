@@ -0,0 +1,120 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+func TestRebroadcasterBackoff(t *testing.T) {
+	rebroadcaster := NewRebroadcaster()
+	tx := &Transaction{Stamp: Stamp{Hash: []byte("tx")}}
+
+	rebroadcaster.Track(tx, 10)
+
+	interval := int64(REBROADCAST_INTERVAL / time.Second)
+
+	for i, want := range []int64{interval, 2 * interval, 4 * interval} {
+		rebroadcaster.Broadcasted(tx.Stamp.Hash, 100)
+
+		ownTx, _ := rebroadcaster.Get(tx.Stamp.Hash)
+
+		if ownTx.NextBroadcast != 100+want || ownTx.Attempts != i+1 {
+			t.Fatal("attempt", i+1, "next broadcast", ownTx.NextBroadcast)
+		}
+	}
+
+	rebroadcaster.Finish(tx.Stamp.Hash, OWN_TX_CONFIRMED, 5, 100)
+	rebroadcaster.Finish(tx.Stamp.Hash, OWN_TX_CONFLICTED, 0, 100)
+
+	if ownTx, _ := rebroadcaster.Get(tx.Stamp.Hash); ownTx.Status != OWN_TX_CONFIRMED || ownTx.Height != 5 {
+		t.Fatal("status", ownTx.Status)
+	}
+
+	if len(rebroadcaster.Pending()) != 0 {
+		t.Fatal("finished transaction still pending")
+	}
+
+	rebroadcaster.Prune(100 + int64(REBROADCAST_KEEP/time.Second) + 1)
+
+	if len(rebroadcaster.List()) != 0 {
+		t.Fatal("finished transaction not pruned")
+	}
+}
+
+// A pending transaction of the wallet, tracked as already broadcasted
+func trackTestSpend(t *testing.T, bc *Blockchain, wallet *Wallet, out UnspentTxOut) *Transaction {
+	tx := newTestSpend(t, bc, wallet, []UnspentTxOut{out}, out.Out.Value, "dest")
+
+	bc.rebroadcaster.Track(tx, time.Now().Unix())
+	bc.rebroadcaster.Broadcasted(tx.Stamp.Hash, time.Now().Unix())
+
+	return tx
+}
+
+func TestRebroadcastOwnTransactions(t *testing.T) {
+	bc := newTestBlockchain(RegtestParams)
+	wallet := newTestWallet(t, "main.key")
+	bc.wallets[wallet.name] = wallet
+
+	// Left the mempool, but can be added back
+	dropped := trackTestSpend(t, bc, wallet, giveTestOut(bc, wallet, "dropped", 100, 0, false))
+
+	// Its out was spent by another transaction
+	conflicted := trackTestSpend(t, bc, wallet, UnspentTxOut{
+		Out:    TxOut{Value: 100},
+		TxHash: NewHash([]byte("spent")),
+	})
+
+	// Mined: its out is spent, but by itself
+	mined := trackTestSpend(t, bc, wallet, UnspentTxOut{
+		Out:    TxOut{Value: 100},
+		TxHash: NewHash([]byte("mined")),
+	})
+
+	// Mined without a history entry: it only moves value back to the wallet
+	address := []byte(SanitizePubKey(wallet.pub))
+	minedData, err := NewSignedTransaction(
+		[]TxIn{{PrevHash: NewHash([]byte("minedData"))}},
+		[]TxOut{NewDataOut([]byte("data")), {Value: 100, Address: address}},
+		wallet,
+		bc.params.ID,
+	)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bc.rebroadcaster.Track(minedData, time.Now().Unix())
+	bc.addUnspentOut(UnspentTxOut{Out: minedData.Outs[1], TxHash: minedData.Stamp.Hash, InIdx: 1, Height: 4})
+
+	bc.history[wallet.name] = NewHistory([]HistoryTx{{
+		TxHash: hex.EncodeToString(mined.Stamp.Hash),
+		Height: 3,
+	}})
+
+	bc.rebroadcastOwnTransactions()
+
+	if !bc.mempool.Has(dropped.Stamp.Hash) {
+		t.Fatal("dropped transaction not added back")
+	}
+
+	tests := []struct {
+		tx     *Transaction
+		status string
+		height int64
+	}{
+		{dropped, OWN_TX_PENDING, 0},
+		{conflicted, OWN_TX_CONFLICTED, 0},
+		{mined, OWN_TX_CONFIRMED, 3},
+		{minedData, OWN_TX_CONFIRMED, 4},
+	}
+
+	for i, test := range tests {
+		ownTx, _ := bc.rebroadcaster.Get(test.tx.Stamp.Hash)
+
+		if ownTx.Status != test.status || ownTx.Height != test.height {
+			t.Errorf("%d: status %s at %d, want %s at %d", i, ownTx.Status, ownTx.Height, test.status, test.height)
+		}
+	}
+}
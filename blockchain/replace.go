@@ -0,0 +1,288 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Minimal fee increase for a replacement, in cents
+var REPLACEMENT_FEE_INCREMENT = 1
+
+// A pending transaction can only be replaced if it opted-in, and if the replacement
+// pays more fees than the transactions it evicts, descendants included
+func (this *Blockchain) canReplace(entry *MempoolEntry, conflicts map[string]*MempoolEntry) error {
+	replacedFee := 0
+	seen := make(map[string]bool)
+
+	for hash, conflict := range conflicts {
+		if !conflict.Tx.Replaceable {
+			return errors.New("Transaction " + hex.EncodeToString(conflict.Tx.Stamp.Hash) + " is not replaceable")
+		}
+
+		toReplace := append([]*MempoolEntry{conflict}, this.mempool.Descendants([]byte(hash))...)
+
+		for _, replaced := range toReplace {
+			if seen[string(replaced.Tx.Stamp.Hash)] {
+				continue
+			}
+
+			seen[string(replaced.Tx.Stamp.Hash)] = true
+			replacedFee += replaced.Fee
+		}
+	}
+
+	if entry.Fee <= replacedFee {
+		return errors.New("Replacement fee must be higher than " + strconv.Itoa(replacedFee))
+	}
+
+	return nil
+}
+
+// Parse an order of the form 'txHash[:fee]'
+func parseReplaceOrder(value string) ([]byte, int, error) {
+	splited := strings.Split(value, ":")
+
+	if len(splited) > 2 {
+		return nil, 0, errors.New("Bad format, must be 'txHash[:fee]'")
+	}
+
+	txHash, err := hex.DecodeString(splited[0])
+
+	if err != nil || len(txHash) == 0 {
+		return nil, 0, errors.New("Invalid transaction hash: " + splited[0])
+	}
+
+	fee := 0
+
+	if len(splited) == 2 {
+		fee, err = strconv.Atoi(splited[1])
+
+		if err != nil || fee <= 0 {
+			return nil, 0, errors.New("Invalid fee: " + splited[1])
+		}
+	}
+
+	return txHash, fee, nil
+}
+
+func (this *Blockchain) BumpFeeTo(value string) error {
+	txHash, fee, err := parseReplaceOrder(value)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = this.BumpFee(txHash, fee)
+
+	return err
+}
+
+func (this *Blockchain) CancelTo(value string) error {
+	txHash, fee, err := parseReplaceOrder(value)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = this.CancelTransaction(txHash, fee)
+
+	return err
+}
+
+// Replace one of our pending transactions by the same one paying `fee`.
+// The difference is taken from the change out, or from new ins if needed.
+// A fee of 0 gives the minimal fee accepted for a replacement
+func (this *Blockchain) BumpFee(txHash []byte, fee int) (*Transaction, error) {
+	entry, wallet, err := this.getReplaceableOwnEntry(txHash)
+
+	if err != nil {
+		return nil, err
+	}
+
+	fee, err = this.replacementFee(entry, fee)
+
+	if err != nil {
+		return nil, err
+	}
+
+	delta := fee - entry.Fee
+
+	walletStr := SanitizePubKey(wallet.pub)
+
+	ins := append([]TxIn{}, entry.Tx.Ins...)
+	outs := []TxOut{}
+
+	for _, out := range entry.Tx.Outs {
+		if delta > 0 && string(out.Address) == walletStr {
+			taken := delta
+
+			if taken > out.Value {
+				taken = out.Value
+			}
+
+			out.Value -= taken
+			delta -= taken
+
+			if out.Value == 0 {
+				continue
+			}
+		}
+
+		outs = append(outs, out)
+	}
+
+	if delta > 0 {
+		// Outs created by the replaced transactions will disappear with them
+		replaced := map[string]bool{string(txHash): true}
+
+		for _, descendant := range this.mempool.Descendants(txHash) {
+			replaced[string(descendant.Tx.Stamp.Hash)] = true
+		}
+
+		candidates := []UnspentTxOut{}
+
+		for _, unspent := range this.getSpendableOuts(wallet) {
+			if !replaced[string(unspent.TxHash)] {
+				candidates = append(candidates, unspent)
+			}
+		}
+
+		extra := SelectLargestFirst(candidates, delta)
+
+		if len(extra) == 0 {
+			return nil, errors.New("Not enough funds to bump the fee")
+		}
+
+		total := 0
+
+		for _, unspent := range extra {
+			ins = append(ins, TxIn{
+				PrevHash: unspent.TxHash,
+				PrevIdx:  unspent.InIdx,
+			})
+
+			total += unspent.Out.Value
+		}
+
+		if total > delta {
+			outs = append(outs, TxOut{
+				Value:   total - delta,
+				Address: []byte(walletStr),
+			})
+		}
+	}
+
+	return this.sendReplacement(ins, outs, wallet)
+}
+
+// Replace one of our pending transactions by one sending its ins back to our wallet.
+// A fee of 0 gives the minimal fee accepted for a replacement
+func (this *Blockchain) CancelTransaction(txHash []byte, fee int) (*Transaction, error) {
+	entry, wallet, err := this.getReplaceableOwnEntry(txHash)
+
+	if err != nil {
+		return nil, err
+	}
+
+	fee, err = this.replacementFee(entry, fee)
+
+	if err != nil {
+		return nil, err
+	}
+
+	total := entry.Fee
+
+	for _, out := range entry.Tx.Outs {
+		total += out.Value
+	}
+
+	if total <= fee {
+		return nil, errors.New("Fee exceeds the transaction amount")
+	}
+
+	outs := []TxOut{TxOut{
+		Value:   total - fee,
+		Address: []byte(SanitizePubKey(wallet.pub)),
+	}}
+
+	return this.sendReplacement(append([]TxIn{}, entry.Tx.Ins...), outs, wallet)
+}
+
+func (this *Blockchain) sendReplacement(ins []TxIn, outs []TxOut, wallet *Wallet) (*Transaction, error) {
+	tx := &Transaction{
+		Ins:         ins,
+		Outs:        outs,
+		Replaceable: true,
+	}
+
+	if err := tx.Sign(wallet, this.params.ID); err != nil {
+		return nil, err
+	}
+
+	if !this.AddTransationToWaiting(tx) {
+		return nil, errors.New("Unable to replace the transaction")
+	}
+
+	this.mustStop = true
+
+	if err := this.BroadcastTransaction(tx); err != nil {
+		return nil, err
+	}
+
+	return tx, nil
+}
+
+func (this *Blockchain) getReplaceableOwnEntry(txHash []byte) (*MempoolEntry, *Wallet, error) {
+	entry := this.mempool.Get(txHash)
+
+	if entry == nil {
+		return nil, nil, errors.New("Unknown pending transaction: " + hex.EncodeToString(txHash))
+	}
+
+	if !entry.Tx.Replaceable {
+		return nil, nil, errors.New("Transaction is not replaceable")
+	}
+
+	wallet := this.walletByPub(entry.Tx.Stamp.Pub)
+
+	if wallet == nil {
+		return nil, nil, errors.New("Transaction was not sent by one of our wallets")
+	}
+
+	// The replacement is signed as a plain spend of the wallet
+	for _, in := range entry.Tx.Ins {
+		if in.Multisig != nil || in.Script != nil {
+			return nil, nil, errors.New("Cannot replace a transaction spending multisig or script outs")
+		}
+	}
+
+	return entry, wallet, nil
+}
+
+// Returns the fee of the replacement of `entry`: `fee`, or the minimal one if 0.
+// Fails if `fee` is too low to replace the entry and its descendants
+func (this *Blockchain) replacementFee(entry *MempoolEntry, fee int) (int, error) {
+	minFee := this.minReplacementFee(entry)
+
+	if fee == 0 {
+		return minFee, nil
+	}
+
+	if fee < minFee {
+		return 0, errors.New("Fee must be at least " + strconv.Itoa(minFee) + " to replace the transaction")
+	}
+
+	return fee, nil
+}
+
+func (this *Blockchain) minReplacementFee(entry *MempoolEntry) int {
+	fee := entry.Fee
+
+	for _, descendant := range this.mempool.Descendants(entry.Tx.Stamp.Hash) {
+		fee += descendant.Fee
+	}
+
+	return fee + REPLACEMENT_FEE_INCREMENT
+}
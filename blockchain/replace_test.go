@@ -0,0 +1,199 @@
+package blockchain
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// A replaceable transaction of the wallet spending `out`, paying `value` to `to`
+func newTestReplaceable(t *testing.T, bc *Blockchain, wallet *Wallet, out UnspentTxOut, value int, to string) *Transaction {
+	tx := &Transaction{
+		Ins:         []TxIn{{PrevHash: out.TxHash, PrevIdx: out.InIdx}},
+		Outs:        []TxOut{{Value: value, Address: []byte(to)}},
+		Replaceable: true,
+	}
+
+	if err := tx.Sign(wallet, bc.params.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	return tx
+}
+
+func TestReplacementFee(t *testing.T) {
+	bc := newTestBlockchain(RegtestParams)
+
+	parent := newTestEntry("p", 10, 100, 1)
+	bc.mempool.Add(parent)
+	bc.mempool.Add(newTestEntry("c", 5, 100, 1, TxIn{PrevHash: []byte("p"), PrevIdx: 0}))
+
+	if fee := bc.minReplacementFee(parent); fee != 15+REPLACEMENT_FEE_INCREMENT {
+		t.Fatal("minReplacementFee", fee)
+	}
+
+	tests := []struct {
+		fee  int
+		want int
+		err  string
+	}{
+		{0, 16, ""},
+		{16, 16, ""},
+		{30, 30, ""},
+		{11, 0, "Fee must be at least 16"},
+		{15, 0, "Fee must be at least 16"},
+	}
+
+	for _, test := range tests {
+		fee, err := bc.replacementFee(parent, test.fee)
+
+		if fee != test.want || (err == nil) != (test.err == "") || (err != nil && !strings.HasPrefix(err.Error(), test.err)) {
+			t.Errorf("replacementFee(%d) = %d, %v", test.fee, fee, err)
+		}
+	}
+}
+
+func TestCanReplace(t *testing.T) {
+	bc := newTestBlockchain(RegtestParams)
+
+	replaceable := newTestEntry("r", 10, 100, 1)
+	replaceable.Tx.Replaceable = true
+	bc.mempool.Add(replaceable)
+	bc.mempool.Add(newTestEntry("c", 5, 100, 1, TxIn{PrevHash: []byte("r"), PrevIdx: 0}))
+
+	final := newTestEntry("f", 1, 100, 1)
+	bc.mempool.Add(final)
+
+	tests := []struct {
+		fee       int
+		conflicts map[string]*MempoolEntry
+		ok        bool
+	}{
+		{16, map[string]*MempoolEntry{"r": replaceable}, true},
+		// Must pay for the descendants too
+		{15, map[string]*MempoolEntry{"r": replaceable}, false},
+		{11, map[string]*MempoolEntry{"r": replaceable}, false},
+		{100, map[string]*MempoolEntry{"f": final}, false},
+	}
+
+	for i, test := range tests {
+		err := bc.canReplace(newTestEntry("n", test.fee, 100, 1), test.conflicts)
+
+		if (err == nil) != test.ok {
+			t.Errorf("%d: %v", i, err)
+		}
+	}
+}
+
+func TestReplaceTransaction(t *testing.T) {
+	bc := newTestBlockchain(RegtestParams)
+	wallet := newTestWallet(t, "main.key")
+	out := giveTestOut(bc, wallet, "out", 100, 0, false)
+
+	original := newTestReplaceable(t, bc, wallet, out, 90, "dest")
+
+	if !bc.AddTransationToWaiting(original) {
+		t.Fatal("original refused")
+	}
+
+	// Same fee
+	if bc.AddTransationToWaiting(newTestReplaceable(t, bc, wallet, out, 90, "other")) {
+		t.Fatal("replacement without a higher fee accepted")
+	}
+
+	replacement := newTestReplaceable(t, bc, wallet, out, 89, "dest")
+
+	if !bc.AddTransationToWaiting(replacement) {
+		t.Fatal("replacement refused")
+	}
+
+	if bc.mempool.Has(original.Stamp.Hash) || !bc.mempool.Has(replacement.Stamp.Hash) {
+		t.Fatal("original not replaced")
+	}
+
+	if bc.mempool.Get(replacement.Stamp.Hash).Fee != 11 {
+		t.Fatal("bad fee", bc.mempool.Get(replacement.Stamp.Hash).Fee)
+	}
+}
+
+func TestReplaceNotReplaceable(t *testing.T) {
+	bc := newTestBlockchain(RegtestParams)
+	wallet := newTestWallet(t, "main.key")
+	out := giveTestOut(bc, wallet, "out", 100, 0, false)
+
+	if !bc.AddTransationToWaiting(newTestSpend(t, bc, wallet, []UnspentTxOut{out}, 90, "dest")) {
+		t.Fatal("original refused")
+	}
+
+	if bc.AddTransationToWaiting(newTestReplaceable(t, bc, wallet, out, 50, "dest")) {
+		t.Fatal("final transaction replaced")
+	}
+}
+
+func TestBumpFeeErrors(t *testing.T) {
+	bc := newTestBlockchain(RegtestParams)
+	wallet := newTestWallet(t, "main.key")
+	bc.wallets[wallet.name] = wallet
+
+	plain := &MempoolEntry{
+		Tx: Transaction{
+			Ins:         []TxIn{{PrevHash: []byte("x")}},
+			Outs:        []TxOut{{Value: 90}},
+			Replaceable: true,
+			Stamp:       Stamp{Pub: wallet.pub, Hash: []byte("plain")},
+		},
+		Fee:  10,
+		Size: 100,
+		Time: time.Now().Unix(),
+	}
+
+	multisig := &MempoolEntry{
+		Tx: Transaction{
+			Ins:         []TxIn{{PrevHash: []byte("y"), Multisig: &MultisigSpend{}}},
+			Outs:        []TxOut{{Value: 90}},
+			Replaceable: true,
+			Stamp:       Stamp{Pub: wallet.pub, Hash: []byte("multisig")},
+		},
+		Fee:  10,
+		Size: 100,
+		Time: time.Now().Unix(),
+	}
+
+	script := &MempoolEntry{
+		Tx: Transaction{
+			Ins:         []TxIn{{PrevHash: []byte("z"), Script: &ScriptSpend{}}},
+			Outs:        []TxOut{{Value: 90}},
+			Replaceable: true,
+			Stamp:       Stamp{Pub: wallet.pub, Hash: []byte("script")},
+		},
+		Fee:  10,
+		Size: 100,
+		Time: time.Now().Unix(),
+	}
+
+	bc.mempool.Add(plain)
+	bc.mempool.Add(multisig)
+	bc.mempool.Add(script)
+
+	tests := []struct {
+		hash string
+		fee  int
+		err  string
+	}{
+		{"plain", 5, "Fee must be at least 11"},
+		{"plain", 10, "Fee must be at least 11"},
+		{"multisig", 0, "Cannot replace a transaction spending multisig or script outs"},
+		{"script", 0, "Cannot replace a transaction spending multisig or script outs"},
+		{"unknown", 0, "Unknown pending transaction"},
+	}
+
+	for _, test := range tests {
+		if _, err := bc.BumpFee([]byte(test.hash), test.fee); err == nil || !strings.HasPrefix(err.Error(), test.err) {
+			t.Errorf("BumpFee(%s, %d): %v", test.hash, test.fee, err)
+		}
+
+		if _, err := bc.CancelTransaction([]byte(test.hash), test.fee); err == nil || !strings.HasPrefix(err.Error(), test.err) {
+			t.Errorf("CancelTransaction(%s, %d): %v", test.hash, test.fee, err)
+		}
+	}
+}
@@ -0,0 +1,462 @@
+package script
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"strconv"
+)
+
+// Execution limits, so that the cost of verifying a script stays bounded
+var (
+	MAX_SCRIPT_SIZE   = 10000
+	MAX_ELEMENT_SIZE  = 520
+	MAX_STACK_SIZE    = 1000
+	MAX_OPS           = 201
+	MAX_MULTISIG_KEYS = 16
+
+	// Numbers are 4 bytes at most, 5 for lock times
+	MAX_NUM_SIZE      = 4
+	MAX_LOCKTIME_SIZE = 5
+)
+
+// The transaction related checks, provided by the caller
+type Checker interface {
+	// Is `sig` a valid signature of the transaction by the `pub` key
+	CheckSig(sig, pub []byte) bool
+
+	// Does the transaction LockTime reach `lockTime`
+	CheckLockTime(lockTime int64) bool
+
+	// Does the in Sequence reach `sequence`
+	CheckSequence(sequence int64) bool
+}
+
+// Run the unlocking script, then the locking script on the resulting stack.
+// The spend is valid if it ends with a single true element
+func Verify(unlock, lock Script, checker Checker) error {
+	if len(unlock) > MAX_SCRIPT_SIZE || len(lock) > MAX_SCRIPT_SIZE {
+		return errors.New("Script too big")
+	}
+
+	if !unlock.IsPushOnly() {
+		return errors.New("Unlocking script must only push data")
+	}
+
+	engine := NewEngine(checker)
+
+	if err := engine.Execute(unlock); err != nil {
+		return err
+	}
+
+	if err := engine.Execute(lock); err != nil {
+		return err
+	}
+
+	if len(engine.stack) != 1 {
+		return errors.New("Script must end with a single element, got " + strconv.Itoa(len(engine.stack)))
+	}
+
+	if !asBool(engine.stack[0]) {
+		return errors.New("Script evaluated to false")
+	}
+
+	return nil
+}
+
+type Engine struct {
+	stack   [][]byte
+	conds   []bool
+	ops     int
+	checker Checker
+}
+
+func NewEngine(checker Checker) *Engine {
+	return &Engine{checker: checker}
+}
+
+func (this *Engine) Stack() [][]byte {
+	return this.stack
+}
+
+// Execute a script on the current stack
+func (this *Engine) Execute(script Script) error {
+	this.conds = nil
+	this.ops = 0
+
+	for pc := 0; pc < len(script); {
+		op, next, err := nextOp(script, pc)
+
+		if err != nil {
+			return err
+		}
+
+		if err := this.step(op); err != nil {
+			return errors.New(OpName(op.Code) + ": " + err.Error())
+		}
+
+		if len(this.stack) > MAX_STACK_SIZE {
+			return errors.New("Stack too big")
+		}
+
+		pc = next
+	}
+
+	if len(this.conds) > 0 {
+		return errors.New("Unbalanced OP_IF")
+	}
+
+	return nil
+}
+
+// Are we in a branch being executed
+func (this *Engine) executing() bool {
+	for _, cond := range this.conds {
+		if !cond {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (this *Engine) step(op Op) error {
+	if op.Code > OP_16 {
+		this.ops++
+
+		if this.ops > MAX_OPS {
+			return errors.New("Too many opcodes")
+		}
+	}
+
+	if len(op.Data) > MAX_ELEMENT_SIZE {
+		return errors.New("Element too big")
+	}
+
+	executing := this.executing()
+
+	// Conditionals are tracked even in the branches not executed
+	switch op.Code {
+	case OP_IF, OP_NOTIF:
+		cond := false
+
+		if executing {
+			top, err := this.pop()
+
+			if err != nil {
+				return err
+			}
+
+			cond = asBool(top)
+
+			if op.Code == OP_NOTIF {
+				cond = !cond
+			}
+		}
+
+		this.conds = append(this.conds, cond)
+
+		return nil
+
+	case OP_ELSE:
+		if len(this.conds) == 0 {
+			return errors.New("No OP_IF")
+		}
+
+		this.conds[len(this.conds)-1] = !this.conds[len(this.conds)-1]
+
+		return nil
+
+	case OP_ENDIF:
+		if len(this.conds) == 0 {
+			return errors.New("No OP_IF")
+		}
+
+		this.conds = this.conds[:len(this.conds)-1]
+
+		return nil
+	}
+
+	if !executing {
+		return nil
+	}
+
+	switch {
+	case op.Code == OP_0 || op.Code <= OP_PUSHDATA2:
+		this.push(op.Data)
+
+		return nil
+
+	case op.Code == OP_1NEGATE:
+		this.push(EncodeNum(-1))
+
+		return nil
+
+	case op.Code >= OP_1 && op.Code <= OP_16:
+		this.push(EncodeNum(int64(op.Code - OP_1 + 1)))
+
+		return nil
+	}
+
+	switch op.Code {
+	case OP_NOP:
+		return nil
+
+	case OP_VERIFY:
+		return this.verify()
+
+	case OP_RETURN:
+		return errors.New("Unspendable")
+
+	case OP_DROP:
+		_, err := this.pop()
+
+		return err
+
+	case OP_DUP:
+		top, err := this.peek(0)
+
+		if err != nil {
+			return err
+		}
+
+		this.push(top)
+
+	case OP_OVER:
+		second, err := this.peek(1)
+
+		if err != nil {
+			return err
+		}
+
+		this.push(second)
+
+	case OP_SWAP:
+		if len(this.stack) < 2 {
+			return errors.New("Stack too small")
+		}
+
+		n := len(this.stack)
+		this.stack[n-1], this.stack[n-2] = this.stack[n-2], this.stack[n-1]
+
+	case OP_SIZE:
+		top, err := this.peek(0)
+
+		if err != nil {
+			return err
+		}
+
+		this.push(EncodeNum(int64(len(top))))
+
+	case OP_EQUAL, OP_EQUALVERIFY:
+		a, err := this.pop()
+
+		if err != nil {
+			return err
+		}
+
+		b, err := this.pop()
+
+		if err != nil {
+			return err
+		}
+
+		this.push(fromBool(bytes.Equal(a, b)))
+
+		if op.Code == OP_EQUALVERIFY {
+			return this.verify()
+		}
+
+	case OP_SHA256:
+		top, err := this.pop()
+
+		if err != nil {
+			return err
+		}
+
+		hash := sha256.Sum256(top)
+
+		this.push(hash[:])
+
+	case OP_CHECKSIG, OP_CHECKSIGVERIFY:
+		pub, err := this.pop()
+
+		if err != nil {
+			return err
+		}
+
+		sig, err := this.pop()
+
+		if err != nil {
+			return err
+		}
+
+		this.push(fromBool(len(sig) > 0 && this.checker.CheckSig(sig, pub)))
+
+		if op.Code == OP_CHECKSIGVERIFY {
+			return this.verify()
+		}
+
+	case OP_CHECKMULTISIG, OP_CHECKMULTISIGVERIFY:
+		if err := this.checkMultisig(); err != nil {
+			return err
+		}
+
+		if op.Code == OP_CHECKMULTISIGVERIFY {
+			return this.verify()
+		}
+
+	case OP_CHECKLOCKTIMEVERIFY, OP_CHECKSEQUENCEVERIFY:
+		top, err := this.peek(0)
+
+		if err != nil {
+			return err
+		}
+
+		n, err := DecodeNum(top, MAX_LOCKTIME_SIZE)
+
+		if err != nil {
+			return err
+		}
+
+		if n < 0 {
+			return errors.New("Negative lock")
+		}
+
+		if op.Code == OP_CHECKLOCKTIMEVERIFY && !this.checker.CheckLockTime(n) {
+			return errors.New("Lock time not reached")
+		}
+
+		if op.Code == OP_CHECKSEQUENCEVERIFY && !this.checker.CheckSequence(n) {
+			return errors.New("Sequence not reached")
+		}
+
+	default:
+		return errors.New("Unknown opcode")
+	}
+
+	return nil
+}
+
+// Stack: <sig1> ... <sigM> M <pub1> ... <pubN> N
+// Signatures must be given in the order of their keys
+func (this *Engine) checkMultisig() error {
+	n, err := this.popNum()
+
+	if err != nil {
+		return err
+	}
+
+	if n < 0 || n > int64(MAX_MULTISIG_KEYS) {
+		return errors.New("Bad number of keys")
+	}
+
+	this.ops += int(n)
+
+	if this.ops > MAX_OPS {
+		return errors.New("Too many opcodes")
+	}
+
+	pubs := [][]byte{}
+
+	for i := int64(0); i < n; i++ {
+		pub, err := this.pop()
+
+		if err != nil {
+			return err
+		}
+
+		pubs = append([][]byte{pub}, pubs...)
+	}
+
+	m, err := this.popNum()
+
+	if err != nil {
+		return err
+	}
+
+	if m < 0 || m > n {
+		return errors.New("Bad number of signatures")
+	}
+
+	sigs := [][]byte{}
+
+	for i := int64(0); i < m; i++ {
+		sig, err := this.pop()
+
+		if err != nil {
+			return err
+		}
+
+		sigs = append([][]byte{sig}, sigs...)
+	}
+
+	success := true
+	k := 0
+
+	for _, sig := range sigs {
+		for k < len(pubs) && (len(sig) == 0 || !this.checker.CheckSig(sig, pubs[k])) {
+			k++
+		}
+
+		if k == len(pubs) {
+			success = false
+
+			break
+		}
+
+		k++
+	}
+
+	this.push(fromBool(success))
+
+	return nil
+}
+
+func (this *Engine) verify() error {
+	top, err := this.pop()
+
+	if err != nil {
+		return err
+	}
+
+	if !asBool(top) {
+		return errors.New("Verify failed")
+	}
+
+	return nil
+}
+
+func (this *Engine) push(data []byte) {
+	this.stack = append(this.stack, data)
+}
+
+func (this *Engine) pop() ([]byte, error) {
+	if len(this.stack) == 0 {
+		return nil, errors.New("Empty stack")
+	}
+
+	top := this.stack[len(this.stack)-1]
+	this.stack = this.stack[:len(this.stack)-1]
+
+	return top, nil
+}
+
+// Element at `depth` from the top, without removing it
+func (this *Engine) peek(depth int) ([]byte, error) {
+	if len(this.stack) <= depth {
+		return nil, errors.New("Stack too small")
+	}
+
+	return this.stack[len(this.stack)-1-depth], nil
+}
+
+func (this *Engine) popNum() (int64, error) {
+	top, err := this.pop()
+
+	if err != nil {
+		return 0, err
+	}
+
+	return DecodeNum(top, MAX_NUM_SIZE)
+}
@@ -0,0 +1,95 @@
+package script
+
+import "errors"
+
+// Numbers are encoded in little endian, with the sign in the highest bit of the last
+// byte. Zero is the empty element, and encodings must be minimal so that a number
+// has only one representation
+
+func EncodeNum(n int64) []byte {
+	if n == 0 {
+		return []byte{}
+	}
+
+	negative := n < 0
+	abs := uint64(n)
+
+	if negative {
+		abs = uint64(-n)
+	}
+
+	res := []byte{}
+
+	for abs > 0 {
+		res = append(res, byte(abs&0xff))
+		abs >>= 8
+	}
+
+	// Keep the highest bit for the sign
+	if res[len(res)-1]&0x80 != 0 {
+		if negative {
+			res = append(res, 0x80)
+		} else {
+			res = append(res, 0x00)
+		}
+	} else if negative {
+		res[len(res)-1] |= 0x80
+	}
+
+	return res
+}
+
+func DecodeNum(data []byte, maxSize int) (int64, error) {
+	if len(data) > maxSize {
+		return 0, errors.New("Number too big")
+	}
+
+	if len(data) == 0 {
+		return 0, nil
+	}
+
+	last := data[len(data)-1]
+
+	// The last byte can only be 0x00 or 0x80 if the previous one needs its highest bit
+	if last&0x7f == 0 && (len(data) == 1 || data[len(data)-2]&0x80 == 0) {
+		return 0, errors.New("Non minimal number encoding")
+	}
+
+	var res int64
+
+	for i, b := range data {
+		res |= int64(b) << uint(8*i)
+	}
+
+	if last&0x80 != 0 {
+		res &= ^(int64(0x80) << uint(8*(len(data)-1)))
+
+		return -res, nil
+	}
+
+	return res, nil
+}
+
+// An element is false if it is empty, zero or negative zero
+func asBool(data []byte) bool {
+	for i, b := range data {
+		if b != 0 {
+			// Negative zero
+			if i == len(data)-1 && b == 0x80 {
+				return false
+			}
+
+			return true
+		}
+	}
+
+	return false
+}
+
+func fromBool(b bool) []byte {
+	if b {
+		return []byte{1}
+	}
+
+	return []byte{}
+}
@@ -0,0 +1,101 @@
+package script
+
+import "strconv"
+
+const (
+	// Push an empty element, which is false
+	OP_0 byte = 0x00
+
+	// Opcodes 0x01 to 0x4b push the next 1 to 75 bytes
+	OP_DATA_MAX byte = 0x4b
+
+	// Push the number of bytes given by the next 1 or 2 (little endian) bytes
+	OP_PUSHDATA1 byte = 0x4c
+	OP_PUSHDATA2 byte = 0x4d
+
+	// Push the numbers -1 and 1 to 16
+	OP_1NEGATE byte = 0x4f
+	OP_1       byte = 0x51
+	OP_16      byte = 0x60
+
+	OP_NOP    byte = 0x61
+	OP_IF     byte = 0x63
+	OP_NOTIF  byte = 0x64
+	OP_ELSE   byte = 0x67
+	OP_ENDIF  byte = 0x68
+	OP_VERIFY byte = 0x69
+	OP_RETURN byte = 0x6a
+
+	OP_DROP byte = 0x75
+	OP_DUP  byte = 0x76
+	OP_OVER byte = 0x78
+	OP_SWAP byte = 0x7c
+	OP_SIZE byte = 0x82
+
+	OP_EQUAL       byte = 0x87
+	OP_EQUALVERIFY byte = 0x88
+
+	OP_SHA256 byte = 0xa8
+
+	OP_CHECKSIG            byte = 0xac
+	OP_CHECKSIGVERIFY      byte = 0xad
+	OP_CHECKMULTISIG       byte = 0xae
+	OP_CHECKMULTISIGVERIFY byte = 0xaf
+
+	OP_CHECKLOCKTIMEVERIFY byte = 0xb1
+	OP_CHECKSEQUENCEVERIFY byte = 0xb2
+)
+
+var opNames = map[byte]string{
+	OP_0:                   "OP_0",
+	OP_PUSHDATA1:           "OP_PUSHDATA1",
+	OP_PUSHDATA2:           "OP_PUSHDATA2",
+	OP_1NEGATE:             "OP_1NEGATE",
+	OP_NOP:                 "OP_NOP",
+	OP_IF:                  "OP_IF",
+	OP_NOTIF:               "OP_NOTIF",
+	OP_ELSE:                "OP_ELSE",
+	OP_ENDIF:               "OP_ENDIF",
+	OP_VERIFY:              "OP_VERIFY",
+	OP_RETURN:              "OP_RETURN",
+	OP_DROP:                "OP_DROP",
+	OP_DUP:                 "OP_DUP",
+	OP_OVER:                "OP_OVER",
+	OP_SWAP:                "OP_SWAP",
+	OP_SIZE:                "OP_SIZE",
+	OP_EQUAL:               "OP_EQUAL",
+	OP_EQUALVERIFY:         "OP_EQUALVERIFY",
+	OP_SHA256:              "OP_SHA256",
+	OP_CHECKSIG:            "OP_CHECKSIG",
+	OP_CHECKSIGVERIFY:      "OP_CHECKSIGVERIFY",
+	OP_CHECKMULTISIG:       "OP_CHECKMULTISIG",
+	OP_CHECKMULTISIGVERIFY: "OP_CHECKMULTISIGVERIFY",
+	OP_CHECKLOCKTIMEVERIFY: "OP_CHECKLOCKTIMEVERIFY",
+	OP_CHECKSEQUENCEVERIFY: "OP_CHECKSEQUENCEVERIFY",
+}
+
+// Opcodes by name, OP_1 to OP_16 included
+var opCodes = make(map[string]byte)
+
+func init() {
+	for op := OP_1; op <= OP_16; op++ {
+		opNames[op] = "OP_" + strconv.Itoa(int(op-OP_1+1))
+	}
+
+	for op, name := range opNames {
+		opCodes[name] = op
+	}
+}
+
+func OpName(op byte) string {
+	if name, ok := opNames[op]; ok {
+		return name
+	}
+
+	return "OP_UNKNOWN"
+}
+
+// Data pushes and number pushes, the only opcodes allowed in an unlocking script
+func isPush(op byte) bool {
+	return op <= OP_16
+}
@@ -0,0 +1,234 @@
+package script
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// A serialized script: a sequence of opcodes, each data push followed by its bytes
+type Script []byte
+
+// One decoded opcode, with the data it pushes if any
+type Op struct {
+	Code byte
+	Data []byte
+}
+
+// Decode the opcode at `pc`, returns it with the position of the next one
+func nextOp(script Script, pc int) (Op, int, error) {
+	op := script[pc]
+	pc++
+
+	size := 0
+
+	switch {
+	case op > OP_0 && op <= OP_DATA_MAX:
+		size = int(op)
+
+	case op == OP_PUSHDATA1:
+		if pc+1 > len(script) {
+			return Op{}, 0, errors.New("Truncated OP_PUSHDATA1")
+		}
+
+		size = int(script[pc])
+		pc++
+
+	case op == OP_PUSHDATA2:
+		if pc+2 > len(script) {
+			return Op{}, 0, errors.New("Truncated OP_PUSHDATA2")
+		}
+
+		size = int(script[pc]) | int(script[pc+1])<<8
+		pc += 2
+
+	default:
+		if _, ok := opNames[op]; !ok {
+			return Op{}, 0, errors.New("Unknown opcode 0x" + hex.EncodeToString([]byte{op}))
+		}
+
+		return Op{Code: op}, pc, nil
+	}
+
+	if pc+size > len(script) {
+		return Op{}, 0, errors.New("Truncated data push")
+	}
+
+	return Op{Code: op, Data: script[pc : pc+size]}, pc + size, nil
+}
+
+// Decode the whole script
+func (this Script) Ops() ([]Op, error) {
+	res := []Op{}
+
+	for pc := 0; pc < len(this); {
+		op, next, err := nextOp(this, pc)
+
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, op)
+		pc = next
+	}
+
+	return res, nil
+}
+
+// An unlocking script can only push data, so it cannot change what the locking
+// script does
+func (this Script) IsPushOnly() bool {
+	ops, err := this.Ops()
+
+	if err != nil {
+		return false
+	}
+
+	for _, op := range ops {
+		if !isPush(op.Code) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Human readable form, data pushes are hex encoded
+func (this Script) String() string {
+	ops, err := this.Ops()
+
+	if err != nil {
+		return "[invalid script: " + err.Error() + "]"
+	}
+
+	res := []string{}
+
+	for _, op := range ops {
+		if op.Code > OP_0 && op.Code <= OP_PUSHDATA2 {
+			res = append(res, hex.EncodeToString(op.Data))
+		} else {
+			res = append(res, OpName(op.Code))
+		}
+	}
+
+	return strings.Join(res, " ")
+}
+
+// Hashed before the script to get its address, so it cannot collide with the
+// address of a pub key or of a multisig
+const ADDRESS_TAG = "script"
+
+// Hex encoded hash of the tagged script. The outs locked by a script pay this address
+func (this Script) Address() string {
+	hash := sha256.Sum256(append([]byte(ADDRESS_TAG), this...))
+
+	return hex.EncodeToString(hash[:])
+}
+
+// Parse the human readable form: opcode names, hex data (optionally between '<' and '>')
+// and decimal numbers prefixed by '#'
+func Parse(text string) (Script, error) {
+	builder := NewBuilder()
+
+	for _, token := range strings.Fields(text) {
+		if op, ok := opCodes[strings.ToUpper(token)]; ok {
+			builder.AddOp(op)
+
+			continue
+		}
+
+		if strings.HasPrefix(token, "#") {
+			n, err := strconv.ParseInt(token[1:], 10, 64)
+
+			if err != nil {
+				return nil, errors.New("Invalid number: " + token)
+			}
+
+			builder.AddInt(n)
+
+			continue
+		}
+
+		data, err := hex.DecodeString(strings.Trim(token, "<>"))
+
+		if err != nil {
+			return nil, errors.New("Unknown token: " + token)
+		}
+
+		builder.AddData(data)
+	}
+
+	return builder.Script()
+}
+
+// Build a script with the smallest encoding of each push
+type Builder struct {
+	script Script
+	err    error
+}
+
+func NewBuilder() *Builder {
+	return &Builder{script: Script{}}
+}
+
+func (this *Builder) AddOp(op byte) *Builder {
+	this.script = append(this.script, op)
+
+	return this
+}
+
+func (this *Builder) AddData(data []byte) *Builder {
+	size := len(data)
+
+	switch {
+	case size > MAX_ELEMENT_SIZE:
+		this.err = errors.New("Data push too big: " + strconv.Itoa(size) + " bytes")
+
+		return this
+
+	case size == 0:
+		this.script = append(this.script, OP_0)
+
+	case size <= int(OP_DATA_MAX):
+		this.script = append(this.script, byte(size))
+
+	case size <= 0xff:
+		this.script = append(this.script, OP_PUSHDATA1, byte(size))
+
+	default:
+		this.script = append(this.script, OP_PUSHDATA2, byte(size), byte(size>>8))
+	}
+
+	this.script = append(this.script, data...)
+
+	return this
+}
+
+func (this *Builder) AddInt(n int64) *Builder {
+	switch {
+	case n == -1:
+		return this.AddOp(OP_1NEGATE)
+
+	case n == 0:
+		return this.AddOp(OP_0)
+
+	case n >= 1 && n <= 16:
+		return this.AddOp(OP_1 + byte(n-1))
+	}
+
+	return this.AddData(EncodeNum(n))
+}
+
+func (this *Builder) Script() (Script, error) {
+	if this.err != nil {
+		return nil, this.err
+	}
+
+	if len(this.script) > MAX_SCRIPT_SIZE {
+		return nil, errors.New("Script too big")
+	}
+
+	return this.script, nil
+}
@@ -0,0 +1,490 @@
+package script
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// Signatures are the signed pub key prefixed by "sig"
+type testChecker struct {
+	lockTime int64
+	sequence int64
+}
+
+func (this *testChecker) CheckSig(sig, pub []byte) bool {
+	return bytes.Equal(sig, testSig(pub))
+}
+
+func (this *testChecker) CheckLockTime(lockTime int64) bool {
+	return this.lockTime >= lockTime
+}
+
+func (this *testChecker) CheckSequence(sequence int64) bool {
+	return this.sequence >= sequence
+}
+
+func testSig(pub []byte) []byte {
+	return append([]byte("sig"), pub...)
+}
+
+func testHash(data []byte) []byte {
+	hash := sha256.Sum256(data)
+
+	return hash[:]
+}
+
+func mustParse(t *testing.T, text string) Script {
+	script, err := Parse(text)
+
+	if err != nil {
+		t.Fatal(text, err)
+	}
+
+	return script
+}
+
+// Scripts built by the tests are always valid
+func mustScript(script Script, err error) Script {
+	if err != nil {
+		panic(err)
+	}
+
+	return script
+}
+
+func TestEncodeNum(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, ""},
+		{1, "01"},
+		{-1, "81"},
+		{16, "10"},
+		{127, "7f"},
+		{128, "8000"},
+		{-127, "ff"},
+		{-128, "8080"},
+		{255, "ff00"},
+		{256, "0001"},
+		{-256, "0081"},
+		{32767, "ff7f"},
+		{32768, "008000"},
+		{-32768, "008080"},
+		{1<<31 - 1, "ffffff7f"},
+		{-(1<<31 - 1), "ffffffff"},
+		{1 << 31, "0000008000"},
+		{500000000, "0065cd1d"},
+	}
+
+	for _, test := range tests {
+		if got := hex.EncodeToString(EncodeNum(test.n)); got != test.want {
+			t.Errorf("EncodeNum(%d) = %s, want %s", test.n, got, test.want)
+		}
+
+		n, err := DecodeNum(EncodeNum(test.n), MAX_LOCKTIME_SIZE)
+
+		if err != nil || n != test.n {
+			t.Errorf("DecodeNum(EncodeNum(%d)) = %d, %v", test.n, n, err)
+		}
+	}
+}
+
+func TestDecodeNum(t *testing.T) {
+	tests := []struct {
+		data    string
+		maxSize int
+		want    int64
+		ok      bool
+	}{
+		{"", 4, 0, true},
+		{"ff00", 4, 255, true},
+		{"80ff", 4, -32640, true},
+		{"ffffff7f", 4, 1<<31 - 1, true},
+		{"0000008000", 4, 0, false},
+		{"0000008000", 5, 1 << 31, true},
+		// Non minimal encodings
+		{"00", 4, 0, false},
+		{"80", 4, 0, false},
+		{"0100", 4, 0, false},
+		{"0180", 4, 0, false},
+		{"000000", 4, 0, false},
+	}
+
+	for _, test := range tests {
+		data, _ := hex.DecodeString(test.data)
+		n, err := DecodeNum(data, test.maxSize)
+
+		if (err == nil) != test.ok || n != test.want {
+			t.Errorf("DecodeNum(%s, %d) = %d, %v", test.data, test.maxSize, n, err)
+		}
+	}
+}
+
+func TestAsBool(t *testing.T) {
+	tests := []struct {
+		data string
+		want bool
+	}{
+		{"", false},
+		{"00", false},
+		{"0000", false},
+		{"80", false},
+		{"0080", false},
+		{"01", true},
+		{"0001", true},
+		{"8000", true},
+		{"81", true},
+	}
+
+	for _, test := range tests {
+		data, _ := hex.DecodeString(test.data)
+
+		if asBool(data) != test.want {
+			t.Errorf("asBool(%s) = %v", test.data, !test.want)
+		}
+	}
+}
+
+// Each opcode, run as `unlock` then `lock`
+func TestOpcodes(t *testing.T) {
+	tests := []struct {
+		unlock string
+		lock   string
+		ok     bool
+	}{
+		// Pushes
+		{"", "OP_1", true},
+		{"", "OP_0", false},
+		{"", "", false},
+		{"", "OP_1NEGATE #-1 OP_EQUAL", true},
+		{"", "OP_16 10 OP_EQUAL", true},
+		{"", "#17 11 OP_EQUAL", true},
+		{"", "<0102> 0102 OP_EQUAL", true},
+
+		// Flow control
+		{"", "OP_NOP OP_1", true},
+		{"OP_1", "OP_IF OP_2 OP_ELSE OP_0 OP_ENDIF", true},
+		{"OP_0", "OP_IF OP_2 OP_ELSE OP_0 OP_ENDIF", false},
+		{"OP_0", "OP_NOTIF OP_2 OP_ELSE OP_0 OP_ENDIF", true},
+		{"OP_1", "OP_NOTIF OP_0 OP_ELSE OP_2 OP_ENDIF", true},
+		{"OP_0 OP_0", "OP_IF OP_IF OP_0 OP_ENDIF OP_ELSE OP_IF OP_0 OP_ELSE OP_1 OP_ENDIF OP_ENDIF", true},
+		{"OP_1", "OP_IF OP_1", false},
+		{"", "OP_ENDIF OP_1", false},
+		{"", "OP_ELSE OP_1", false},
+		{"", "OP_IF OP_1 OP_ENDIF", false},
+		{"", "OP_1 OP_VERIFY OP_1", true},
+		{"", "OP_0 OP_VERIFY OP_1", false},
+		{"", "OP_RETURN OP_1", false},
+		{"", "OP_0 OP_IF OP_RETURN OP_ENDIF OP_1", true},
+
+		// Stack
+		{"OP_2", "OP_DUP OP_EQUAL", true},
+		{"OP_2 OP_3", "OP_DROP OP_2 OP_EQUAL", true},
+		{"OP_2 OP_3", "OP_SWAP OP_2 OP_EQUALVERIFY OP_3 OP_EQUAL", true},
+		{"OP_2 OP_3", "OP_OVER OP_2 OP_EQUALVERIFY OP_3 OP_EQUALVERIFY OP_2 OP_EQUAL", true},
+		{"abcdef", "OP_SIZE OP_3 OP_EQUALVERIFY OP_DROP OP_1", true},
+		{"OP_0", "OP_SIZE OP_0 OP_EQUALVERIFY OP_DROP OP_1", true},
+
+		// Comparisons and hashes
+		{"OP_2 OP_2", "OP_EQUAL", true},
+		{"OP_2 OP_3", "OP_EQUAL", false},
+		{"OP_2 OP_3", "OP_EQUALVERIFY OP_1", false},
+		{"616263", "OP_SHA256 ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad OP_EQUAL", true},
+		{"616264", "OP_SHA256 ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad OP_EQUAL", false},
+
+		// Signatures
+		{"7369676b6579 6b6579", "OP_CHECKSIG", true},
+		{"7369676b6579 6f74686572", "OP_CHECKSIG", false},
+		{"OP_0 6b6579", "OP_CHECKSIG", false},
+		{"7369676b6579 6b6579", "OP_CHECKSIGVERIFY OP_1", true},
+		{"7369676b6579 6f74686572", "OP_CHECKSIGVERIFY OP_1", false},
+		{"7369676b6579 OP_1 6b6579 OP_1", "OP_CHECKMULTISIG", true},
+		{"7369676b6579 OP_1 6b6579 OP_1", "OP_CHECKMULTISIGVERIFY OP_1", true},
+		{"OP_0 OP_0", "OP_CHECKMULTISIG", true},
+
+		// Locks
+		{"", "#0 OP_CHECKLOCKTIMEVERIFY OP_DROP OP_1", true},
+		{"", "#1 OP_CHECKLOCKTIMEVERIFY", false},
+		{"", "OP_1NEGATE OP_CHECKLOCKTIMEVERIFY", false},
+		{"", "#0 OP_CHECKSEQUENCEVERIFY OP_DROP OP_1", true},
+		{"", "#1 OP_CHECKSEQUENCEVERIFY", false},
+		{"", "OP_1NEGATE OP_CHECKSEQUENCEVERIFY", false},
+		{"", "0000000000ff OP_CHECKLOCKTIMEVERIFY", false},
+
+		// The unlocking script only pushes data
+		{"OP_DUP", "OP_1", false},
+		{"OP_1 OP_IF OP_ENDIF", "OP_1", false},
+
+		// A single element must remain
+		{"OP_1", "OP_1", false},
+		{"OP_1", "", true},
+	}
+
+	for _, test := range tests {
+		err := Verify(mustParse(t, test.unlock), mustParse(t, test.lock), &testChecker{})
+
+		if (err == nil) != test.ok {
+			t.Errorf("%q | %q: %v", test.unlock, test.lock, err)
+		}
+	}
+}
+
+func TestStackUnderflow(t *testing.T) {
+	tests := []string{
+		"OP_IF OP_ENDIF",
+		"OP_NOTIF OP_ENDIF",
+		"OP_VERIFY",
+		"OP_DROP",
+		"OP_DUP",
+		"OP_1 OP_OVER",
+		"OP_1 OP_SWAP",
+		"OP_SIZE",
+		"OP_1 OP_EQUAL",
+		"OP_1 OP_EQUALVERIFY",
+		"OP_SHA256",
+		"OP_1 OP_CHECKSIG",
+		"OP_1 OP_CHECKSIGVERIFY",
+		"OP_CHECKMULTISIG",
+		"OP_2 OP_CHECKMULTISIG",
+		"OP_1 OP_1 OP_1 OP_CHECKMULTISIG",
+		"OP_CHECKMULTISIGVERIFY",
+		"OP_CHECKLOCKTIMEVERIFY",
+		"OP_CHECKSEQUENCEVERIFY",
+	}
+
+	for _, lock := range tests {
+		err := Verify(Script{}, mustParse(t, lock), &testChecker{})
+
+		if err == nil || !strings.Contains(err.Error(), "Stack too small") && !strings.Contains(err.Error(), "Empty stack") {
+			t.Errorf("%q: %v", lock, err)
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	checker := &testChecker{}
+
+	builder := NewBuilder()
+
+	for i := 0; i < MAX_OPS; i++ {
+		builder.AddOp(OP_NOP)
+	}
+
+	if err := Verify(Script{}, mustScript(builder.AddOp(OP_1).Script()), checker); err != nil {
+		t.Fatal("max ops refused:", err)
+	}
+
+	if err := Verify(Script{}, mustScript(builder.AddOp(OP_NOP).Script()), checker); err == nil {
+		t.Fatal("too many ops accepted")
+	}
+
+	// The keys of a multisig count as opcodes
+	builder = NewBuilder()
+
+	for i := 0; i < MAX_OPS-MAX_MULTISIG_KEYS; i++ {
+		builder.AddOp(OP_NOP)
+	}
+
+	multisig := mustParse(t, "OP_0 #16 OP_CHECKMULTISIG")
+
+	if err := Verify(Script{}, append(mustScript(builder.Script()), multisig...), checker); err == nil {
+		t.Fatal("too many multisig ops accepted")
+	}
+
+	if err := Verify(Script{}, mustParse(t, "OP_0 #17 OP_CHECKMULTISIG"), checker); err == nil {
+		t.Fatal("too many multisig keys accepted")
+	}
+
+	builder = NewBuilder()
+
+	for i := 0; i < MAX_STACK_SIZE+1; i++ {
+		builder.AddOp(OP_1)
+	}
+
+	if err := Verify(Script{}, mustScript(builder.Script()), checker); err == nil {
+		t.Fatal("stack too big accepted")
+	}
+
+	if _, err := NewBuilder().AddData(make([]byte, MAX_ELEMENT_SIZE+1)).Script(); err == nil {
+		t.Fatal("element too big built")
+	}
+
+	big := append(Script{OP_PUSHDATA2, byte(MAX_ELEMENT_SIZE + 1), byte((MAX_ELEMENT_SIZE + 1) >> 8)}, make([]byte, MAX_ELEMENT_SIZE+1)...)
+
+	if err := Verify(Script{}, append(big, OP_DROP, OP_1), checker); err == nil {
+		t.Fatal("element too big accepted")
+	}
+
+	if err := Verify(make(Script, MAX_SCRIPT_SIZE+1), mustParse(t, "OP_1"), checker); err == nil {
+		t.Fatal("unlocking script too big accepted")
+	}
+
+	if err := Verify(Script{}, make(Script, MAX_SCRIPT_SIZE+1), checker); err == nil {
+		t.Fatal("locking script too big accepted")
+	}
+
+	builder = NewBuilder()
+
+	for i := 0; i < MAX_SCRIPT_SIZE/(MAX_ELEMENT_SIZE+3)+1; i++ {
+		builder.AddData(make([]byte, MAX_ELEMENT_SIZE))
+	}
+
+	if _, err := builder.Script(); err == nil {
+		t.Fatal("script too big built")
+	}
+}
+
+func TestMalformedScripts(t *testing.T) {
+	tests := []Script{
+		{0x05, 0x01},
+		{OP_PUSHDATA1},
+		{OP_PUSHDATA1, 0x02, 0x01},
+		{OP_PUSHDATA2, 0x01},
+		{OP_PUSHDATA2, 0x01, 0x00},
+		{0x50},
+		{0xff},
+	}
+
+	for _, script := range tests {
+		if _, err := script.Ops(); err == nil {
+			t.Errorf("%x: decoded", []byte(script))
+		}
+
+		if err := Verify(Script{}, script, &testChecker{}); err == nil {
+			t.Errorf("%x: accepted", []byte(script))
+		}
+	}
+}
+
+// Pushes use the smallest encoding, and the text form parses back to the same script
+func TestBuilderPushes(t *testing.T) {
+	tests := []struct {
+		size   int
+		prefix string
+	}{
+		{1, "01"},
+		{75, "4b"},
+		{76, "4c4c"},
+		{255, "4cff"},
+		{256, "4d0001"},
+		{520, "4d0802"},
+	}
+
+	for _, test := range tests {
+		script := mustScript(NewBuilder().AddData(make([]byte, test.size)).Script())
+
+		if !strings.HasPrefix(hex.EncodeToString(script), test.prefix) || len(script) != len(test.prefix)/2+test.size {
+			t.Errorf("%d bytes: bad push %x", test.size, []byte(script)[:len(test.prefix)/2])
+		}
+
+		ops, err := script.Ops()
+
+		if err != nil || len(ops) != 1 || len(ops[0].Data) != test.size {
+			t.Errorf("%d bytes: bad ops %v", test.size, err)
+		}
+
+		parsed, err := Parse(script.String())
+
+		if err != nil || !bytes.Equal(parsed, script) {
+			t.Errorf("%d bytes: does not parse back %v", test.size, err)
+		}
+	}
+
+	ints := []struct {
+		n    int64
+		want string
+	}{
+		{-1, "4f"},
+		{0, "00"},
+		{1, "51"},
+		{16, "60"},
+		{17, "0111"},
+		{-2, "0182"},
+	}
+
+	for _, test := range ints {
+		script := mustScript(NewBuilder().AddInt(test.n).Script())
+
+		if hex.EncodeToString(script) != test.want {
+			t.Errorf("AddInt(%d) = %x, want %s", test.n, []byte(script), test.want)
+		}
+	}
+
+	if _, err := Parse("OP_FOO"); err == nil {
+		t.Error("unknown opcode parsed")
+	}
+}
+
+func TestAddress(t *testing.T) {
+	script := mustParse(t, "OP_1")
+
+	if script.Address() != hex.EncodeToString(testHash([]byte("script\x51"))) {
+		t.Fatal("bad address", script.Address())
+	}
+
+	if script.Address() == hex.EncodeToString(testHash(script)) {
+		t.Fatal("address is not tagged")
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	alice, bob, carol := []byte("alice"), []byte("bob"), []byte("carol")
+	secret := []byte("secret")
+
+	payToAlice := mustScript(PayToPubKeyHash(testHash(alice)))
+	hashLock := mustScript(HashLock(testHash(secret), testHash(alice)))
+	multisig := mustScript(MultiSig(2, [][]byte{alice, bob, carol}))
+	htlc := mustScript(HashTimeLock(testHash(secret), testHash(bob), testHash(alice), 100))
+
+	tests := []struct {
+		name     string
+		unlock   Script
+		lock     Script
+		lockTime int64
+		sequence int64
+		ok       bool
+	}{
+		{"p2pkh", mustScript(UnlockPubKeyHash(testSig(alice), alice)), payToAlice, 0, 0, true},
+		{"p2pkh other key", mustScript(UnlockPubKeyHash(testSig(bob), bob)), payToAlice, 0, 0, false},
+		{"p2pkh bad sig", mustScript(UnlockPubKeyHash([]byte("x"), alice)), payToAlice, 0, 0, false},
+		{"p2pkh empty", Script{}, payToAlice, 0, 0, false},
+
+		{"hashlock", mustScript(UnlockHashLock(testSig(alice), alice, secret)), hashLock, 0, 0, true},
+		{"hashlock bad preimage", mustScript(UnlockHashLock(testSig(alice), alice, []byte("nope"))), hashLock, 0, 0, false},
+
+		{"timelock reached", mustScript(UnlockPubKeyHash(testSig(alice), alice)),
+			mustScript(TimeLock(100, testHash(alice))), 100, 0, true},
+		{"timelock not reached", mustScript(UnlockPubKeyHash(testSig(alice), alice)),
+			mustScript(TimeLock(101, testHash(alice))), 100, 0, false},
+		{"relative timelock reached", mustScript(UnlockPubKeyHash(testSig(alice), alice)),
+			mustScript(RelativeTimeLock(5, testHash(alice))), 0, 5, true},
+		{"relative timelock not reached", mustScript(UnlockPubKeyHash(testSig(alice), alice)),
+			mustScript(RelativeTimeLock(6, testHash(alice))), 0, 5, false},
+
+		{"multisig a b", mustScript(UnlockMultiSig([][]byte{testSig(alice), testSig(bob)})), multisig, 0, 0, true},
+		{"multisig a c", mustScript(UnlockMultiSig([][]byte{testSig(alice), testSig(carol)})), multisig, 0, 0, true},
+		{"multisig wrong order", mustScript(UnlockMultiSig([][]byte{testSig(bob), testSig(alice)})), multisig, 0, 0, false},
+		{"multisig same key twice", mustScript(UnlockMultiSig([][]byte{testSig(alice), testSig(alice)})), multisig, 0, 0, false},
+		{"multisig missing sig", mustScript(UnlockMultiSig([][]byte{testSig(alice)})), multisig, 0, 0, false},
+		{"multisig empty sig", mustScript(UnlockMultiSig([][]byte{testSig(alice), {}})), multisig, 0, 0, false},
+
+		{"htlc redeem", mustScript(UnlockHashTimeLockRedeem(testSig(bob), bob, secret)), htlc, 0, 0, true},
+		{"htlc redeem by refund key", mustScript(UnlockHashTimeLockRedeem(testSig(alice), alice, secret)), htlc, 0, 0, false},
+		{"htlc redeem bad secret", mustScript(UnlockHashTimeLockRedeem(testSig(bob), bob, []byte("x"))), htlc, 0, 0, false},
+		{"htlc refund", mustScript(UnlockHashTimeLockRefund(testSig(alice), alice)), htlc, 100, 0, true},
+		{"htlc early refund", mustScript(UnlockHashTimeLockRefund(testSig(alice), alice)), htlc, 99, 0, false},
+		{"htlc refund by recipient", mustScript(UnlockHashTimeLockRefund(testSig(bob), bob)), htlc, 100, 0, false},
+	}
+
+	for _, test := range tests {
+		checker := &testChecker{lockTime: test.lockTime, sequence: test.sequence}
+
+		if err := Verify(test.unlock, test.lock, checker); (err == nil) != test.ok {
+			t.Errorf("%s: %v", test.name, err)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package script
+
+// Common locking scripts and the unlocking scripts spending them.
+// Pub keys are PEM encoded, and pub key hashes are their sha256
+
+// Spendable by the owner of the pub key
+func PayToPubKeyHash(pubHash []byte) (Script, error) {
+	return NewBuilder().
+		AddOp(OP_DUP).
+		AddOp(OP_SHA256).
+		AddData(pubHash).
+		AddOp(OP_EQUALVERIFY).
+		AddOp(OP_CHECKSIG).
+		Script()
+}
+
+func UnlockPubKeyHash(sig, pub []byte) (Script, error) {
+	return NewBuilder().
+		AddData(sig).
+		AddData(pub).
+		Script()
+}
+
+// Spendable by the owner of the pub key, once they reveal the preimage of `hash`
+func HashLock(hash, pubHash []byte) (Script, error) {
+	return NewBuilder().
+		AddOp(OP_SHA256).
+		AddData(hash).
+		AddOp(OP_EQUALVERIFY).
+		AddOp(OP_DUP).
+		AddOp(OP_SHA256).
+		AddData(pubHash).
+		AddOp(OP_EQUALVERIFY).
+		AddOp(OP_CHECKSIG).
+		Script()
+}
+
+func UnlockHashLock(sig, pub, preimage []byte) (Script, error) {
+	return NewBuilder().
+		AddData(sig).
+		AddData(pub).
+		AddData(preimage).
+		Script()
+}
+
+// Spendable by the owner of the pub key in a transaction with a LockTime of at least
+// `lockTime` (a block height, or a timestamp if >= 500000000)
+func TimeLock(lockTime int64, pubHash []byte) (Script, error) {
+	return NewBuilder().
+		AddInt(lockTime).
+		AddOp(OP_CHECKLOCKTIMEVERIFY).
+		AddOp(OP_DROP).
+		AddOp(OP_DUP).
+		AddOp(OP_SHA256).
+		AddData(pubHash).
+		AddOp(OP_EQUALVERIFY).
+		AddOp(OP_CHECKSIG).
+		Script()
+}
+
+// Spendable by the owner of the pub key, `sequence` blocks after the out is confirmed
+func RelativeTimeLock(sequence int64, pubHash []byte) (Script, error) {
+	return NewBuilder().
+		AddInt(sequence).
+		AddOp(OP_CHECKSEQUENCEVERIFY).
+		AddOp(OP_DROP).
+		AddOp(OP_DUP).
+		AddOp(OP_SHA256).
+		AddData(pubHash).
+		AddOp(OP_EQUALVERIFY).
+		AddOp(OP_CHECKSIG).
+		Script()
+}
+
+// Spendable with `m` signatures of the given keys
+func MultiSig(m int, pubs [][]byte) (Script, error) {
+	builder := NewBuilder().AddInt(int64(m))
+
+	for _, pub := range pubs {
+		builder.AddData(pub)
+	}
+
+	return builder.
+		AddInt(int64(len(pubs))).
+		AddOp(OP_CHECKMULTISIG).
+		Script()
+}
+
+// Signatures must be given in the order of their keys in the locking script
+func UnlockMultiSig(sigs [][]byte) (Script, error) {
+	builder := NewBuilder()
+
+	for _, sig := range sigs {
+		builder.AddData(sig)
+	}
+
+	return builder.Script()
+}
+
+// Hash time-locked contract: spendable by the recipient with the preimage of `hash`,
+// or by the refund key once `lockTime` is reached
+func HashTimeLock(hash, recipientPubHash, refundPubHash []byte, lockTime int64) (Script, error) {
+	return NewBuilder().
+		AddOp(OP_IF).
+		AddOp(OP_SHA256).
+		AddData(hash).
+		AddOp(OP_EQUALVERIFY).
+		AddOp(OP_DUP).
+		AddOp(OP_SHA256).
+		AddData(recipientPubHash).
+		AddOp(OP_ELSE).
+		AddInt(lockTime).
+		AddOp(OP_CHECKLOCKTIMEVERIFY).
+		AddOp(OP_DROP).
+		AddOp(OP_DUP).
+		AddOp(OP_SHA256).
+		AddData(refundPubHash).
+		AddOp(OP_ENDIF).
+		AddOp(OP_EQUALVERIFY).
+		AddOp(OP_CHECKSIG).
+		Script()
+}
+
+func UnlockHashTimeLockRedeem(sig, pub, preimage []byte) (Script, error) {
+	return NewBuilder().
+		AddData(sig).
+		AddData(pub).
+		AddData(preimage).
+		AddInt(1).
+		Script()
+}
+
+func UnlockHashTimeLockRefund(sig, pub []byte) (Script, error) {
+	return NewBuilder().
+		AddData(sig).
+		AddData(pub).
+		AddInt(0).
+		Script()
+}
@@ -0,0 +1,248 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"strconv"
+)
+
+// A snapshot is the set of unspent outs at a given block, with the headers up to
+// it. A node loading one follows the chain from that block without downloading
+// the ones before, so the snapshot must be checked against a hash known in advance.
+
+var (
+	// Version of the snapshot format, first field of every snapshot
+	SNAPSHOT_VERSION uint32 = 1
+)
+
+type Snapshot struct {
+	ChainID uint32
+	Height  int64
+	TipHash []byte
+
+	// Headers from height 1 to Height
+	Headers []BlockHeader
+
+	// Sorted by outpoint: TxHash, then InIdx
+	Outs []UnspentTxOut
+
+	// sha256 of the encoded snapshot, that ends with it
+	Hash []byte
+}
+
+// Snapshot of our unspent outs at our last block
+func (this *Blockchain) TakeSnapshot() *Snapshot {
+	this.RLock()
+	defer this.RUnlock()
+
+	tip := this.headers[len(this.headers)-1]
+
+	snapshot := &Snapshot{
+		ChainID: this.params.ID,
+		Height:  tip.Height,
+		TipHash: tip.Hash,
+		Headers: append([]BlockHeader{}, this.headers[1:]...),
+		Outs:    this.sortedUnspentOuts(),
+	}
+
+	return snapshot
+}
+
+// Also sets the hash of the snapshot
+func EncodeSnapshot(snapshot *Snapshot) []byte {
+	enc := &encoder{}
+
+	enc.uint32(SNAPSHOT_VERSION)
+	enc.uint32(snapshot.ChainID)
+	enc.int64(snapshot.Height)
+	enc.bytes(snapshot.TipHash)
+
+	enc.uint32(uint32(len(snapshot.Headers)))
+
+	for i := range snapshot.Headers {
+		encodeHeader(enc, &snapshot.Headers[i])
+	}
+
+	enc.uint32(uint32(len(snapshot.Outs)))
+
+	for i := range snapshot.Outs {
+		encodeUnspentOut(enc, &snapshot.Outs[i])
+	}
+
+	hash := sha256.Sum256(enc.Bytes())
+	snapshot.Hash = hash[:]
+
+	enc.buf.Write(snapshot.Hash)
+
+	return enc.Bytes()
+}
+
+func DecodeSnapshot(data []byte) (*Snapshot, error) {
+	if len(data) < sha256.Size {
+		return nil, errors.New("Bad snapshot: Unexpected end of data")
+	}
+
+	body := data[:len(data)-sha256.Size]
+	hash := sha256.Sum256(body)
+
+	if compare(hash[:], data[len(body):]) != 0 {
+		return nil, errors.New("Bad snapshot: Hash does not match its content")
+	}
+
+	dec := &decoder{data: body}
+	snapshot := &Snapshot{Hash: hash[:]}
+
+	if version := dec.uint32(); dec.err == nil && version != SNAPSHOT_VERSION {
+		dec.fail("Unknown snapshot version " + strconv.FormatUint(uint64(version), 10))
+	}
+
+	snapshot.ChainID = dec.uint32()
+	snapshot.Height = dec.int64()
+	snapshot.TipHash = dec.bytes()
+
+	// A header takes at least 44 bytes
+	snapshot.Headers = make([]BlockHeader, dec.count(44))
+
+	for i := range snapshot.Headers {
+		snapshot.Headers[i] = *decodeHeader(dec)
+	}
+
+	// An out takes at least 37 bytes
+	snapshot.Outs = make([]UnspentTxOut, dec.count(37))
+
+	for i := range snapshot.Outs {
+		snapshot.Outs[i] = decodeUnspentOut(dec)
+	}
+
+	if err := dec.end(); err != nil {
+		return nil, errors.New("Bad snapshot: " + err.Error())
+	}
+
+	for i := range snapshot.Headers {
+		snapshot.Headers[i].Hash = snapshot.Headers[i].ComputeHash()
+	}
+
+	return snapshot, nil
+}
+
+func (this *Blockchain) DumpSnapshot(path string) error {
+	snapshot := this.TakeSnapshot()
+
+	if err := ioutil.WriteFile(path, EncodeSnapshot(snapshot), 0644); err != nil {
+		return err
+	}
+
+	this.logger.Info("Snapshot of", len(snapshot.Outs), "unspent outs at block", snapshot.Height, "written to", path)
+	this.logger.Info("Snapshot hash:", hex.EncodeToString(snapshot.Hash))
+
+	return nil
+}
+
+// Replace our chain by the snapshot in the file, if its hash is the one given by
+// the options or the one the chain params know for its height, or if its tip is
+// a checkpoint or the assumed valid block. Only a node without blocks can load one
+func (this *Blockchain) LoadSnapshot(path string) error {
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return err
+	}
+
+	snapshot, err := DecodeSnapshot(data)
+
+	if err != nil {
+		return err
+	}
+
+	if err := this.verifySnapshot(snapshot); err != nil {
+		return err
+	}
+
+	this.Lock()
+	defer this.Unlock()
+
+	if this.BlocksHeight() > 0 {
+		return errors.New("Cannot load a snapshot: the node already has blocks")
+	}
+
+	this.headers = append(this.headers[:1], snapshot.Headers...)
+	this.unspentTxOut = make(map[string][]UnspentTxOut)
+
+	for _, unspent := range snapshot.Outs {
+		walletStr := string(unspent.Out.Address)
+
+		this.unspentTxOut[walletStr] = append(this.unspentTxOut[walletStr], unspent)
+	}
+
+	this.utxos = NewUtxoTree(snapshot.Outs)
+
+	// Target of the next block
+	tip := this.headers[len(this.headers)-1]
+	this.lastTarget = tip.Target
+
+	if this.params.RetargetInterval > 0 && tip.Height%this.params.RetargetInterval == 0 {
+		this.adjustDifficulty(&Block{Header: tip})
+	}
+
+	if err := StoreAllHeaders(this); err != nil {
+		return err
+	}
+
+	if err := StoreUnspent(this); err != nil {
+		return err
+	}
+
+	this.logger.Info("Loaded a snapshot of", len(snapshot.Outs), "unspent outs at block", snapshot.Height)
+
+	return nil
+}
+
+func (this *Blockchain) verifySnapshot(snapshot *Snapshot) error {
+	if snapshot.ChainID != this.params.ID {
+		return errors.New("Snapshot of another chain")
+	}
+
+	expected := this.options.SnapshotHash
+
+	if expected == nil {
+		expected = this.params.Snapshots[snapshot.Height]
+	}
+
+	// Without a known hash, the tip must be trusted for its UTXO root to be
+	trusted := compare(this.params.Checkpoints[snapshot.Height], snapshot.TipHash) == 0 ||
+		compare(this.params.AssumeValid, snapshot.TipHash) == 0
+
+	if expected == nil && !trusted {
+		return errors.New("No known hash for a snapshot at block " + strconv.FormatInt(snapshot.Height, 10))
+	}
+
+	if expected != nil && compare(snapshot.Hash, expected) != 0 {
+		return errors.New("Snapshot hash does not match the known one")
+	}
+
+	if snapshot.Height <= 0 || int64(len(snapshot.Headers)) != snapshot.Height {
+		return errors.New("Bad snapshot headers count")
+	}
+
+	prec := &this.headers[0]
+
+	for i := range snapshot.Headers {
+		if err := this.verifyHeader(prec, &snapshot.Headers[i]); err != nil {
+			return errors.New("Bad snapshot headers: " + err.Error())
+		}
+
+		prec = &snapshot.Headers[i]
+	}
+
+	if compare(prec.Hash, snapshot.TipHash) != 0 {
+		return errors.New("Snapshot tip does not match its headers")
+	}
+
+	if compare(prec.UtxoRoot, UtxoRoot(snapshot.Outs)) != 0 {
+		return errors.New("Snapshot outs do not match the UTXO root of its tip")
+	}
+
+	return nil
+}
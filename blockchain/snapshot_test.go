@@ -0,0 +1,88 @@
+package blockchain
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	src := newTestNode(t, RegtestParams)
+	dest := newTestWallet(t, "dest")
+
+	mineTestBlock(t, src)
+
+	if _, err := src.SendTo([]string{"60:" + SanitizePubKey(dest.pub)}, SendOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	mineTestBlock(t, src)
+
+	file := filepath.Join(t.TempDir(), "snapshot")
+
+	if err := src.DumpSnapshot(file); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot := src.TakeSnapshot()
+
+	decoded, err := DecodeSnapshot(EncodeSnapshot(snapshot))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if compare(decoded.Hash, snapshot.Hash) != 0 {
+		t.Fatal("decoded snapshot has another hash")
+	}
+
+	// Tampering changes the hash
+	tampered := EncodeSnapshot(snapshot)
+	tampered[20] ^= 1
+
+	if decoded, err := DecodeSnapshot(tampered); err == nil && compare(decoded.Hash, snapshot.Hash) == 0 {
+		t.Fatal("tampered snapshot has the same hash")
+	}
+
+	dst := newTestNode(t, RegtestParams)
+
+	if err := dst.LoadSnapshot(file); err == nil {
+		t.Fatal("snapshot loaded without a known hash")
+	}
+
+	dst.options.SnapshotHash = NewHash(nil)
+
+	if err := dst.LoadSnapshot(file); err == nil {
+		t.Fatal("snapshot loaded with another hash")
+	}
+
+	dst.options.SnapshotHash = snapshot.Hash
+
+	if err := dst.LoadSnapshot(file); err != nil {
+		t.Fatal(err)
+	}
+
+	if dst.BlocksHeight() != src.BlocksHeight() {
+		t.Fatal("bad height once loaded:", dst.BlocksHeight())
+	}
+
+	if compare(dst.utxos.Root(), src.utxos.Root()) != 0 {
+		t.Fatal("loaded UTXO tree differs")
+	}
+
+	if err := dst.LoadSnapshot(file); err == nil {
+		t.Fatal("snapshot loaded twice")
+	}
+
+	// The next block commits to the UTXO root of the loaded tree
+	mineTestBlock(t, dst)
+
+	// A checkpoint of the tip is trusted without a hash
+	params := RegtestParams
+	params.Checkpoints = map[int64][]byte{snapshot.Height: snapshot.TipHash}
+
+	trusting := newTestNode(t, params)
+
+	if err := trusting.LoadSnapshot(file); err != nil {
+		t.Fatal("snapshot of a checkpoint refused:", err)
+	}
+}
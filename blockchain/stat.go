@@ -1,6 +1,7 @@
 package blockchain
 
 import (
+	"encoding/hex"
 	"fmt"
 	"time"
 
@@ -48,11 +49,14 @@ func (this *Blockchain) StatsLoop() {
 		goterm.MoveCursor(1, 1)
 		goterm.Println("Crypto DHT v0.0.1          Current Time: ", time.Now().Format(time.RFC1123))
 		goterm.Println("")
+		goterm.Println("Chain:          ", this.params.Name)
 		goterm.Println("Synced:         ", this.synced)
 		goterm.Println("Mining:         ", this.options.Mine)
 		goterm.Println("")
 		goterm.Println("Funds:          ", this.GetAvailableFunds(this.wallets["main.key"].pub), "ctd")
+		goterm.Println("Immature:       ", this.GetImmatureFunds(this.wallets["main.key"].pub), "ctd")
 		goterm.Println("Blocks height:  ", this.BlocksHeight())
+		goterm.Println("Supply:         ", this.TotalSupply(), "ctd")
 		goterm.Println("Address:        ", SanitizePubKey(this.wallets["main.key"].pub))
 		goterm.Println("")
 
@@ -73,11 +77,142 @@ func (this *Blockchain) StatsLoop() {
 	goterm.Flush() // Call it every time at the end of rendering
 }
 
+func (this *Blockchain) ShowSupply() {
+	height := this.BlocksHeight()
+
+	fmt.Println("Chain:        ", this.params.Name)
+	fmt.Println("Blocks height:", height)
+	fmt.Println("Subsidy:      ", this.params.Subsidy(height+1), "ctd")
+	fmt.Println("Supply:       ", this.TotalSupply(), "ctd")
+
+	if max := this.MaxSupply(); max > 0 {
+		fmt.Println("Max supply:   ", max, "ctd")
+		fmt.Println("Next halving: ", "block", (height/this.params.HalvingInterval+1)*this.params.HalvingInterval)
+	} else {
+		fmt.Println("Max supply:   ", "not capped")
+	}
+}
+
 func (this *Blockchain) ShowWallets() {
 	for name, wallet := range this.wallets {
 		fmt.Println("Name:    ", name)
 		fmt.Println("Address: ", SanitizePubKey(wallet.pub))
+		fmt.Println("Pub:     ", hex.EncodeToString(wallet.pub))
 		fmt.Println("Amount:  ", this.GetAvailableFunds(wallet.pub))
+		fmt.Println("Immature:", this.GetImmatureFunds(wallet.pub))
 		fmt.Println("")
 	}
+
+	for _, multisig := range this.multisigs {
+		this.ShowMultisig(multisig)
+	}
+}
+
+func (this *Blockchain) ShowHistory(walletName string, page, pageSize int) {
+	txs, total := this.GetHistory(walletName, page*pageSize, pageSize)
+
+	fmt.Println("Wallet:  ", walletName)
+	fmt.Println("Page:    ", page, "(", len(txs), "of", total, "transactions )")
+	fmt.Println("")
+
+	for _, tx := range txs {
+		fmt.Println("Tx:            ", tx.TxHash)
+		fmt.Println("Block:         ", tx.Height, "(", tx.Confirmations, "confirmations )")
+		fmt.Println("Date:          ", time.Unix(tx.Timestamp, 0).Format(time.RFC1123))
+		fmt.Println("Counterparty:  ", tx.Address)
+		fmt.Println("Amount:        ", tx.Amount)
+		fmt.Println("Fee:           ", tx.Fee)
+
+		if len(tx.Data) > 0 {
+			fmt.Println("Data:          ", tx.Data)
+		}
+
+		fmt.Println("")
+	}
+}
+
+func (this *Blockchain) ShowLockedTransaction(tx *Transaction) {
+	raw, err := EncodeRawTransaction(tx)
+
+	if err != nil {
+		this.logger.Error("Cannot encode transaction", err)
+
+		return
+	}
+
+	fmt.Println("Transaction locked until", lockTimeString(tx.LockTime))
+	fmt.Println("Keep it and broadcast it once unlocked with --broadcast:")
+	fmt.Println("")
+	fmt.Println(raw)
+}
+
+func (this *Blockchain) ShowMultisig(multisig *Multisig) {
+	fmt.Println("Address:   ", multisig.Address())
+	fmt.Println("Signatures:", multisig.M, "of", len(multisig.Pubs))
+	fmt.Println("Amount:    ", this.GetAddressFunds(multisig.Address()))
+	fmt.Println("")
+}
+
+func (this *Blockchain) ShowPartialTransaction(tx *Transaction) {
+	raw, err := EncodeRawTransaction(tx)
+
+	if err != nil {
+		this.logger.Error("Cannot encode transaction", err)
+
+		return
+	}
+
+	if missing := tx.MissingSignatures(); missing > 0 {
+		fmt.Println("Transaction needs", missing, "more signatures")
+		fmt.Println("Have it signed with --multisig-sign, or merge the signed copies with --multisig-combine:")
+	} else {
+		fmt.Println("Transaction fully signed, broadcast it with --broadcast:")
+	}
+
+	fmt.Println("")
+	fmt.Println(raw)
+}
+
+func (this *Blockchain) ShowScriptAddress(text string) {
+	address, lock, err := ScriptAddress(text)
+
+	if err != nil {
+		this.logger.Error("Invalid script", err)
+
+		return
+	}
+
+	fmt.Println("Script: ", lock.String())
+	fmt.Println("Hex:    ", hex.EncodeToString(lock))
+	fmt.Println("Address:", address)
+	fmt.Println("Amount: ", this.GetAddressFunds(address))
+}
+
+func (this *Blockchain) ShowHTLC(contract *HTLC) {
+	address, _ := contract.Address()
+	amount, _ := this.AuditHTLC(contract)
+
+	fmt.Println("Contract: ", contract.Encode())
+	fmt.Println("Address:  ", address)
+	fmt.Println("Hash:     ", hex.EncodeToString(contract.Hash))
+	fmt.Println("Recipient:", hex.EncodeToString(contract.Recipient))
+	fmt.Println("Refund:   ", hex.EncodeToString(contract.Refund))
+	fmt.Println("Timeout:  ", lockTimeString(contract.Timeout))
+	fmt.Println("Amount:   ", amount)
+
+	if secret := this.GetSecret(contract.Hash); secret != nil {
+		fmt.Println("Secret:   ", hex.EncodeToString(secret))
+	}
+}
+
+func (this *Blockchain) ShowDataSearch(query string) {
+	refs := this.SearchData(query, 0)
+
+	for _, ref := range refs {
+		data, _ := hex.DecodeString(ref.Data)
+
+		fmt.Println(ref.Height, time.Unix(ref.Timestamp, 0).Format(time.RFC1123), ref.TxHash, DataString(data))
+	}
+
+	fmt.Println(len(refs), "data outs found")
 }
@@ -1,11 +1,13 @@
 package blockchain
 
 import (
+	"encoding/hex"
 	"errors"
 	"io/ioutil"
 	"net/url"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/vmihailenco/msgpack"
 )
@@ -15,7 +17,7 @@ func SetupStorage(bc *Blockchain) error {
 	stat, err := os.Stat(bc.options.Folder)
 
 	if err != nil {
-		os.Mkdir(bc.options.Folder, 0755)
+		os.MkdirAll(bc.options.Folder, 0755)
 	} else {
 		if !stat.IsDir() {
 			return errors.New(bc.options.Folder + " is not a folder")
@@ -40,6 +42,24 @@ func SetupStorage(bc *Blockchain) error {
 		}
 	}
 
+	stat, err = os.Stat(bc.options.Folder + "/history")
+	if err != nil {
+		os.Mkdir(bc.options.Folder+"/history", 0755)
+	} else {
+		if !stat.IsDir() {
+			return errors.New(bc.options.Folder + "/history" + " is not a folder")
+		}
+	}
+
+	stat, err = os.Stat(bc.options.Folder + "/multisig")
+	if err != nil {
+		os.Mkdir(bc.options.Folder+"/multisig", 0755)
+	} else {
+		if !stat.IsDir() {
+			return errors.New(bc.options.Folder + "/multisig" + " is not a folder")
+		}
+	}
+
 	stat, err = os.Stat(bc.options.Folder + "/wallets")
 	if err != nil {
 		os.Mkdir(bc.options.Folder+"/wallets", 0755)
@@ -76,8 +96,10 @@ func LoadStoredHeaders(bc *Blockchain) error {
 		return err
 	}
 
-	for _, file := range dir {
-		headersByte, err := ioutil.ReadFile(bc.options.Folder + "/chain/" + file.Name())
+	// The files are named by number, and must be read in order
+	for number := range dir {
+		fileNumber := strconv.Itoa(number)
+		headersByte, err := ioutil.ReadFile(bc.options.Folder + "/chain/" + fileNumber)
 
 		if err != nil {
 			return err
@@ -93,7 +115,7 @@ func LoadStoredHeaders(bc *Blockchain) error {
 		bc.headers = append(bc.headers, headers...)
 
 		if !bc.AreHeadersGood() {
-			return errors.New("Load headers: Bad blocks loaded in file " + file.Name())
+			return errors.New("Load headers: Bad blocks loaded in file " + fileNumber)
 		}
 	}
 
@@ -103,17 +125,41 @@ func LoadStoredHeaders(bc *Blockchain) error {
 }
 
 func StoreLastHeaders(bc *Blockchain) error {
-	headersLen := len(bc.headers)
+	return storeHeadersFile(bc, (len(bc.headers)-1)/1000)
+}
+
+// Rewrite every headers file, when the headers were not added one by one
+func StoreAllHeaders(bc *Blockchain) error {
+	for number := 0; number <= (len(bc.headers)-1)/1000; number++ {
+		if err := storeHeadersFile(bc, number); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// The file k holds the headers from height k * 1000 to k * 1000 + 999, but the
+// genesis one
+func storeHeadersFile(bc *Blockchain, number int) error {
+	start := number * 1000
+	end := start + 1000
 
-	nb := headersLen % 1000
+	if start == 0 {
+		start = 1
+	}
+
+	if end > len(bc.headers) {
+		end = len(bc.headers)
+	}
 
-	toStore, err := msgpack.Marshal(bc.headers[1+headersLen-nb:])
+	toStore, err := msgpack.Marshal(bc.headers[start:end])
 
 	if err != nil {
 		return err
 	}
 
-	fileNumber := strconv.Itoa(headersLen / 1000)
+	fileNumber := strconv.Itoa(number)
 
 	err = ioutil.WriteFile(bc.options.Folder+"/chain/"+fileNumber, toStore, 0644)
 
@@ -121,7 +167,7 @@ func StoreLastHeaders(bc *Blockchain) error {
 		return err
 	}
 
-	bc.logger.Debug("Stored", nb-1, "blocks in file", fileNumber)
+	bc.logger.Debug("Stored", end-start, "blocks in file", fileNumber)
 
 	return nil
 }
@@ -152,6 +198,8 @@ func LoadUnspent(bc *Blockchain) error {
 		bc.unspentTxOut[wallet] = unspents
 	}
 
+	bc.utxos = NewUtxoTree(bc.sortedUnspentOuts())
+
 	bc.logger.Debug("Loaded", len(bc.unspentTxOut), "wallets unspent out")
 
 	return nil
@@ -176,3 +224,252 @@ func StoreUnspent(bc *Blockchain) error {
 
 	return nil
 }
+
+func LoadHistory(bc *Blockchain) error {
+	dir, err := ioutil.ReadDir(bc.options.Folder + "/history")
+
+	if err != nil {
+		return err
+	}
+
+	for _, file := range dir {
+		historyByte, err := ioutil.ReadFile(bc.options.Folder + "/history/" + file.Name())
+
+		if err != nil {
+			return err
+		}
+
+		var txs []HistoryTx
+		err = msgpack.Unmarshal(historyByte, &txs)
+
+		if err != nil {
+			return err
+		}
+
+		wallet, _ := url.PathUnescape(file.Name())
+
+		bc.history[wallet] = NewHistory(txs)
+	}
+
+	bc.logger.Debug("Loaded", len(bc.history), "wallets history")
+
+	return nil
+}
+
+func StoreHistory(bc *Blockchain) error {
+	for walletName, history := range bc.history {
+		toStore, err := msgpack.Marshal(history.Txs)
+
+		if err != nil {
+			return err
+		}
+
+		err = ioutil.WriteFile(bc.options.Folder+"/history/"+url.PathEscape(walletName), toStore, 0644)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	bc.logger.Debug("Stored", len(bc.history), "wallets history")
+
+	return nil
+}
+
+func LoadMultisigs(bc *Blockchain) error {
+	dir, err := ioutil.ReadDir(bc.options.Folder + "/multisig")
+
+	if err != nil {
+		return err
+	}
+
+	for _, file := range dir {
+		multisigByte, err := ioutil.ReadFile(bc.options.Folder + "/multisig/" + file.Name())
+
+		if err != nil {
+			return err
+		}
+
+		var multisig Multisig
+		err = msgpack.Unmarshal(multisigByte, &multisig)
+
+		if err != nil {
+			return err
+		}
+
+		bc.multisigs[multisig.Address()] = &multisig
+	}
+
+	bc.logger.Debug("Loaded", len(bc.multisigs), "multisig addresses")
+
+	return nil
+}
+
+func StoreMultisig(bc *Blockchain, multisig *Multisig) error {
+	toStore, err := msgpack.Marshal(multisig)
+
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(bc.options.Folder+"/multisig/"+multisig.Address(), toStore, 0644)
+}
+
+func LoadDataIndex(bc *Blockchain) error {
+	indexByte, err := ioutil.ReadFile(bc.options.Folder + "/data-index")
+
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	var refs []DataRef
+	err = msgpack.Unmarshal(indexByte, &refs)
+
+	if err != nil {
+		return err
+	}
+
+	bc.dataIndex = NewDataIndex(refs)
+
+	bc.logger.Debug("Loaded", len(refs), "data outs")
+
+	return nil
+}
+
+func StoreDataIndex(bc *Blockchain) error {
+	toStore, err := msgpack.Marshal(bc.dataIndex.All())
+
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(bc.options.Folder+"/data-index", toStore, 0644)
+}
+
+func LoadSecrets(bc *Blockchain) error {
+	secretsByte, err := ioutil.ReadFile(bc.options.Folder + "/secrets")
+
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	var secrets map[string][]byte
+	err = msgpack.Unmarshal(secretsByte, &secrets)
+
+	if err != nil {
+		return err
+	}
+
+	for hash, secret := range secrets {
+		hashBytes, err := hex.DecodeString(hash)
+
+		if err != nil {
+			return err
+		}
+
+		bc.secrets[string(hashBytes)] = secret
+	}
+
+	bc.logger.Debug("Loaded", len(bc.secrets), "secrets")
+
+	return nil
+}
+
+// Hashes are stored in hex, msgpack strings being decoded as UTF-8
+func StoreSecrets(bc *Blockchain) error {
+	secrets := make(map[string][]byte)
+
+	for hash, secret := range bc.secrets {
+		secrets[hex.EncodeToString([]byte(hash))] = secret
+	}
+
+	toStore, err := msgpack.Marshal(secrets)
+
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(bc.options.Folder+"/secrets", toStore, 0600)
+}
+
+func LoadMempool(bc *Blockchain) error {
+	// Outs are reserved again by the restored transactions only
+	for _, unspents := range bc.unspentTxOut {
+		for i := range unspents {
+			unspents[i].IsTargeted = false
+		}
+	}
+
+	mempoolByte, err := ioutil.ReadFile(bc.options.Folder + "/mempool")
+
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	var entries []MempoolEntry
+	err = msgpack.Unmarshal(mempoolByte, &entries)
+
+	if err != nil {
+		return err
+	}
+
+	// Entries are stored parents first, so they can be added back in order
+	limit := time.Now().Add(-bc.options.MempoolExpiry).Unix()
+	restored := 0
+
+	for _, entry := range entries {
+		if entry.Time < limit {
+			continue
+		}
+
+		if !bc.addTransactionToWaiting(&entry.Tx, entry.Time) {
+			continue
+		}
+
+		if bc.isOwnTransaction(&entry.Tx) {
+			bc.rebroadcaster.Track(&entry.Tx, entry.Time)
+		}
+
+		restored++
+	}
+
+	bc.logger.Debug("Restored", restored, "of", len(entries), "pending transactions")
+
+	return nil
+}
+
+func StoreMempool(bc *Blockchain) error {
+	entries := []MempoolEntry{}
+
+	for _, entry := range bc.mempool.Entries() {
+		entries = append(entries, *entry)
+	}
+
+	toStore, err := msgpack.Marshal(entries)
+
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(bc.options.Folder+"/mempool", toStore, 0644)
+
+	if err != nil {
+		return err
+	}
+
+	bc.logger.Debug("Stored", len(entries), "pending transactions")
+
+	return nil
+}
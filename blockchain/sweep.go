@@ -0,0 +1,122 @@
+package blockchain
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Max number of ins in one sweep transaction, bigger sweeps are split
+var SWEEP_MAX_INS = 200
+
+// Parse a sweep order of the form 'destAddress[:threshold]' and execute it
+func (this *Blockchain) SweepTo(value string, walletName string) error {
+	splited := strings.Split(value, ":")
+
+	if len(splited) < 1 || len(splited) > 2 || len(splited[0]) == 0 {
+		return errors.New("Bad sweep format")
+	}
+
+	threshold := 0
+
+	if len(splited) == 2 {
+		var err error
+
+		threshold, err = strconv.Atoi(splited[1])
+
+		if err != nil || threshold <= 0 {
+			return errors.New("Invalid threshold: " + splited[1])
+		}
+	}
+
+	txs, err := this.Sweep(walletName, []byte(splited[0]), threshold, this.options.Fee)
+
+	if err != nil {
+		return err
+	}
+
+	this.logger.Info("Sweeping", walletName, "in", len(txs), "transactions")
+
+	return nil
+}
+
+// Move every available out of the wallet to `dest`, or only those with a value below
+// `threshold` if it is > 0. Outs are batched by SWEEP_MAX_INS, each batch giving one
+// transaction with a single out, that pays `fee`
+func (this *Blockchain) Sweep(walletName string, dest []byte, threshold int, fee int) ([]*Transaction, error) {
+	wallet, ok := this.wallets[walletName]
+
+	if !ok {
+		return nil, errors.New("Unknown wallet: " + walletName)
+	}
+
+	if fee < 0 {
+		return nil, errors.New("Invalid fee: " + strconv.Itoa(fee))
+	}
+
+	toSweep := []UnspentTxOut{}
+
+	for _, unspent := range this.getSpendableOuts(wallet) {
+		if threshold > 0 && unspent.Out.Value >= threshold {
+			continue
+		}
+
+		toSweep = append(toSweep, unspent)
+	}
+
+	if len(toSweep) == 0 {
+		return nil, errors.New("Nothing to sweep")
+	}
+
+	res := []*Transaction{}
+
+	for len(toSweep) > 0 {
+		nb := len(toSweep)
+
+		if nb > SWEEP_MAX_INS {
+			nb = SWEEP_MAX_INS
+		}
+
+		batch := toSweep[:nb]
+		toSweep = toSweep[nb:]
+
+		ins := []TxIn{}
+		total := 0
+
+		for _, unspent := range batch {
+			ins = append(ins, TxIn{
+				PrevHash: unspent.TxHash,
+				PrevIdx:  unspent.InIdx,
+			})
+
+			total += unspent.Out.Value
+		}
+
+		if total <= fee {
+			return res, errors.New("Fee exceeds the swept amount")
+		}
+
+		tx, err := NewSignedTransaction(ins, []TxOut{TxOut{
+			Value:   total - fee,
+			Address: dest,
+		}}, wallet, this.params.ID)
+
+		if err != nil {
+			return res, err
+		}
+
+		if !this.AddTransationToWaiting(tx) {
+			return res, errors.New("Unable to create the sweep transaction")
+		}
+
+		if err := this.BroadcastTransaction(tx); err != nil {
+			return res, err
+		}
+
+		res = append(res, tx)
+	}
+
+	this.mustStop = true
+
+	return res, nil
+}
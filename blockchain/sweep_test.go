@@ -0,0 +1,89 @@
+package blockchain
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestSweep(t *testing.T) {
+	bc := newTestNode(t, RegtestParams)
+	wallet := bc.wallets["main.key"]
+	dest := []byte(SanitizePubKey(newTestWallet(t, "dest").pub))
+	count := 2*SWEEP_MAX_INS + 50
+
+	outs := make(map[string]bool)
+
+	for i := 0; i < count; i++ {
+		out := giveTestOut(bc, wallet, "out"+strconv.Itoa(i), 10, 0, false)
+		outs[outpointKey(out.TxHash, out.InIdx)] = true
+	}
+
+	if _, err := bc.Sweep("main.key", dest, 0, 10*SWEEP_MAX_INS); err == nil {
+		t.Fatal("sweep paying all its value in fee")
+	}
+
+	txs, err := bc.Sweep("main.key", dest, 0, 3)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(txs) != 3 {
+		t.Fatal("bad number of transactions:", len(txs))
+	}
+
+	for _, tx := range txs {
+		if len(tx.Ins) > SWEEP_MAX_INS || len(tx.Outs) != 1 || compare(tx.Outs[0].Address, dest) != 0 {
+			t.Fatal("bad sweep transaction")
+		}
+
+		// Every out is worth 10
+		if tx.Outs[0].Value != 10*len(tx.Ins)-3 {
+			t.Fatal("fee not deducted:", tx.Outs[0].Value, "for", len(tx.Ins), "ins")
+		}
+
+		for _, in := range tx.Ins {
+			key := outpointKey(in.PrevHash, in.PrevIdx)
+
+			if !outs[key] {
+				t.Fatal("out spent twice or unknown")
+			}
+
+			delete(outs, key)
+		}
+
+		if !bc.mempool.Has(tx.Stamp.Hash) {
+			t.Fatal("sweep transaction not pending")
+		}
+	}
+
+	if len(outs) != 0 {
+		t.Fatal("outs not swept:", len(outs))
+	}
+
+	if _, err := bc.Sweep("main.key", dest, 0, 0); err == nil {
+		t.Fatal("swept pending outs again")
+	}
+}
+
+func TestSweepThreshold(t *testing.T) {
+	bc := newTestNode(t, RegtestParams)
+	wallet := bc.wallets["main.key"]
+
+	small := giveTestOut(bc, wallet, "small", 5, 0, false)
+	giveTestOut(bc, wallet, "big", 50, 0, false)
+
+	txs, err := bc.Sweep("main.key", []byte("dest"), 10, 0)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(txs) != 1 || len(txs[0].Ins) != 1 || compare(txs[0].Ins[0].PrevHash, small.TxHash) != 0 {
+		t.Fatal("swept outs over the threshold")
+	}
+
+	if _, err := bc.Sweep("unknown", []byte("dest"), 0, 0); err == nil {
+		t.Fatal("swept an unknown wallet")
+	}
+}
@@ -0,0 +1,375 @@
+package blockchain
+
+import (
+	"errors"
+	"time"
+)
+
+// Blocks are stored in the DHT at the hash of the previous block hash, so they can
+// only be found one after the other. To sync faster, the headers are also stored
+// by chunks: once their headers are known, the blocks are downloaded in parallel.
+
+var (
+	// Number of headers stored together. A chunk holds the headers from height
+	// k * HEADERS_CHUNK_SIZE + 1 to (k + 1) * HEADERS_CHUNK_SIZE
+	HEADERS_CHUNK_SIZE int64 = 100
+
+	// Max number of blocks downloaded before adding them
+	SYNC_BATCH_SIZE = 1000
+
+	// Number of blocks downloaded at once
+	SYNC_WORKERS = 8
+)
+
+// Kind of a value stored in the DHT, first byte after the chain ID
+const (
+	STORED_BLOCK byte = iota
+	STORED_HEADERS
+
+	// See handshake.go
+	STORED_HELLO
+)
+
+func (this *Blockchain) sealStored(kind byte, payload []byte) []byte {
+	return this.params.Seal(append([]byte{kind}, payload...))
+}
+
+func (this *Blockchain) openStored(blob []byte, kind byte) ([]byte, error) {
+	data, err := this.params.Open(blob)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) == 0 || data[0] != kind {
+		return nil, errors.New("Unexpected stored value")
+	}
+
+	return data[1:], nil
+}
+
+// Key of the chunk of headers following the one with `hash`
+func headersKey(hash []byte) []byte {
+	return NewHash(append([]byte("headers"), hash...))
+}
+
+func (this *Blockchain) Sync() {
+	this.logger.Info("Start syncing at", len(this.headers)-1)
+
+	this.catchUp()
+
+	this.synced = true
+
+	go func() {
+		for {
+			if this.catchUp() > 0 {
+				this.mustStop = true
+			}
+
+			time.Sleep(time.Second * 5)
+		}
+	}()
+}
+
+// Add the blocks following our last one until none can be found, headers first
+// when they are available. Returns the number of added blocks
+func (this *Blockchain) catchUp() int {
+	added := 0
+
+	for {
+		headers := this.fetchHeaders()
+
+		this.assumeValid(headers)
+
+		progress := false
+
+		for len(headers) > 0 {
+			batch := headers
+
+			if len(batch) > SYNC_BATCH_SIZE {
+				batch = batch[:SYNC_BATCH_SIZE]
+			}
+
+			var nb int
+			var err error
+
+			// Light nodes do not need the blocks of the headers
+			if this.options.Light {
+				nb, err = this.addHeaders(batch)
+			} else {
+				nb, err = this.downloadBlocks(batch, this.fetchBlock)
+			}
+
+			added += nb
+			progress = progress || nb > 0
+
+			if err != nil {
+				this.logger.Warning("Sync:", err)
+
+				break
+			}
+
+			headers = headers[len(batch):]
+		}
+
+		if progress {
+			continue
+		}
+
+		if this.doSync() != nil {
+			return added
+		}
+
+		// Chunks missing from the DHT are stored again by the nodes that
+		// complete them, for the next ones to sync
+		this.storeHeaders()
+
+		added++
+	}
+}
+
+func (this *Blockchain) doSync() error {
+	block, err := this.fetchBlock(this.headers[len(this.headers)-1].Hash)
+
+	if err != nil {
+		return err
+	}
+
+	if this.options.Light {
+		if err := this.AddHeader(&block.Header); err != nil {
+			this.logger.Warning("Sync: Received bad header:", err)
+
+			return err
+		}
+
+		return nil
+	}
+
+	if !this.AddBlock(block) {
+		this.logger.Warning("Sync: Received bad block")
+
+		return errors.New("Cannot add block")
+	}
+
+	return nil
+}
+
+func (this *Blockchain) fetchBlock(precHash []byte) (*Block, error) {
+	blob, err := this.client.Fetch(NewHash(precHash))
+
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := this.openStored(blob, STORED_BLOCK)
+
+	if err != nil {
+		this.logger.Warning("Sync: Refused block:", err)
+
+		return nil, err
+	}
+
+	block, err := DecodeBlock(data)
+
+	if err != nil {
+		this.logger.Warning("Sync: Received bad block:", err)
+
+		return nil, err
+	}
+
+	return block, nil
+}
+
+// Headers following our last block, as long as chunks of them are found
+func (this *Blockchain) fetchHeaders() []BlockHeader {
+	res := []BlockHeader{}
+	last := this.headers[len(this.headers)-1]
+
+	for last.Height%HEADERS_CHUNK_SIZE == 0 {
+		blob, err := this.client.Fetch(headersKey(last.Hash))
+
+		if err != nil {
+			break
+		}
+
+		data, err := this.openStored(blob, STORED_HEADERS)
+
+		if err != nil {
+			this.logger.Warning("Sync: Refused headers:", err)
+
+			break
+		}
+
+		chunk, err := DecodeHeaders(data)
+
+		if err == nil {
+			err = this.verifyHeaders(&last, chunk)
+		}
+
+		if err != nil {
+			this.logger.Warning("Sync: Received bad headers:", err)
+
+			break
+		}
+
+		res = append(res, chunk...)
+		last = chunk[len(chunk)-1]
+	}
+
+	return res
+}
+
+// Check the chunk follows `prec` and every header has a valid proof of work. The
+// targets themselves are checked when the blocks are added
+func (this *Blockchain) verifyHeaders(prec *BlockHeader, chunk []BlockHeader) error {
+	if int64(len(chunk)) != HEADERS_CHUNK_SIZE {
+		return errors.New("Bad headers count")
+	}
+
+	for i := range chunk {
+		if err := this.verifyHeader(prec, &chunk[i]); err != nil {
+			return err
+		}
+
+		prec = &chunk[i]
+	}
+
+	return nil
+}
+
+func (this *Blockchain) verifyHeader(prec *BlockHeader, header *BlockHeader) error {
+	if header.Height != prec.Height+1 || compare(header.PrecHash, prec.Hash) != 0 {
+		return errors.New("Headers do not follow each other")
+	}
+
+	if !this.params.CheckCheckpoint(header.Height, header.Hash) {
+		return errors.New("Headers conflict with a checkpoint")
+	}
+
+	if compare(header.Target, this.baseTarget) > 0 || compare(header.Hash, header.Target) >= 0 {
+		return errors.New("Bad proof of work")
+	}
+
+	return nil
+}
+
+// Download the blocks of the headers in parallel with `fetch`, that gets the block
+// following a hash, and add them in order. Returns the number of added blocks
+func (this *Blockchain) downloadBlocks(headers []BlockHeader, fetch func(precHash []byte) (*Block, error)) (int, error) {
+	blocks := make([]*Block, len(headers))
+	errs := make([]error, len(headers))
+	done := make([]chan bool, len(headers))
+
+	for i := range done {
+		done[i] = make(chan bool)
+	}
+
+	jobs := make(chan int)
+	stop := make(chan bool)
+
+	defer close(stop)
+
+	go func() {
+		defer close(jobs)
+
+		for i := range headers {
+			select {
+			case jobs <- i:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	for w := 0; w < SYNC_WORKERS; w++ {
+		go func() {
+			for i := range jobs {
+				blocks[i], errs[i] = fetch(headers[i].PrecHash)
+
+				close(done[i])
+			}
+		}()
+	}
+
+	for i := range headers {
+		<-done[i]
+
+		if errs[i] != nil {
+			return i, errs[i]
+		}
+
+		if compare(blocks[i].Header.Hash, headers[i].Hash) != 0 {
+			return i, errors.New("Block does not match its header")
+		}
+
+		if !this.AddBlock(blocks[i]) {
+			return i, errors.New("Cannot add block")
+		}
+	}
+
+	return len(headers), nil
+}
+
+// Store the chunk of headers ending with our last block, if it completes one
+func (this *Blockchain) storeHeaders() {
+	height := this.BlocksHeight()
+
+	if height == 0 || height%HEADERS_CHUNK_SIZE != 0 {
+		return
+	}
+
+	chunk := this.headers[height-HEADERS_CHUNK_SIZE+1 : height+1]
+	key := headersKey(this.headers[height-HEADERS_CHUNK_SIZE].Hash)
+
+	if _, _, err := this.client.StoreAt(key, this.sealStored(STORED_HEADERS, EncodeHeaders(chunk))); err != nil {
+		this.logger.Warning("Cannot store headers", err)
+	}
+}
+
+// Validate a chunk of headers another node wants to store. Must be called with
+// the lock held
+func (this *Blockchain) verifyStoredHeaders(data []byte) bool {
+	chunk, err := DecodeHeaders(data)
+
+	if err != nil || len(chunk) == 0 {
+		this.logger.Warning("ONSTORE Bad headers")
+
+		return false
+	}
+
+	start := chunk[0].Height - 1
+
+	if start < 0 || start%HEADERS_CHUNK_SIZE != 0 {
+		this.logger.Warning("ONSTORE Misaligned headers")
+
+		return false
+	}
+
+	// We cannot check the chunk follows a header we do not have yet
+	prec := &BlockHeader{
+		Height: start,
+		Hash:   chunk[0].PrecHash,
+	}
+
+	if start < int64(len(this.headers)) {
+		prec = &this.headers[start]
+	}
+
+	if err := this.verifyHeaders(prec, chunk); err != nil {
+		this.logger.Warning("ONSTORE Bad headers:", err)
+
+		return false
+	}
+
+	for _, header := range chunk {
+		if header.Height < int64(len(this.headers)) && compare(header.Hash, this.headers[header.Height].Hash) != 0 {
+			this.logger.Warning("ONSTORE Headers do not match ours")
+
+			return false
+		}
+	}
+
+	this.assumeValid(chunk)
+
+	return true
+}
@@ -0,0 +1,192 @@
+package blockchain
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+)
+
+// A chunk of mined headers following the genesis block
+func newTestChunk(params ChainParams) []BlockHeader {
+	chunk := []BlockHeader{}
+	last := params.Genesis().Header
+
+	for i := int64(0); i < HEADERS_CHUNK_SIZE; i++ {
+		header := BlockHeader{
+			Version:    BLOCK_VERSION,
+			Height:     last.Height + 1,
+			PrecHash:   last.Hash,
+			MerkelHash: NewHash(nil),
+			Target:     params.BaseTarget,
+		}
+
+		header.Hash = header.ComputeHash()
+
+		for compare(header.Hash, header.Target) >= 0 {
+			header.Nonce++
+			header.Hash = header.ComputeHash()
+		}
+
+		chunk = append(chunk, header)
+		last = header
+	}
+
+	return chunk
+}
+
+func TestVerifyHeaders(t *testing.T) {
+	params := RegtestParams
+	bc := newTestBlockchain(params)
+	genesis := params.Genesis().Header
+	chunk := newTestChunk(params)
+
+	decoded, err := DecodeHeaders(EncodeHeaders(chunk))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := bc.verifyHeaders(&genesis, decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := bc.verifyHeaders(&genesis, chunk[:HEADERS_CHUNK_SIZE/2]); err == nil {
+		t.Fatal("partial chunk accepted")
+	}
+
+	decoded[5].Nonce++
+	decoded[5].Hash = decoded[5].ComputeHash()
+
+	if err := bc.verifyHeaders(&genesis, decoded); err == nil {
+		t.Fatal("tampered chunk accepted")
+	}
+
+	bc.params.Checkpoints = map[int64][]byte{7: NewHash(nil)}
+
+	if err := bc.verifyHeaders(&genesis, chunk); err == nil {
+		t.Fatal("chunk conflicting with a checkpoint accepted")
+	}
+}
+
+// A chunk of headers stored by another node leads to the assumed valid block too
+func TestVerifyStoredHeaders(t *testing.T) {
+	params := RegtestParams
+	chunk := newTestChunk(params)
+	params.AssumeValid = chunk[40].Hash
+
+	bc := newTestBlockchain(params)
+
+	if !bc.verifyStoredHeaders(EncodeHeaders(chunk)) {
+		t.Fatal("stored headers refused")
+	}
+
+	if !bc.isAssumedValid(&chunk[0]) || bc.isAssumedValid(&chunk[41]) {
+		t.Fatal("stored headers not leading to the assumed valid block")
+	}
+
+	if bc.verifyStoredHeaders(EncodeHeaders(chunk[1:])) {
+		t.Fatal("misaligned headers accepted")
+	}
+}
+
+// A node with `count` blocks, and a fetch of its blocks as the DHT would give them
+func newTestSource(t *testing.T, count int) (*Blockchain, func(precHash []byte) (*Block, error)) {
+	src := newTestNode(t, RegtestParams)
+	stored := make(map[string][]byte)
+
+	for i := 0; i < count; i++ {
+		block := mineTestBlock(t, src)
+
+		stored[string(block.Header.PrecHash)] = EncodeBlock(block)
+	}
+
+	return src, func(precHash []byte) (*Block, error) {
+		data, ok := stored[string(precHash)]
+
+		if !ok {
+			return nil, errors.New("Not found")
+		}
+
+		return DecodeBlock(data)
+	}
+}
+
+func TestDownloadBlocks(t *testing.T) {
+	src, fetch := newTestSource(t, 3*SYNC_WORKERS)
+	headers := src.headers[1:]
+
+	// The first block arrives last
+	last := make(chan bool)
+	fetched := int32(0)
+
+	outOfOrder := func(precHash []byte) (*Block, error) {
+		if compare(precHash, headers[0].PrecHash) == 0 {
+			<-last
+		} else if atomic.AddInt32(&fetched, 1) == int32(len(headers)-1) {
+			close(last)
+		}
+
+		return fetch(precHash)
+	}
+
+	dst := newTestNode(t, RegtestParams)
+
+	if added, err := dst.downloadBlocks(headers, outOfOrder); err != nil || added != len(headers) {
+		t.Fatal("blocks not added:", added, err)
+	}
+
+	if compare(dst.headers[len(dst.headers)-1].Hash, src.headers[len(src.headers)-1].Hash) != 0 {
+		t.Fatal("bad last block")
+	}
+
+	if compare(dst.utxos.Root(), src.utxos.Root()) != 0 {
+		t.Fatal("bad unspent outs")
+	}
+}
+
+func TestDownloadBlocksErrors(t *testing.T) {
+	src, fetch := newTestSource(t, 10)
+	_, otherFetch := newTestSource(t, 10)
+	headers := src.headers[1:]
+
+	tests := []struct {
+		name  string
+		fetch func(precHash []byte) (*Block, error)
+		added int
+	}{
+		{
+			"missing block",
+			func(precHash []byte) (*Block, error) {
+				if compare(precHash, headers[5].PrecHash) == 0 {
+					return nil, errors.New("Not found")
+				}
+
+				return fetch(precHash)
+			},
+			5,
+		},
+		{
+			"block of another chain",
+			func(precHash []byte) (*Block, error) {
+				if compare(precHash, headers[3].PrecHash) == 0 {
+					return otherFetch(src.params.Genesis().Header.Hash)
+				}
+
+				return fetch(precHash)
+			},
+			3,
+		},
+	}
+
+	for _, test := range tests {
+		dst := newTestNode(t, RegtestParams)
+
+		if added, err := dst.downloadBlocks(headers, test.fetch); err == nil || added != test.added {
+			t.Errorf("%s: added %d blocks, error %v", test.name, added, err)
+		}
+
+		if dst.BlocksHeight() != int64(test.added) {
+			t.Errorf("%s: at block %d", test.name, dst.BlocksHeight())
+		}
+	}
+}
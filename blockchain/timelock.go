@@ -0,0 +1,45 @@
+package blockchain
+
+import (
+	"strconv"
+	"time"
+)
+
+// Lock times below this value are block heights, above are unix timestamps
+const LOCKTIME_THRESHOLD = 500000000
+
+// Max number of seconds a block timestamp can be ahead of our clock
+var MAX_FUTURE_BLOCK_TIME int64 = 2 * 60 * 60
+
+// A transaction can be included in a block of given height and timestamp only
+// once its LockTime is reached
+func (this *Transaction) IsFinal(height, timestamp int64) bool {
+	if this.LockTime == 0 {
+		return true
+	}
+
+	if this.LockTime < LOCKTIME_THRESHOLD {
+		return height >= this.LockTime
+	}
+
+	return timestamp >= this.LockTime
+}
+
+// An in with a Sequence can only spend an out confirmed at least Sequence blocks before
+func (this *TxIn) IsRelativeLocked(out *UnspentTxOut, height int64) bool {
+	return this.Sequence > 0 && height-out.Height < this.Sequence
+}
+
+func (this *Blockchain) isFinal(tx *Transaction) bool {
+	ctx := this.nextTxContext()
+
+	return tx.IsFinal(ctx.height, ctx.timestamp)
+}
+
+func lockTimeString(lockTime int64) string {
+	if lockTime < LOCKTIME_THRESHOLD {
+		return "block " + strconv.FormatInt(lockTime, 10)
+	}
+
+	return time.Unix(lockTime, 0).Format(time.RFC1123)
+}
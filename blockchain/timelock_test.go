@@ -0,0 +1,85 @@
+package blockchain
+
+import "testing"
+
+func TestIsFinal(t *testing.T) {
+	tests := []struct {
+		lockTime  int64
+		height    int64
+		timestamp int64
+		final     bool
+	}{
+		{0, 1, 0, true},
+		{10, 9, 0, false},
+		{10, 10, 0, true},
+		{10, 9, LOCKTIME_THRESHOLD + 100, false},
+		{LOCKTIME_THRESHOLD + 100, 1000, LOCKTIME_THRESHOLD + 99, false},
+		{LOCKTIME_THRESHOLD + 100, 1, LOCKTIME_THRESHOLD + 100, true},
+	}
+
+	for i, test := range tests {
+		tx := &Transaction{LockTime: test.lockTime}
+
+		if tx.IsFinal(test.height, test.timestamp) != test.final {
+			t.Errorf("%d: IsFinal = %v, want %v", i, !test.final, test.final)
+		}
+	}
+}
+
+func TestIsRelativeLocked(t *testing.T) {
+	tests := []struct {
+		sequence int64
+		created  int64
+		height   int64
+		locked   bool
+	}{
+		{0, 10, 10, false},
+		{5, 10, 14, true},
+		{5, 10, 15, false},
+		{1, 10, 10, true},
+	}
+
+	for i, test := range tests {
+		in := &TxIn{Sequence: test.sequence}
+
+		if in.IsRelativeLocked(&UnspentTxOut{Height: test.created}, test.height) != test.locked {
+			t.Errorf("%d: IsRelativeLocked = %v, want %v", i, !test.locked, test.locked)
+		}
+	}
+}
+
+func TestLockedSpend(t *testing.T) {
+	bc := newTestBlockchain(MainParams)
+	wallet := newTestWallet(t, "main.key")
+	out := giveTestOut(bc, wallet, "out", 100, 10, false)
+
+	tx := newTestSpend(t, bc, wallet, []UnspentTxOut{out}, 100, "dest")
+	tx.LockTime = 20
+
+	if err := tx.Sign(wallet, bc.params.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if tx.verifyInContext(bc, testContext(bc, 19, 0)) {
+		t.Fatal("spend accepted before its lock time")
+	}
+
+	if !tx.verifyInContext(bc, testContext(bc, 20, 0)) {
+		t.Fatal("spend refused at its lock time")
+	}
+
+	tx = newTestSpend(t, bc, wallet, []UnspentTxOut{out}, 100, "dest")
+	tx.Ins[0].Sequence = 5
+
+	if err := tx.Sign(wallet, bc.params.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if tx.verifyInContext(bc, testContext(bc, 14, 0)) {
+		t.Fatal("spend accepted before its relative lock")
+	}
+
+	if !tx.verifyInContext(bc, testContext(bc, 15, 0)) {
+		t.Fatal("spend refused after its relative lock")
+	}
+}
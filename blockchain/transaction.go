@@ -4,21 +4,33 @@ import (
 	"crypto/ecdsa"
 	"crypto/rand"
 	"crypto/x509"
+	"encoding/hex"
 	"encoding/pem"
+	"errors"
 	"math/big"
 	"time"
-
-	"github.com/vmihailenco/msgpack"
 )
 
 type TxIn struct {
 	PrevHash []byte
 	PrevIdx  int
+
+	// Relative lock: number of blocks the spent out must have been confirmed for
+	Sequence int64 `msgpack:",omitempty"`
+
+	// Set when spending a multisig out
+	Multisig *MultisigSpend `msgpack:",omitempty"`
+
+	// Set when spending an out locked by a script
+	Script *ScriptSpend `msgpack:",omitempty"`
 }
 
 type TxOut struct {
 	Value   int
 	Address []byte
+
+	// Set on data outs only, see data.go
+	Data []byte `msgpack:",omitempty"`
 }
 
 type Stamp struct {
@@ -30,31 +42,46 @@ type Stamp struct {
 }
 
 type Transaction struct {
+	// ID of the chain the transaction is signed for, so it cannot be replayed on another
+	ChainID uint32 `msgpack:",omitempty"`
+
 	Ins   []TxIn
 	Outs  []TxOut
 	Stamp Stamp
+
+	// Opt-in to be replaced in the mempool by a transaction paying more fees
+	Replaceable bool `msgpack:",omitempty"`
+
+	// Absolute lock: min block height, or min block timestamp if >= LOCKTIME_THRESHOLD
+	LockTime int64 `msgpack:",omitempty"`
 }
 
-func (this *Transaction) Verify(bc *Blockchain) bool {
-	r := this.Stamp.R
-	s := this.Stamp.S
-	txHash := this.Stamp.Hash
+// Chain state a transaction is verified against
+type txContext struct {
+	// Height and timestamp of the block that will include the transaction
+	height    int64
+	timestamp int64
 
-	this.Stamp.R = []byte{}
-	this.Stamp.S = []byte{}
-	this.Stamp.Hash = []byte{}
+	// Find the out spent by given in
+	lookup func(wallet []byte, in *TxIn) *UnspentTxOut
 
-	hash, err := msgpack.Marshal(this)
+	// Set for the blocks assumed valid: the signatures and scripts are not checked
+	skipSigs bool
+}
 
-	if err != nil {
-		bc.logger.Error("Tx verify: Cannot marshal the tx")
+// Verify the transaction for inclusion in the next block
+func (this *Transaction) Verify(bc *Blockchain) bool {
+	return this.verifyInContext(bc, bc.nextTxContext())
+}
+
+func (this *Transaction) verifyInContext(bc *Blockchain, ctx txContext) bool {
+	if this.ChainID != bc.params.ID {
+		bc.logger.Error("Tx verify: Signed for another chain")
 
 		return false
 	}
 
-	newHash := NewHash(hash)
-
-	this.Stamp.Hash = txHash
+	newHash := this.SigningHash()
 
 	if compare(newHash, this.Stamp.Hash) != 0 {
 		bc.logger.Error("Tx verify: Hash dont match", newHash)
@@ -79,34 +106,73 @@ func (this *Transaction) Verify(bc *Blockchain) bool {
 
 	var r_ big.Int
 	// var r2 *big.Int
-	r_.SetBytes(r)
+	r_.SetBytes(this.Stamp.R)
 
 	var s_ big.Int
-	s_.SetBytes(s)
+	s_.SetBytes(this.Stamp.S)
 
-	if !ecdsa.Verify(publicKey, newHash, &r_, &s_) {
+	if !ctx.skipSigs && !ecdsa.Verify(publicKey, newHash, &r_, &s_) {
 		bc.logger.Error("Tx verify: Signatures does not match")
 
 		return false
 	}
 
-	this.Stamp.R = r
-	this.Stamp.S = s
+	if !this.IsFinal(ctx.height, ctx.timestamp) {
+		bc.logger.Error("Tx verify: Locked until", lockTimeString(this.LockTime))
+
+		return false
+	}
 
 	// lets assume this will work any time
 	if len(this.Ins) == 0 && len(this.Outs) == 1 {
-		if this.Outs[0].Value != 100 {
+		if this.Outs[0].Value != bc.params.Subsidy(ctx.height) {
 			bc.logger.Error("Tx verify: Bad coinbase amount")
 
 			return false
 		}
 
+		if this.LockTime != ctx.height {
+			bc.logger.Error("Tx verify: Coinbase lock time is not its height")
+
+			return false
+		}
+
 		return true
 	}
 
 	insTotal := 0
 	for _, in := range this.Ins {
-		prevUnspentOut := bc.getCorrespondingOutTx(this.Stamp.Pub, &in)
+		if in.Multisig != nil && in.Script != nil {
+			bc.logger.Error("Tx verify: In cannot spend both a multisig and a script")
+
+			return false
+		}
+
+		if in.Multisig != nil && !ctx.skipSigs {
+			if err := in.Multisig.Verify(newHash); err != nil {
+				bc.logger.Error("Tx verify: Bad multisig spend:", err)
+
+				return false
+			}
+		}
+
+		if in.Script != nil {
+			if err := in.Script.Check(); err != nil {
+				bc.logger.Error("Tx verify: Bad script spend:", err)
+
+				return false
+			}
+		}
+
+		if in.Script != nil && !ctx.skipSigs {
+			if err := in.Script.Verify(this, &in, newHash); err != nil {
+				bc.logger.Error("Tx verify: Bad script spend:", err)
+
+				return false
+			}
+		}
+
+		prevUnspentOut := ctx.lookup(this.Stamp.Pub, &in)
 
 		if prevUnspentOut == nil {
 			bc.logger.Error("Tx verify: Cannot find corresponding OutTx for given In")
@@ -114,14 +180,43 @@ func (this *Transaction) Verify(bc *Blockchain) bool {
 			return false
 		}
 
+		if !bc.isMature(prevUnspentOut, ctx.height) {
+			bc.logger.Error("Tx verify: Immature coinbase spend")
+
+			return false
+		}
+
+		if in.IsRelativeLocked(prevUnspentOut, ctx.height) {
+			bc.logger.Error("Tx verify: In is locked for", in.Sequence, "blocks")
+
+			return false
+		}
+
 		insTotal += prevUnspentOut.Out.Value
 	}
 
 	outsTotal := 0
+	dataOuts := 0
 	for _, out := range this.Outs {
+		if out.IsData() {
+			if out.Value != 0 || len(out.Address) > 0 || len(out.Data) > bc.params.MaxDataSize {
+				bc.logger.Error("Tx verify: Bad data out")
+
+				return false
+			}
+
+			dataOuts++
+		}
+
 		outsTotal += out.Value
 	}
 
+	if dataOuts > 1 {
+		bc.logger.Error("Tx verify: More than one data out")
+
+		return false
+	}
+
 	if outsTotal > insTotal {
 		bc.logger.Error("Tx verify: Outs total amount exceeds in amount")
 
@@ -131,10 +226,32 @@ func (this *Transaction) Verify(bc *Blockchain) bool {
 	return true
 }
 
-func NewTransaction(value int, dest []byte, bc *Blockchain) *Transaction {
-	outs := bc.GetEnoughOwnUnspentOut(value)
+func NewTransaction(payments []TxOut, options SendOptions, bc *Blockchain) *Transaction {
+	selector, err := GetCoinSelector(options.Strategy)
+
+	if err != nil {
+		bc.logger.Warning("Cannot create transaction:", err)
+
+		return nil
+	}
 
-	insRes, outRes := bc.GetInOutFromUnspent(value, dest, outs)
+	value := options.Fee
+	for _, payment := range payments {
+		value += payment.Value
+	}
+
+	// A transaction only carrying data still needs an in
+	if value == 0 {
+		value = 1
+	}
+
+	outs := bc.GetEnoughOwnUnspentOut(value, selector, options.Sequence)
+
+	insRes, outRes := bc.GetInOutFromUnspent(payments, options.Fee, outs)
+
+	if len(options.Data) > 0 {
+		outRes = append(outRes, NewDataOut(options.Data))
+	}
 
 	if len(outs) == 0 {
 		bc.logger.Warning("Cannot create transaction: no outs")
@@ -142,45 +259,83 @@ func NewTransaction(value int, dest []byte, bc *Blockchain) *Transaction {
 		return nil
 	}
 
-	transac := &Transaction{
-		Stamp: Stamp{
-			Pub:       bc.wallets["main.key"].pub,
-			Timestamp: time.Now().Unix(),
-			Hash:      []byte{},
-			R:         []byte{},
-			S:         []byte{},
-		},
-		Ins:  insRes,
-		Outs: outRes,
+	for i := range insRes {
+		insRes[i].Sequence = options.Sequence
 	}
 
-	hash, err := msgpack.Marshal(transac)
+	transac := &Transaction{
+		Ins:         insRes,
+		Outs:        outRes,
+		Replaceable: options.Replaceable,
+		LockTime:    options.LockTime,
+	}
 
-	if err != nil {
-		bc.logger.Warning("Cannot marshal the transaction", err)
+	if err := transac.Sign(bc.wallets["main.key"], bc.params.ID); err != nil {
+		bc.logger.Warning("Cannot create transaction:", err)
 
 		return nil
 	}
 
-	newHash := NewHash(hash)
-	transac.Stamp.Hash = newHash
+	return transac
+}
 
-	r, s, err := ecdsa.Sign(rand.Reader, bc.wallets["main.key"].key, newHash)
+// Create a transaction spending given ins and sign it with the wallet key for the chain
+func NewSignedTransaction(ins []TxIn, outs []TxOut, wallet *Wallet, chainID uint32) (*Transaction, error) {
+	transac := &Transaction{
+		Ins:  ins,
+		Outs: outs,
+	}
 
-	if err != nil {
-		bc.logger.Warning("Cannot create transaction: Signature error", err)
+	if err := transac.Sign(wallet, chainID); err != nil {
+		return nil, err
+	}
 
-		return nil
+	return transac, nil
+}
+
+// Stamp the transaction with the wallet pub key and the chain ID, then hash and sign it
+func (this *Transaction) Sign(wallet *Wallet, chainID uint32) error {
+	this.ChainID = chainID
+	this.Stamp = Stamp{
+		Pub:       wallet.pub,
+		Timestamp: time.Now().Unix(),
+		Hash:      []byte{},
+		R:         []byte{},
+		S:         []byte{},
 	}
 
-	transac.Stamp.R = r.Bytes()
-	transac.Stamp.S = s.Bytes()
+	newHash := this.SigningHash()
 
-	return transac
+	this.Stamp.Hash = newHash
+
+	r, s, err := ecdsa.Sign(rand.Reader, wallet.key, newHash)
+
+	if err != nil {
+		return errors.New("Signature error: " + err.Error())
+	}
+
+	this.Stamp.R = r.Bytes()
+	this.Stamp.S = s.Bytes()
+
+	return nil
+}
+
+// Hash covered by the signatures, computed over the canonical encoding without
+// them. Multisig signatures and unlocking scripts are left out too, so they can
+// be added without changing the transaction hash
+func (this *Transaction) SigningHash() []byte {
+	enc := &encoder{}
+
+	encodeTransaction(enc, this, false)
+
+	return NewHash(enc.Bytes())
 }
 
-func NewCoinBaseTransaction(bc *Blockchain) *Transaction {
+// The coinbase of the block at `height`, paying its subsidy to main.key. Its lock time
+// is the height, so two coinbases never share a hash
+func NewCoinBaseTransaction(bc *Blockchain, height int64) *Transaction {
 	transac := &Transaction{
+		ChainID: bc.params.ID,
 		Stamp: Stamp{
 			Pub:       bc.wallets["main.key"].pub,
 			Timestamp: time.Now().Unix(),
@@ -190,20 +345,13 @@ func NewCoinBaseTransaction(bc *Blockchain) *Transaction {
 		},
 		Ins: []TxIn{},
 		Outs: []TxOut{TxOut{
-			Value:   100,
+			Value:   bc.params.Subsidy(height),
 			Address: []byte(SanitizePubKey(bc.wallets["main.key"].pub)),
 		}},
+		LockTime: height,
 	}
 
-	hash, err := msgpack.Marshal(transac)
-
-	if err != nil {
-		bc.logger.Warning("Cannot marshal the transaction", err)
-
-		return nil
-	}
-
-	newHash := NewHash(hash)
+	newHash := transac.SigningHash()
 
 	transac.Stamp.Hash = newHash
 
@@ -219,42 +367,92 @@ func NewCoinBaseTransaction(bc *Blockchain) *Transaction {
 	return transac
 }
 
+// Remove the mined transactions from the mempool, along with the ones
+// conflicting with them
 func (this *Blockchain) RemovePendingTransaction(insTx []Transaction) {
 	for _, inTx := range insTx {
-		inTxHash := inTx.Stamp.Hash
-
-		idx := -1
+		this.mempool.Remove(inTx.Stamp.Hash)
 
-		for i, tx := range this.pendingTransactions {
-			if compare(inTxHash, tx.Stamp.Hash) == 0 {
-				idx = i
-				break
+		for _, in := range inTx.Ins {
+			if conflict := this.mempool.Spender(&in); conflict != nil {
+				this.dropPendingTransaction(conflict.Tx.Stamp.Hash)
 			}
 		}
+	}
+}
+
+// Remove a transaction that will not be mined along with its descendants,
+// and release the outs they reserved
+func (this *Blockchain) dropPendingTransaction(txHash []byte) {
+	descendants := this.mempool.Descendants(txHash)
+
+	for i := len(descendants) - 1; i >= 0; i-- {
+		this.dropPendingTransaction(descendants[i].Tx.Stamp.Hash)
+	}
 
-		if idx > -1 {
-			this.pendingTransactions = append(this.pendingTransactions[:idx], this.pendingTransactions[idx+1:]...)
+	entry := this.mempool.Remove(txHash)
+
+	if entry == nil {
+		return
+	}
+
+	for _, in := range entry.Tx.Ins {
+		if out := this.getCorrespondingOutTx(entry.Tx.Stamp.Pub, &in); out != nil {
+			out.IsTargeted = false
 		}
 	}
 }
 
+func (this *Blockchain) expirePendingTransactions() {
+	for _, entry := range this.mempool.Expired(time.Now().Unix()) {
+		this.logger.Info("Dropping expired transaction", hex.EncodeToString(entry.Tx.Stamp.Hash))
+
+		this.dropPendingTransaction(entry.Tx.Stamp.Hash)
+	}
+}
+
 func (this *Blockchain) AddTransationToWaiting(tx *Transaction) bool {
-	if !tx.Verify(this) || this.hasPending(tx) {
+	this.Lock()
+	defer this.Unlock()
+
+	return this.addTransactionToWaiting(tx, time.Now().Unix())
+}
+
+// Same as AddTransationToWaiting, with `admitted` the time the transaction was first seen.
+// Must be called with the lock held
+func (this *Blockchain) addTransactionToWaiting(tx *Transaction, admitted int64) bool {
+	this.expirePendingTransactions()
+
+	if this.mempool.Has(tx.Stamp.Hash) || !tx.Verify(this) {
 		this.logger.Warning("Cannot add transaction to waiting")
 
 		return false
 	}
 
-	if HasDoubleSpend(append(this.pendingTransactions, *tx)) {
+	if len(tx.Ins) == 0 {
+		this.logger.Warning("Cannot add transaction to waiting: Coinbase transaction")
+
+		return false
+	}
+
+	if HasDoubleSpend([]Transaction{*tx}) {
 		this.logger.Warning("Cannot add transaction to waiting: Has double spend")
 
 		return false
 	}
 
+	ctx := this.nextTxContext()
+
+	insTotal := 0
 	outs := []*UnspentTxOut{}
+	parents := make(map[string]bool)
+	conflicts := make(map[string]*MempoolEntry)
 	for _, in := range tx.Ins {
-		out := this.getCorrespondingOutTx(tx.Stamp.Pub, &in)
-		outs = append(outs, out)
+		if spender := this.mempool.Spender(&in); spender != nil {
+			conflicts[string(spender.Tx.Stamp.Hash)] = spender
+		}
+
+		out := ctx.lookup(tx.Stamp.Pub, &in)
 
 		if out == nil {
 			this.logger.Warning("Cannot find corresponding out")
@@ -262,18 +460,73 @@ func (this *Blockchain) AddTransationToWaiting(tx *Transaction) bool {
 			return false
 		}
 
-		if out.IsTargeted {
-			this.logger.Warning("Got transaction with double spending")
+		insTotal += out.Out.Value
+
+		if this.mempool.Has(in.PrevHash) {
+			parents[string(in.PrevHash)] = true
+		} else {
+			outs = append(outs, out)
+		}
+	}
+
+	ancestors := this.mempool.Ancestors(parents)
+
+	for hash := range conflicts {
+		if ancestors[hash] {
+			this.logger.Warning("Cannot add transaction to waiting: Spends the transaction it replaces")
 
 			return false
 		}
 	}
 
+	if len(ancestors) >= MEMPOOL_MAX_ANCESTORS {
+		this.logger.Warning("Cannot add transaction to waiting: Too many unconfirmed ancestors")
+
+		return false
+	}
+
+	outsTotal := 0
+	for _, out := range tx.Outs {
+		outsTotal += out.Value
+	}
+
+	entry := NewMempoolEntry(tx, insTotal-outsTotal)
+
+	if len(conflicts) > 0 {
+		if err := this.canReplace(entry, conflicts); err != nil {
+			this.logger.Warning("Got transaction with double spending:", err)
+
+			return false
+		}
+
+		for _, conflict := range conflicts {
+			this.logger.Info("Replacing transaction", hex.EncodeToString(conflict.Tx.Stamp.Hash))
+
+			this.dropPendingTransaction(conflict.Tx.Stamp.Hash)
+		}
+	}
+
+	evicted, err := this.mempool.MakeRoomFor(entry, ancestors)
+
+	if err != nil {
+		this.logger.Warning("Cannot add transaction to waiting:", err)
+
+		return false
+	}
+
+	for _, evictedEntry := range evicted {
+		this.logger.Info("Evicting transaction", hex.EncodeToString(evictedEntry.Tx.Stamp.Hash))
+
+		this.dropPendingTransaction(evictedEntry.Tx.Stamp.Hash)
+	}
+
 	for _, out := range outs {
 		out.IsTargeted = true
 	}
 
-	this.pendingTransactions = append(this.pendingTransactions, *tx)
+	entry.Time = admitted
+
+	this.mempool.Add(entry)
 
 	return true
 }
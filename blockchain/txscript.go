@@ -0,0 +1,132 @@
+package blockchain
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"errors"
+	"math/big"
+
+	"github.com/champii/crypto-dht/blockchain/script"
+)
+
+// Size of a script signature: R and S on 32 bytes each
+const SCRIPT_SIG_SIZE = 64
+
+// The locking script of the spent out, whose hash is the out address, and the
+// unlocking script satisfying it
+type ScriptSpend struct {
+	Lock   []byte
+	Unlock []byte `msgpack:",omitempty"`
+}
+
+// A pub key is not a locking script, even when it happens to parse as one
+func (this *ScriptSpend) Check() error {
+	if _, err := parsePubKey(this.Lock); err == nil {
+		return errors.New("Locking script is a pub key")
+	}
+
+	return nil
+}
+
+func (this *ScriptSpend) Verify(tx *Transaction, in *TxIn, hash []byte) error {
+	checker := &txChecker{
+		tx:   tx,
+		in:   in,
+		hash: hash,
+	}
+
+	return script.Verify(this.Unlock, this.Lock, checker)
+}
+
+// The address owning the out spent by this in: the multisig or script address if any,
+// the signer of the transaction otherwise
+func (this *TxIn) OwnerAddress(stampPub []byte) string {
+	if this.Multisig != nil {
+		return this.Multisig.Multisig.Address()
+	}
+
+	if this.Script != nil {
+		return script.Script(this.Script.Lock).Address()
+	}
+
+	return SanitizePubKey(stampPub)
+}
+
+// The transaction checks needed by the script interpreter
+type txChecker struct {
+	tx   *Transaction
+	in   *TxIn
+	hash []byte
+}
+
+func (this *txChecker) CheckSig(sig, pub []byte) bool {
+	if len(sig) != SCRIPT_SIG_SIZE {
+		return false
+	}
+
+	publicKey, err := parsePubKey(pub)
+
+	if err != nil {
+		return false
+	}
+
+	var r big.Int
+	r.SetBytes(sig[:SCRIPT_SIG_SIZE/2])
+
+	var s big.Int
+	s.SetBytes(sig[SCRIPT_SIG_SIZE/2:])
+
+	return ecdsa.Verify(publicKey, this.hash, &r, &s)
+}
+
+// Heights and timestamps cannot be compared, the transaction finality
+// itself is checked by IsFinal
+func (this *txChecker) CheckLockTime(lockTime int64) bool {
+	if (lockTime < LOCKTIME_THRESHOLD) != (this.tx.LockTime < LOCKTIME_THRESHOLD) {
+		return false
+	}
+
+	return this.tx.LockTime >= lockTime
+}
+
+// The relative lock itself is checked by IsRelativeLocked
+func (this *txChecker) CheckSequence(sequence int64) bool {
+	return this.in.Sequence >= sequence
+}
+
+// Signature of the transaction to put in an unlocking script
+func (this *Transaction) ScriptSignature(wallet *Wallet) ([]byte, error) {
+	hash := this.SigningHash()
+
+	r, s, err := ecdsa.Sign(rand.Reader, wallet.key, hash)
+
+	if err != nil {
+		return nil, errors.New("Signature error: " + err.Error())
+	}
+
+	// Left pad R and S to their fixed size
+	sig := make([]byte, SCRIPT_SIG_SIZE)
+	rBytes := r.Bytes()
+	sBytes := s.Bytes()
+
+	copy(sig[SCRIPT_SIG_SIZE/2-len(rBytes):SCRIPT_SIG_SIZE/2], rBytes)
+	copy(sig[SCRIPT_SIG_SIZE-len(sBytes):], sBytes)
+
+	return sig, nil
+}
+
+// Hash of the wallet pub key, as expected by the pay to pub key hash scripts
+func (this *Wallet) PubHash() []byte {
+	return NewHash(this.pub)
+}
+
+// Parse a locking script in its text form, and give the address to pay to lock outs with it
+func ScriptAddress(text string) (string, script.Script, error) {
+	lock, err := script.Parse(text)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	return lock.Address(), lock, nil
+}
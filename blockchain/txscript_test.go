@@ -0,0 +1,99 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/champii/crypto-dht/blockchain/script"
+)
+
+func TestScriptSpend(t *testing.T) {
+	wallet := newTestWallet(t, "main.key")
+
+	lock, err := script.TimeLock(50, wallet.PubHash())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx := &Transaction{
+		LockTime: 60,
+		Ins:      []TxIn{{PrevHash: []byte("x"), Script: &ScriptSpend{Lock: lock}}},
+		Outs:     []TxOut{{Value: 1, Address: []byte("dest")}},
+	}
+
+	if err := tx.Sign(wallet, MainParams.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	sig, err := tx.ScriptSignature(wallet)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx.Ins[0].Script.Unlock, err = script.UnlockPubKeyHash(sig, wallet.pub)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash := tx.SigningHash()
+
+	if compare(hash, tx.Stamp.Hash) != 0 {
+		t.Fatal("unlocking script changed the hash")
+	}
+
+	if tx.Ins[0].OwnerAddress(wallet.pub) != lock.Address() {
+		t.Fatal("in not owned by the script address")
+	}
+
+	tests := []struct {
+		lockTime int64
+		ok       bool
+	}{
+		{60, true},
+		{50, true},
+		{49, false},
+		// A timestamp does not reach a height
+		{LOCKTIME_THRESHOLD, false},
+	}
+
+	for _, test := range tests {
+		tx.LockTime = test.lockTime
+
+		if err := tx.Ins[0].Script.Verify(tx, &tx.Ins[0], hash); (err == nil) != test.ok {
+			t.Errorf("lock time %d: %v", test.lockTime, err)
+		}
+	}
+}
+
+// A pub key given as a locking script does not spend the outs of its owner
+func TestPubKeyLockRefused(t *testing.T) {
+	bc := newTestBlockchain(RegtestParams)
+	victim := newTestWallet(t, "victim")
+	attacker := newTestWallet(t, "attacker")
+	out := giveTestOut(bc, victim, "out", 100, 0, false)
+
+	if script.Script(victim.pub).Address() == SanitizePubKey(victim.pub) {
+		t.Fatal("script address is the pub key address")
+	}
+
+	spend := &ScriptSpend{Lock: victim.pub}
+
+	if err := spend.Check(); err == nil {
+		t.Fatal("pub key accepted as a locking script")
+	}
+
+	tx := &Transaction{
+		Ins:  []TxIn{{PrevHash: out.TxHash, PrevIdx: out.InIdx, Script: spend}},
+		Outs: []TxOut{{Value: 100, Address: []byte(SanitizePubKey(attacker.pub))}},
+	}
+
+	if err := tx.Sign(attacker, bc.params.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if tx.verifyInContext(bc, testContext(bc, 1, 0)) {
+		t.Fatal("spend with a pub key as locking script accepted")
+	}
+}
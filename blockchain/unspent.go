@@ -1,13 +1,13 @@
 package blockchain
 
-import "strconv"
+import "time"
 
 func (this *Blockchain) getUnspentTxOut() {
 
 }
 
 func (this *Blockchain) getCorrespondingOutTx(wallet []byte, in *TxIn) *UnspentTxOut {
-	walletStr := SanitizePubKey(wallet)
+	walletStr := in.OwnerAddress(wallet)
 
 	outs, ok := this.unspentTxOut[walletStr]
 
@@ -24,20 +24,37 @@ func (this *Blockchain) getCorrespondingOutTx(wallet []byte, in *TxIn) *UnspentT
 	return nil
 }
 
-func (this *Blockchain) UpdateUnspentTxOuts(block *Block) {
-	for _, tx := range block.Transactions {
-		hash := tx.Stamp.Hash
+// Same as getCorrespondingOutTx, but also look for the outs of pending transactions
+func (this *Blockchain) getCorrespondingOutTxOrPending(wallet []byte, in *TxIn) *UnspentTxOut {
+	if out := this.getCorrespondingOutTx(wallet, in); out != nil {
+		return out
+	}
 
-		own := false
+	entry := this.mempool.Get(in.PrevHash)
 
-		txValue := 0
+	if entry == nil || in.PrevIdx < 0 || in.PrevIdx >= len(entry.Tx.Outs) {
+		return nil
+	}
 
-		ownAddrStr := []byte(SanitizePubKey(this.wallets["main.key"].pub))
+	out := entry.Tx.Outs[in.PrevIdx]
 
-		addr := SanitizePubKey(tx.Stamp.Pub)
-		if compare(tx.Stamp.Pub, this.wallets["main.key"].pub) == 0 {
-			own = true
-		}
+	if string(out.Address) != in.OwnerAddress(wallet) {
+		return nil
+	}
+
+	return &UnspentTxOut{
+		Out:    out,
+		TxHash: entry.Tx.Stamp.Hash,
+		InIdx:  in.PrevIdx,
+		Height: this.nextTxContext().height,
+	}
+}
+
+func (this *Blockchain) UpdateUnspentTxOuts(block *Block) {
+	for _, tx := range block.Transactions {
+		hash := tx.Stamp.Hash
+
+		insTotal := 0
 
 		for _, in := range tx.Ins {
 			out := this.getCorrespondingOutTx(tx.Stamp.Pub, &in)
@@ -48,45 +65,41 @@ func (this *Blockchain) UpdateUnspentTxOuts(block *Block) {
 				return
 			}
 
-			this.RemoveUnspentOut(tx.Stamp.Pub, out)
+			insTotal += out.Out.Value
+
+			this.RemoveUnspentOut(out)
 		}
 
 		for i, out := range tx.Outs {
-			if own && compare(out.Address, ownAddrStr) != 0 {
-				txValue -= out.Value
-				addr = string(out.Address)
-			}
-
-			if !own && compare(out.Address, ownAddrStr) == 0 {
-				txValue += out.Value
-			}
-
-			if len(tx.Ins) == 0 && len(tx.Outs) == 1 && compare(out.Address, ownAddrStr) == 0 {
-				txValue += out.Value
-				addr = "Miner fee (Block " + strconv.FormatInt(block.Header.Height, 10) + ")"
+			if out.IsData() {
+				continue
 			}
 
-			walletStr := string(out.Address)
-
-			this.unspentTxOut[walletStr] = append(this.unspentTxOut[walletStr], UnspentTxOut{
-				Out:    out,
-				InIdx:  i,
-				TxHash: hash,
+			this.addUnspentOut(UnspentTxOut{
+				Out:        out,
+				InIdx:      i,
+				TxHash:     hash,
+				IsCoinbase: len(tx.Ins) == 0,
+				Height:     block.Header.Height,
 			})
 		}
 
-		if txValue != 0 {
-			this.history = append(this.history, HistoryTx{
-				Address:   addr,
-				Timestamp: block.Header.Timestamp,
-				Amount:    txValue,
-			})
-		}
+		this.recordHistory(block, &tx, insTotal)
+		this.recordRevealedSecrets(&tx)
+		this.indexData(block, &tx)
 	}
 }
 
-func (this *Blockchain) RemoveUnspentOut(wallet []byte, out *UnspentTxOut) {
-	walletStr := SanitizePubKey(wallet)
+// Add the out to the unspent outs of its address and to the UTXO tree
+func (this *Blockchain) addUnspentOut(out UnspentTxOut) {
+	walletStr := string(out.Out.Address)
+
+	this.unspentTxOut[walletStr] = append(this.unspentTxOut[walletStr], out)
+	this.utxos.Add(&out)
+}
+
+func (this *Blockchain) RemoveUnspentOut(out *UnspentTxOut) {
+	walletStr := string(out.Out.Address)
 	idx := -1
 
 	for i := range this.unspentTxOut[walletStr] {
@@ -101,52 +114,155 @@ func (this *Blockchain) RemoveUnspentOut(wallet []byte, out *UnspentTxOut) {
 		return
 	}
 
+	this.utxos.Remove(out)
+
 	this.unspentTxOut[walletStr] = append(this.unspentTxOut[walletStr][:idx], this.unspentTxOut[walletStr][idx+1:]...)
 }
 
-func (this *Blockchain) GetEnoughOwnUnspentOut(value int) []UnspentTxOut {
-	walletStr := SanitizePubKey(this.wallets["main.key"].pub)
+// Select outs of main.key worth at least value. With a sequence > 0, only the outs
+// confirmed for that many blocks are candidates
+func (this *Blockchain) GetEnoughOwnUnspentOut(value int, selector CoinSelector, sequence int64) []UnspentTxOut {
+	available := []UnspentTxOut{}
+	height := this.nextTxContext().height
+	in := TxIn{Sequence: sequence}
 
-	var res []UnspentTxOut
+	for _, unspent := range this.getSpendableOuts(this.wallets["main.key"]) {
+		if !in.IsRelativeLocked(&unspent, height) {
+			available = append(available, unspent)
+		}
+	}
 
-	total := 0
-	for _, unspent := range this.unspentTxOut[walletStr] {
-		if unspent.IsTargeted {
+	return selector(available, value)
+}
+
+// Outs the wallet can spend right now: the mature confirmed ones not already spent by
+// a pending transaction, and the ones created by its own pending transactions
+func (this *Blockchain) getSpendableOuts(wallet *Wallet) []UnspentTxOut {
+	walletStr := SanitizePubKey(wallet.pub)
+	height := this.nextTxContext().height
+
+	res := this.getConfirmedSpendableOuts(walletStr)
+
+	for _, entry := range this.mempool.Entries() {
+		if compare(entry.Tx.Stamp.Pub, wallet.pub) != 0 {
 			continue
 		}
 
-		total += unspent.Out.Value
+		for i, out := range entry.Tx.Outs {
+			if string(out.Address) != walletStr {
+				continue
+			}
 
-		res = append(res, unspent)
+			in := TxIn{
+				PrevHash: entry.Tx.Stamp.Hash,
+				PrevIdx:  i,
+			}
 
-		if total > value {
-			break
+			if this.mempool.Spender(&in) != nil {
+				continue
+			}
+
+			res = append(res, UnspentTxOut{
+				Out:    out,
+				TxHash: entry.Tx.Stamp.Hash,
+				InIdx:  i,
+				Height: height,
+			})
 		}
 	}
 
-	if total < value {
-		return []UnspentTxOut{}
+	return res
+}
+
+// Mature confirmed outs of the address not already spent by a pending transaction
+func (this *Blockchain) getConfirmedSpendableOuts(address string) []UnspentTxOut {
+	res := []UnspentTxOut{}
+	height := this.nextTxContext().height
+
+	for _, unspent := range this.unspentTxOut[address] {
+		if unspent.IsTargeted || !this.isMature(&unspent, height) {
+			continue
+		}
+
+		// The out may come from a transaction mined after its pending spender
+		in := TxIn{
+			PrevHash: unspent.TxHash,
+			PrevIdx:  unspent.InIdx,
+		}
+
+		if this.mempool.Spender(&in) != nil {
+			continue
+		}
+
+		res = append(res, unspent)
 	}
 
 	return res
 }
 
+// Funds held by an address, a multisig one for instance
+func (this *Blockchain) GetAddressFunds(address string) int {
+	total := 0
+
+	for _, out := range this.unspentTxOut[address] {
+		total += out.Out.Value
+	}
+
+	return total
+}
+
+// Spendable funds of the wallet, immature coinbase outs excluded
 func (this *Blockchain) GetAvailableFunds(wallet []byte) int {
 	walletStr := SanitizePubKey(wallet)
-	var total int
+	height := this.nextTxContext().height
 
-	total = 0
+	total := 0
 
-	// fmt.Println("Ouech", hex.EncodeToString(wallet.pub))
 	for _, out := range this.unspentTxOut[walletStr] {
-		total += out.Out.Value
+		if this.isMature(&out, height) {
+			total += out.Out.Value
+		}
 	}
 
 	return total
 }
 
+// Funds of the wallet locked in coinbase outs that cannot be spent yet
+func (this *Blockchain) GetImmatureFunds(wallet []byte) int {
+	walletStr := SanitizePubKey(wallet)
+	height := this.nextTxContext().height
+
+	total := 0
+
+	for _, out := range this.unspentTxOut[walletStr] {
+		if !this.isMature(&out, height) {
+			total += out.Out.Value
+		}
+	}
+
+	return total
+}
+
+// A coinbase out can be spent in a block at least CoinbaseMaturity blocks after its own
+func (this *Blockchain) isMature(out *UnspentTxOut, height int64) bool {
+	if !out.IsCoinbase {
+		return true
+	}
+
+	return height-out.Height >= this.params.CoinbaseMaturity
+}
+
+func (this *Blockchain) nextTxContext() txContext {
+	return txContext{
+		height:    this.headers[len(this.headers)-1].Height + 1,
+		timestamp: time.Now().Unix(),
+		lookup:    this.getCorrespondingOutTxOrPending,
+	}
+}
+
 // Used to create a transaction without loss
-func (this *Blockchain) GetInOutFromUnspent(value int, destWallet []byte, outs []UnspentTxOut) ([]TxIn, []TxOut) {
+// Gives one out for each payment, plus one change out if needed
+func (this *Blockchain) GetInOutFromUnspent(payments []TxOut, fee int, outs []UnspentTxOut) ([]TxIn, []TxOut) {
 	insRes := []TxIn{}
 	outsRes := []TxOut{}
 
@@ -160,14 +276,16 @@ func (this *Blockchain) GetInOutFromUnspent(value int, destWallet []byte, outs [
 		total += out.Out.Value
 	}
 
-	outsRes = append(outsRes, TxOut{
-		Value:   value,
-		Address: destWallet,
-	})
+	value := 0
+	for _, payment := range payments {
+		outsRes = append(outsRes, payment)
+
+		value += payment.Value
+	}
 
-	if total > value {
+	if total > value+fee {
 		outsRes = append(outsRes, TxOut{
-			Value:   total - value,
+			Value:   total - value - fee,
 			Address: []byte(SanitizePubKey(this.wallets["main.key"].pub)),
 		})
 	}
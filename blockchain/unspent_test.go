@@ -0,0 +1,80 @@
+package blockchain
+
+import "testing"
+
+func TestIsMature(t *testing.T) {
+	tests := []struct {
+		params   ChainParams
+		coinbase bool
+		created  int64
+		height   int64
+		mature   bool
+	}{
+		{MainParams, true, 10, 109, false},
+		{MainParams, true, 10, 110, true},
+		{MainParams, false, 10, 11, true},
+		{RegtestParams, true, 10, 10, false},
+		{RegtestParams, true, 10, 11, true},
+	}
+
+	for i, test := range tests {
+		bc := newTestBlockchain(test.params)
+		out := &UnspentTxOut{IsCoinbase: test.coinbase, Height: test.created}
+
+		if bc.isMature(out, test.height) != test.mature {
+			t.Errorf("%d: isMature = %v, want %v", i, !test.mature, test.mature)
+		}
+	}
+}
+
+// The maturity is a rule of the chain: the options cannot change it
+func TestImmatureCoinbaseSpend(t *testing.T) {
+	bc := newTestBlockchain(MainParams)
+	wallet := newTestWallet(t, "main.key")
+	out := giveTestOut(bc, wallet, "coinbase", 100, 5, true)
+	tx := newTestSpend(t, bc, wallet, []UnspentTxOut{out}, 100, "dest")
+
+	if tx.verifyInContext(bc, testContext(bc, 104, 0)) {
+		t.Fatal("immature coinbase spend accepted")
+	}
+
+	if !tx.verifyInContext(bc, testContext(bc, 105, 0)) {
+		t.Fatal("mature coinbase spend refused")
+	}
+
+	if bc.GetAvailableFunds(wallet.pub) != 0 {
+		t.Fatal("immature coinbase counted as available")
+	}
+}
+
+// Blocks mined in the same second still get coinbases of their own
+func TestCoinbaseCommitsToHeight(t *testing.T) {
+	bc := newTestNode(t, RegtestParams)
+	dest := newTestWallet(t, "dest")
+
+	first := mineTestBlock(t, bc)
+
+	if _, err := bc.SendTo([]string{"100:" + SanitizePubKey(dest.pub)}, SendOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	second := mineTestBlock(t, bc)
+
+	if compare(first.Transactions[0].Stamp.Hash, second.Transactions[0].Stamp.Hash) == 0 {
+		t.Fatal("coinbases share a hash")
+	}
+
+	if len(second.Transactions) != 2 || bc.GetAvailableFunds(dest.pub) != 100 {
+		t.Fatal("coinbase of the previous block not spent")
+	}
+
+	coinbase := NewCoinBaseTransaction(bc, 5)
+
+	if !coinbase.verifyInContext(bc, testContext(bc, 5, 0)) {
+		t.Fatal("coinbase refused at its height")
+	}
+
+	if coinbase.verifyInContext(bc, testContext(bc, 6, 0)) {
+		t.Fatal("coinbase accepted at another height")
+	}
+}
@@ -0,0 +1,198 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Proof that an out is unspent once the block at Height is applied: the hashes
+// needed to rebuild the UTXO root of its header from the out, see utxoroot.go.
+// Checking one only needs the headers, as light nodes have
+
+type UnspentProof struct {
+	Height int64
+	Out    UnspentTxOut
+
+	// Position of the out in its bucket
+	Index int
+
+	// Siblings of the out hash up to the bucket root, then of the bucket root up to
+	// the UTXO root
+	Branch       [][]byte
+	BucketBranch [][]byte
+}
+
+// Build the proof that the out is unspent at our last block
+func (this *Blockchain) ProveUnspent(txHash []byte, idx int) (*UnspentProof, error) {
+	this.RLock()
+	defer this.RUnlock()
+
+	if this.options.Light {
+		return nil, errors.New("Light nodes have no unspent outs")
+	}
+
+	for _, unspents := range this.unspentTxOut {
+		for _, unspent := range unspents {
+			if unspent.InIdx != idx || compare(unspent.TxHash, txHash) != 0 {
+				continue
+			}
+
+			unspent.IsTargeted = false
+
+			index, branch, bucketBranch, ok := this.utxos.Prove(&unspent)
+
+			if !ok {
+				return nil, errors.New("Out missing from the UTXO tree")
+			}
+
+			return &UnspentProof{
+				Height:       this.BlocksHeight(),
+				Out:          unspent,
+				Index:        index,
+				Branch:       branch,
+				BucketBranch: bucketBranch,
+			}, nil
+		}
+	}
+
+	return nil, errors.New("Unknown unspent out")
+}
+
+// Check the proof against our headers
+func (this *Blockchain) VerifyUnspentProof(proof *UnspentProof) error {
+	this.RLock()
+	defer this.RUnlock()
+
+	if proof.Height <= 0 || proof.Height > this.BlocksHeight() {
+		return errors.New("Unknown block " + strconv.FormatInt(proof.Height, 10))
+	}
+
+	if len(proof.BucketBranch) != UTXO_BUCKETS_DEPTH {
+		return errors.New("Bad bucket branch")
+	}
+
+	// A branch of n hashes leads to one of 2^n leaves, so each leaf has one index
+	if proof.Index < 0 || len(proof.Branch) > 30 || proof.Index >= 1<<uint(len(proof.Branch)) {
+		return errors.New("Bad out index")
+	}
+
+	leaf := HashUnspentOut(&proof.Out)
+	bucketRoot := merkelRootFromBranch(leaf, proof.Index, proof.Branch)
+	root := merkelRootFromBranch(bucketRoot, utxoBucket(leaf), proof.BucketBranch)
+
+	if compare(root, this.headers[proof.Height].UtxoRoot) != 0 {
+		return errors.New("Out not unspent at block " + strconv.FormatInt(proof.Height, 10))
+	}
+
+	return nil
+}
+
+func EncodeUnspentProof(proof *UnspentProof) []byte {
+	enc := &encoder{}
+
+	enc.int64(proof.Height)
+	encodeUnspentOut(enc, &proof.Out)
+	enc.int64(int64(proof.Index))
+
+	for _, branch := range [][][]byte{proof.Branch, proof.BucketBranch} {
+		enc.uint32(uint32(len(branch)))
+
+		for _, hash := range branch {
+			enc.bytes(hash)
+		}
+	}
+
+	return enc.Bytes()
+}
+
+func DecodeUnspentProof(data []byte) (*UnspentProof, error) {
+	dec := &decoder{data: data}
+	proof := &UnspentProof{}
+
+	proof.Height = dec.int64()
+	proof.Out = decodeUnspentOut(dec)
+	proof.Index = int(dec.int64())
+
+	for _, branch := range []*[][]byte{&proof.Branch, &proof.BucketBranch} {
+		*branch = make([][]byte, dec.count(4))
+
+		for i := range *branch {
+			(*branch)[i] = dec.bytes()
+		}
+	}
+
+	if err := dec.end(); err != nil {
+		return nil, errors.New("Bad proof: " + err.Error())
+	}
+
+	return proof, nil
+}
+
+// Parse an out of the form 'txHash:outIdx'
+func parseOutpoint(value string) ([]byte, int, error) {
+	splited := strings.Split(value, ":")
+
+	if len(splited) != 2 {
+		return nil, 0, errors.New("Bad format, must be 'txHash:outIdx'")
+	}
+
+	txHash, err := hex.DecodeString(splited[0])
+
+	if err != nil || len(txHash) == 0 {
+		return nil, 0, errors.New("Invalid transaction hash: " + splited[0])
+	}
+
+	idx, err := strconv.Atoi(splited[1])
+
+	if err != nil || idx < 0 {
+		return nil, 0, errors.New("Invalid out index: " + splited[1])
+	}
+
+	return txHash, idx, nil
+}
+
+func (this *Blockchain) ShowUnspentProof(value string) error {
+	txHash, idx, err := parseOutpoint(value)
+
+	if err != nil {
+		return err
+	}
+
+	proof, err := this.ProveUnspent(txHash, idx)
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Proof:", hex.EncodeToString(EncodeUnspentProof(proof)))
+
+	return nil
+}
+
+func (this *Blockchain) ShowUnspentProofCheck(value string) error {
+	data, err := hex.DecodeString(value)
+
+	if err != nil {
+		return errors.New("Invalid proof, must be hex encoded")
+	}
+
+	proof, err := DecodeUnspentProof(data)
+
+	if err != nil {
+		return err
+	}
+
+	if err := this.VerifyUnspentProof(proof); err != nil {
+		return err
+	}
+
+	fmt.Println("Out:          ", hex.EncodeToString(proof.Out.TxHash)+":"+strconv.Itoa(proof.Out.InIdx))
+	fmt.Println("Pays:         ", proof.Out.Out.Value, "ctd to", string(proof.Out.Out.Address))
+	fmt.Println("Created at:   ", proof.Out.Height)
+	fmt.Println("Unspent at:   ", proof.Height)
+
+	return nil
+}
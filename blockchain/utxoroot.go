@@ -0,0 +1,311 @@
+package blockchain
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+)
+
+// Each header commits to the unspent outs once its block is applied, with the
+// UTXO root. The hash of each out goes in one of UTXO_BUCKETS buckets, after the
+// first bits of that hash. The root is the Merkle root of the buckets roots, each
+// being the Merkle root of the sorted hashes of its outs. Snapshots and light
+// clients can then check unspent outs against the headers alone.
+//
+// Nodes keep the tree of their unspent outs, so a block only computes again the
+// roots of the buckets it changes
+
+const (
+	UTXO_BUCKETS = 4096
+
+	// Number of hashes from a bucket root to the UTXO root
+	UTXO_BUCKETS_DEPTH = 12
+)
+
+type UtxoTree struct {
+	sync.Mutex
+
+	// Hashes of the outs, by outpoint and sorted in their buckets
+	leaves  map[string][]byte
+	buckets [UTXO_BUCKETS][][]byte
+
+	// Roots of the buckets, nil when it has changed
+	roots [UTXO_BUCKETS][]byte
+}
+
+func encodeUnspentOut(enc *encoder, out *UnspentTxOut) {
+	enc.bytes(out.TxHash)
+	enc.int64(int64(out.InIdx))
+	encodeTxOut(enc, &out.Out)
+	enc.bool(out.IsCoinbase)
+	enc.int64(out.Height)
+}
+
+func decodeUnspentOut(dec *decoder) UnspentTxOut {
+	out := UnspentTxOut{}
+
+	out.TxHash = dec.bytes()
+	out.InIdx = int(dec.int64())
+	out.Out = decodeTxOut(dec)
+	out.IsCoinbase = dec.bool()
+	out.Height = dec.int64()
+
+	return out
+}
+
+// Leaf of the UTXO tree
+func HashUnspentOut(out *UnspentTxOut) []byte {
+	enc := &encoder{}
+
+	encodeUnspentOut(enc, out)
+
+	return NewHash(enc.Bytes())
+}
+
+// Sort by outpoint: TxHash, then InIdx
+func sortUnspentOuts(outs []UnspentTxOut) {
+	sort.Slice(outs, func(i, j int) bool {
+		if cmp := bytes.Compare(outs[i].TxHash, outs[j].TxHash); cmp != 0 {
+			return cmp < 0
+		}
+
+		return outs[i].InIdx < outs[j].InIdx
+	})
+}
+
+// UTXO root of the outs, the hash of nothing when there is none
+func UtxoRoot(outs []UnspentTxOut) []byte {
+	return NewUtxoTree(outs).Root()
+}
+
+func NewUtxoTree(outs []UnspentTxOut) *UtxoTree {
+	tree := &UtxoTree{
+		leaves: make(map[string][]byte),
+	}
+
+	for i := range outs {
+		tree.Add(&outs[i])
+	}
+
+	return tree
+}
+
+// Bucket of the leaf, after its first UTXO_BUCKETS_DEPTH bits
+func utxoBucket(leaf []byte) int {
+	return int(leaf[0])<<4 | int(leaf[1])>>4
+}
+
+// Merkle root of the leaves, the hash of nothing when there is none
+func merkelRoot(leaves [][]byte) []byte {
+	if len(leaves) == 0 {
+		return NewHash(nil)
+	}
+
+	tree := leaves
+
+	for len(tree) > 1 {
+		tree = processOneMerkelTreeRow(tree)
+	}
+
+	return tree[0]
+}
+
+// Position of the leaf in the sorted leaves, or where it would be
+func searchLeaf(leaves [][]byte, leaf []byte) int {
+	return sort.Search(len(leaves), func(i int) bool {
+		return bytes.Compare(leaves[i], leaf) >= 0
+	})
+}
+
+func insertLeaf(leaves [][]byte, leaf []byte) [][]byte {
+	i := searchLeaf(leaves, leaf)
+
+	leaves = append(leaves, nil)
+	copy(leaves[i+1:], leaves[i:])
+	leaves[i] = leaf
+
+	return leaves
+}
+
+func removeLeaf(leaves [][]byte, leaf []byte) [][]byte {
+	i := searchLeaf(leaves, leaf)
+
+	if i == len(leaves) || compare(leaves[i], leaf) != 0 {
+		return leaves
+	}
+
+	return append(leaves[:i], leaves[i+1:]...)
+}
+
+func (this *UtxoTree) Add(out *UnspentTxOut) {
+	this.Lock()
+	defer this.Unlock()
+
+	leaf := HashUnspentOut(out)
+	bucket := utxoBucket(leaf)
+
+	this.leaves[outpointKey(out.TxHash, out.InIdx)] = leaf
+	this.buckets[bucket] = insertLeaf(this.buckets[bucket], leaf)
+	this.roots[bucket] = nil
+}
+
+func (this *UtxoTree) Remove(out *UnspentTxOut) {
+	this.Lock()
+	defer this.Unlock()
+
+	key := outpointKey(out.TxHash, out.InIdx)
+	leaf, ok := this.leaves[key]
+
+	if !ok {
+		return
+	}
+
+	bucket := utxoBucket(leaf)
+
+	delete(this.leaves, key)
+	this.buckets[bucket] = removeLeaf(this.buckets[bucket], leaf)
+	this.roots[bucket] = nil
+}
+
+// Must be called with the lock held
+func (this *UtxoTree) bucketRoot(bucket int) []byte {
+	if this.roots[bucket] == nil {
+		this.roots[bucket] = merkelRoot(this.buckets[bucket])
+	}
+
+	return this.roots[bucket]
+}
+
+// Root of the buckets, with the leaves of some of them changed. Must be called with
+// the lock held
+func (this *UtxoTree) rootWith(changed map[int][][]byte, size int) []byte {
+	if size == 0 {
+		return NewHash(nil)
+	}
+
+	roots := make([][]byte, UTXO_BUCKETS)
+
+	for bucket := range roots {
+		if leaves, ok := changed[bucket]; ok {
+			roots[bucket] = merkelRoot(leaves)
+		} else {
+			roots[bucket] = this.bucketRoot(bucket)
+		}
+	}
+
+	return merkelRoot(roots)
+}
+
+func (this *UtxoTree) Root() []byte {
+	this.Lock()
+	defer this.Unlock()
+
+	return this.rootWith(nil, len(this.leaves))
+}
+
+// Root once the block is applied, without changing the tree. The block
+// transactions must be valid
+func (this *UtxoTree) RootAfter(block *Block) []byte {
+	this.Lock()
+	defer this.Unlock()
+
+	spent := [][]byte{}
+	created := make(map[string][]byte)
+
+	for _, tx := range block.Transactions {
+		for _, in := range tx.Ins {
+			key := outpointKey(in.PrevHash, in.PrevIdx)
+
+			// Created and spent by the block
+			if _, ok := created[key]; ok {
+				delete(created, key)
+
+				continue
+			}
+
+			if leaf, ok := this.leaves[key]; ok {
+				spent = append(spent, leaf)
+			}
+		}
+
+		for i, out := range tx.Outs {
+			if out.IsData() {
+				continue
+			}
+
+			created[outpointKey(tx.Stamp.Hash, i)] = HashUnspentOut(&UnspentTxOut{
+				Out:        out,
+				TxHash:     tx.Stamp.Hash,
+				InIdx:      i,
+				IsCoinbase: len(tx.Ins) == 0,
+				Height:     block.Header.Height,
+			})
+		}
+	}
+
+	// Only the buckets the block changes are copied
+	changed := make(map[int][][]byte)
+
+	bucketOf := func(leaf []byte) int {
+		bucket := utxoBucket(leaf)
+
+		if _, ok := changed[bucket]; !ok {
+			changed[bucket] = append([][]byte{}, this.buckets[bucket]...)
+		}
+
+		return bucket
+	}
+
+	for _, leaf := range spent {
+		bucket := bucketOf(leaf)
+		changed[bucket] = removeLeaf(changed[bucket], leaf)
+	}
+
+	for _, leaf := range created {
+		bucket := bucketOf(leaf)
+		changed[bucket] = insertLeaf(changed[bucket], leaf)
+	}
+
+	return this.rootWith(changed, len(this.leaves)-len(spent)+len(created))
+}
+
+// Position of the out in its bucket, and the branches from its hash to the bucket
+// root and from the bucket root to the UTXO root
+func (this *UtxoTree) Prove(out *UnspentTxOut) (int, [][]byte, [][]byte, bool) {
+	this.Lock()
+	defer this.Unlock()
+
+	leaf, ok := this.leaves[outpointKey(out.TxHash, out.InIdx)]
+
+	if !ok || compare(leaf, HashUnspentOut(out)) != 0 {
+		return 0, nil, nil, false
+	}
+
+	bucket := utxoBucket(leaf)
+	index := searchLeaf(this.buckets[bucket], leaf)
+
+	roots := make([][]byte, UTXO_BUCKETS)
+
+	for i := range roots {
+		roots[i] = this.bucketRoot(i)
+	}
+
+	return index, merkelBranch(this.buckets[bucket], index), merkelBranch(roots, bucket), true
+}
+
+// Our unspent outs, sorted
+func (this *Blockchain) sortedUnspentOuts() []UnspentTxOut {
+	res := []UnspentTxOut{}
+
+	for _, unspents := range this.unspentTxOut {
+		for _, unspent := range unspents {
+			unspent.IsTargeted = false
+
+			res = append(res, unspent)
+		}
+	}
+
+	sortUnspentOuts(res)
+
+	return res
+}
@@ -0,0 +1,174 @@
+package blockchain
+
+import (
+	"strconv"
+	"testing"
+)
+
+func newTestOuts(n int) []UnspentTxOut {
+	outs := []UnspentTxOut{}
+
+	for i := 0; i < n; i++ {
+		outs = append(outs, UnspentTxOut{
+			Out:    TxOut{Value: i, Address: []byte("address")},
+			TxHash: NewHash([]byte(strconv.Itoa(i))),
+			InIdx:  i % 3,
+			Height: int64(i),
+		})
+	}
+
+	return outs
+}
+
+func TestUtxoRootEmpty(t *testing.T) {
+	if compare(UtxoRoot(nil), NewHash(nil)) != 0 {
+		t.Fatal("bad root of nothing")
+	}
+
+	tree := NewUtxoTree(newTestOuts(1))
+	outs := newTestOuts(1)
+	tree.Remove(&outs[0])
+
+	if compare(tree.Root(), NewHash(nil)) != 0 {
+		t.Fatal("bad root once emptied")
+	}
+}
+
+// The root does not depend on how the tree was built
+func TestUtxoTreeIncremental(t *testing.T) {
+	outs := newTestOuts(200)
+	tree := NewUtxoTree(outs[100:])
+
+	for i := 99; i >= 0; i-- {
+		tree.Add(&outs[i])
+	}
+
+	if compare(tree.Root(), UtxoRoot(outs)) != 0 {
+		t.Fatal("root depends on the order of the outs")
+	}
+
+	for i := 0; i < len(outs); i += 2 {
+		tree.Remove(&outs[i])
+	}
+
+	remaining := []UnspentTxOut{}
+
+	for i := 1; i < len(outs); i += 2 {
+		remaining = append(remaining, outs[i])
+	}
+
+	if compare(tree.Root(), UtxoRoot(remaining)) != 0 {
+		t.Fatal("root after removals differs")
+	}
+
+	other := newTestOuts(201)
+
+	if compare(UtxoRoot(other[:200]), UtxoRoot(other)) == 0 {
+		t.Fatal("root does not depend on the outs")
+	}
+}
+
+func TestRootAfter(t *testing.T) {
+	outs := newTestOuts(10)
+	tree := NewUtxoTree(outs)
+	before := tree.Root()
+
+	spend := Transaction{
+		Ins:   []TxIn{{PrevHash: outs[3].TxHash, PrevIdx: outs[3].InIdx}},
+		Outs:  []TxOut{{Value: 3, Address: []byte("b")}, NewDataOut([]byte("x"))},
+		Stamp: Stamp{Hash: NewHash([]byte("spend"))},
+	}
+
+	// Spends an out created by the block
+	chained := Transaction{
+		Ins:   []TxIn{{PrevHash: spend.Stamp.Hash, PrevIdx: 0}},
+		Outs:  []TxOut{{Value: 3, Address: []byte("c")}},
+		Stamp: Stamp{Hash: NewHash([]byte("chained"))},
+	}
+
+	coinbase := Transaction{
+		Outs:  []TxOut{{Value: 100, Address: []byte("m")}},
+		Stamp: Stamp{Hash: NewHash([]byte("coinbase"))},
+	}
+
+	block := &Block{Header: BlockHeader{Height: 20}, Transactions: []Transaction{coinbase, spend, chained}}
+
+	expected := append(append([]UnspentTxOut{}, outs[:3]...), outs[4:]...)
+	expected = append(expected,
+		UnspentTxOut{Out: coinbase.Outs[0], TxHash: coinbase.Stamp.Hash, IsCoinbase: true, Height: 20},
+		UnspentTxOut{Out: chained.Outs[0], TxHash: chained.Stamp.Hash, Height: 20},
+	)
+
+	if compare(tree.RootAfter(block), UtxoRoot(expected)) != 0 {
+		t.Fatal("bad root after the block")
+	}
+
+	if compare(tree.Root(), before) != 0 {
+		t.Fatal("tree changed by RootAfter")
+	}
+}
+
+func TestUnspentProof(t *testing.T) {
+	bc := newTestNode(t, RegtestParams)
+	dest := newTestWallet(t, "dest")
+
+	mineTestBlock(t, bc)
+
+	tx, err := bc.SendTo([]string{"60:" + SanitizePubKey(dest.pub)}, SendOptions{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mineTestBlock(t, bc)
+
+	if compare(bc.utxos.Root(), bc.headers[len(bc.headers)-1].UtxoRoot) != 0 {
+		t.Fatal("tree does not match the last header")
+	}
+
+	proof, err := bc.ProveUnspent(tx.Stamp.Hash, 0)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := DecodeUnspentProof(EncodeUnspentProof(proof))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := bc.VerifyUnspentProof(decoded); err != nil {
+		t.Fatal("proof refused:", err)
+	}
+
+	// The proof stays valid for its block
+	mineTestBlock(t, bc)
+
+	if err := bc.VerifyUnspentProof(proof); err != nil {
+		t.Fatal("proof refused once a block is added:", err)
+	}
+
+	tests := []func(proof *UnspentProof){
+		func(proof *UnspentProof) { proof.Out.Out.Value++ },
+		func(proof *UnspentProof) { proof.Height++ },
+		func(proof *UnspentProof) { proof.Height = 10 },
+		func(proof *UnspentProof) { proof.Index = 1 << uint(len(proof.Branch)) },
+		func(proof *UnspentProof) { proof.BucketBranch = proof.BucketBranch[1:] },
+		func(proof *UnspentProof) { proof.BucketBranch[3] = NewHash(nil) },
+	}
+
+	for i, tamper := range tests {
+		tampered, _ := DecodeUnspentProof(EncodeUnspentProof(proof))
+		tamper(tampered)
+
+		if err := bc.VerifyUnspentProof(tampered); err == nil {
+			t.Errorf("%d: tampered proof accepted", i)
+		}
+	}
+
+	// The coinbase of the first block is spent
+	if _, err := bc.ProveUnspent(tx.Ins[0].PrevHash, tx.Ins[0].PrevIdx); err == nil {
+		t.Fatal("spent out proved")
+	}
+}
@@ -124,6 +124,28 @@ func CreateWallet(name string, bc *Blockchain) (*Wallet, error) {
 	}, nil
 }
 
+// Returns the local wallet owning this pub key, if any
+func (this *Blockchain) walletByPub(pub []byte) *Wallet {
+	for _, wallet := range this.wallets {
+		if compare(pub, wallet.pub) == 0 {
+			return wallet
+		}
+	}
+
+	return nil
+}
+
+// Returns the local wallet whose pub key has this hash, if any
+func (this *Blockchain) walletByPubHash(pubHash []byte) *Wallet {
+	for _, wallet := range this.wallets {
+		if compare(pubHash, wallet.PubHash()) == 0 {
+			return wallet
+		}
+	}
+
+	return nil
+}
+
 func SanitizePubKey(pub []byte) string {
 	return hex.EncodeToString(NewHash(pub))
 }
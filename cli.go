@@ -1,6 +1,8 @@
 package main
 
 import (
+	"encoding/hex"
+	"errors"
 	"os"
 	"time"
 
@@ -16,28 +18,150 @@ func parseArgs(done func(blockchain.BlockchainOptions)) {
 			ListenAddr:    c.String("l"),
 			BootstrapAddr: c.String("c"),
 			Folder:        c.String("f"),
-			Send:          c.String("S"),
+			Chain:         c.String("chain"),
+			Send:          c.StringSlice("S"),
 			Verbose:       c.Int("v"),
 			Stats:         c.Bool("s"),
 			Wallets:       c.Bool("w"),
 			NoGui:         c.Bool("g"),
 			Mine:          c.Bool("m"),
 			Cluster:       c.Int("n"),
+			SwapDemo:      c.Bool("swap-demo"),
+			Wallet:        c.String("wallet"),
+			History:       c.Bool("H"),
+			Page:          c.Int("page"),
+			PageSize:      c.Int("page-size"),
+			CoinSelection: c.String("coin-selection"),
+			Fee:           c.Int("fee"),
+			Replaceable:   c.Bool("replaceable"),
+			LockTime:      c.Int64("locktime"),
+			Sequence:      c.Int64("sequence"),
+			Broadcast:     c.String("broadcast"),
+			Sweep:         c.String("sweep"),
+			BumpFee:       c.String("bumpfee"),
+			Cancel:        c.String("cancel"),
+
+			MultisigCreate:  c.String("multisig-create"),
+			MultisigSend:    c.String("multisig-send"),
+			MultisigSign:    c.String("multisig-sign"),
+			MultisigCombine: c.StringSlice("multisig-combine"),
+
+			ScriptAddress: c.String("script-address"),
+
+			HTLCInitiate: c.String("htlc-initiate"),
+			HTLCRedeem:   c.String("htlc-redeem"),
+			HTLCRefund:   c.String("htlc-refund"),
+			HTLCAudit:    c.String("htlc-audit"),
+
+			Supply:       c.Bool("supply"),
+			SnapshotDump: c.String("snapshot-dump"),
+			SnapshotLoad: c.String("snapshot-load"),
+			Light:        c.Bool("light"),
+			TxProof:      c.String("tx-proof"),
+			VerifyProof:  c.String("verify-proof"),
+
+			UnspentProof:       c.String("utxo-proof"),
+			VerifyUnspentProof: c.String("verify-utxo-proof"),
+
+			SearchData: c.String("search-data"),
+
+			MempoolMaxSize:  c.Int("mempool-max-size"),
+			MempoolMaxCount: c.Int("mempool-max-count"),
+			MempoolExpiry:   c.Duration("mempool-expiry"),
+		}
+
+		if _, err := blockchain.GetChainParams(options.Chain); err != nil {
+			return err
+		}
+
+		if len(c.StringSlice("checkpoint")) > 0 {
+			options.Checkpoints = make(map[int64][]byte)
+
+			for _, value := range c.StringSlice("checkpoint") {
+				height, hash, err := blockchain.ParseCheckpoint(value)
+
+				if err != nil {
+					return err
+				}
+
+				options.Checkpoints[height] = hash
+			}
+		}
+
+		if len(c.String("assume-valid")) > 0 {
+			hash, err := hex.DecodeString(c.String("assume-valid"))
+
+			if err != nil {
+				return errors.New("Invalid assumed valid block hash")
+			}
+
+			options.AssumeValid = hash
+		}
+
+		if len(c.String("snapshot-hash")) > 0 {
+			hash, err := hex.DecodeString(c.String("snapshot-hash"))
+
+			if err != nil {
+				return errors.New("Invalid snapshot hash")
+			}
+
+			options.SnapshotHash = hash
+		}
+
+		if len(c.String("data")) > 0 {
+			data, err := hex.DecodeString(c.String("data"))
+
+			if err != nil {
+				return errors.New("Invalid data, must be hex encoded")
+			}
+
+			options.Data = data
 		}
 
-		if options.Cluster > 0 {
-			options.Send = ""
+		if len(c.String("memo")) > 0 {
+			options.Data = []byte(c.String("memo"))
+		}
+
+		if len(c.String("send-file")) > 0 {
+			payments, err := blockchain.LoadPaymentsFile(c.String("send-file"))
+
+			if err != nil {
+				return err
+			}
+
+			options.Send = append(options.Send, payments...)
+		}
+
+		if options.Cluster > 0 || options.SwapDemo {
+			clearOneShotOptions(&options)
+
 			options.Stats = false
 			options.NoGui = true
 			options.Wallets = false
 		}
 
-		if options.Stats || len(options.Send) > 0 {
+		if options.History {
+			clearOneShotOptions(&options)
+
+			options.Stats = false
+		}
+
+		// Light nodes have no unspent outs to mine with or to dump
+		if options.Light {
+			options.Mine = false
+			options.SnapshotDump = ""
+		}
+
+		// Checked on a copy, so the command stays
+		oneShot := options
+		walletCommand := clearOneShotOptions(&oneShot)
+
+		if options.Stats || walletCommand || options.History {
 			options.NoGui = true
 			options.Wallets = false
 		}
 
-		if len(options.Send) > 0 {
+		if walletCommand {
 			options.Stats = false
 		}
 
@@ -49,6 +173,51 @@ func parseArgs(done func(blockchain.BlockchainOptions)) {
 	app.Run(os.Args)
 }
 
+// Clear the options of the commands that run once and exit: wallet commands and
+// queries. Returns whether one of them was given
+func clearOneShotOptions(options *blockchain.BlockchainOptions) bool {
+	given := len(options.Send) > 0 ||
+		len(options.MultisigCombine) > 0 ||
+		len(options.Data) > 0 ||
+		options.Supply
+
+	options.Send = nil
+	options.MultisigCombine = nil
+	options.Data = nil
+	options.Supply = false
+
+	values := []*string{
+		&options.Sweep,
+		&options.Broadcast,
+		&options.BumpFee,
+		&options.Cancel,
+		&options.MultisigCreate,
+		&options.MultisigSend,
+		&options.MultisigSign,
+		&options.ScriptAddress,
+		&options.HTLCInitiate,
+		&options.HTLCRedeem,
+		&options.HTLCRefund,
+		&options.HTLCAudit,
+		&options.SearchData,
+		&options.SnapshotDump,
+		&options.TxProof,
+		&options.VerifyProof,
+		&options.UnspentProof,
+		&options.VerifyUnspentProof,
+	}
+
+	for _, value := range values {
+		if len(*value) > 0 {
+			given = true
+		}
+
+		*value = ""
+	}
+
+	return given
+}
+
 func setupCli() *cli.App {
 	cli.AppHelpTemplate = `NAME:
 	{{.Name}} - {{.Usage}}
@@ -104,6 +273,19 @@ COPYRIGHT:
 			Usage: "Config Folder",
 			Value: os.Getenv("HOME") + "/.crypto-dht",
 		},
+		cli.StringFlag{
+			Name:  "chain",
+			Usage: "Chain to join: main, test or regtest. Other chains than main are kept in a subfolder",
+			Value: "main",
+		},
+		cli.StringSliceFlag{
+			Name:  "checkpoint",
+			Usage: "Refuse the chains without this block. Must be of the form 'height:blockHash'. Can be repeated",
+		},
+		cli.StringFlag{
+			Name:  "assume-valid",
+			Usage: "Do not check the signatures of the ancestors of the block with this `hash` when syncing",
+		},
 		cli.BoolFlag{
 			Name:  "s",
 			Usage: "Stat mode",
@@ -120,15 +302,181 @@ COPYRIGHT:
 			Name:  "g",
 			Usage: "Deactivate GUI",
 		},
-		cli.StringFlag{
+		cli.StringSliceFlag{
 			Name:  "S, send",
-			Usage: "Send coins from main.key. Must be of the form 'amount:destAddress'",
+			Usage: "Send coins from main.key. Must be of the form 'amount:destAddress'. Repeat it to pay many addresses in one transaction, each once",
+		},
+		cli.StringFlag{
+			Name:  "send-file",
+			Usage: "Send coins from main.key to every 'amount,destAddress' line of the CSV `file`, in one transaction",
+		},
+		cli.IntFlag{
+			Name:  "fee",
+			Value: 0,
+			Usage: "Fee paid by the sent transaction, in `cents`",
+		},
+		cli.BoolFlag{
+			Name:  "replaceable",
+			Usage: "Allow to bump the fee or cancel the sent transaction while it is pending",
+		},
+		cli.Int64Flag{
+			Name:  "locktime",
+			Value: 0,
+			Usage: "Block `height` (or unix timestamp if >= 500000000) before which the sent transaction cannot be mined",
+		},
+		cli.Int64Flag{
+			Name:  "sequence",
+			Value: 0,
+			Usage: "Only spend outs confirmed for this number of `blocks`",
+		},
+		cli.StringFlag{
+			Name:  "data",
+			Usage: "Attach hex encoded `bytes` to the sent transaction, in an unspendable out. Can be sent without payment",
+		},
+		cli.StringFlag{
+			Name:  "memo",
+			Usage: "Attach a `text` to the sent transaction, like --data",
+		},
+		cli.StringFlag{
+			Name:  "search-data",
+			Usage: "Show the transactions whose data starts with `query`, hex encoded or text",
+		},
+		cli.StringFlag{
+			Name:  "broadcast",
+			Usage: "Broadcast a raw `transaction`, as given when sending with --locktime",
+		},
+		cli.StringFlag{
+			Name:  "bumpfee",
+			Usage: "Replace a pending replaceable transaction to pay more fees. Must be of the form 'txHash[:fee]'",
+		},
+		cli.StringFlag{
+			Name:  "cancel",
+			Usage: "Replace a pending replaceable transaction by one paying back to the wallet. Must be of the form 'txHash[:fee]'",
+		},
+		cli.StringFlag{
+			Name:  "multisig-create",
+			Usage: "Create a multisig address. Must be of the form 'm:pub1,pub2,...' with the hex pub keys shown by --wallets",
+		},
+		cli.StringFlag{
+			Name:  "multisig-send",
+			Usage: "Create a transaction spending from a multisig `address`, with the payments given by --send, and sign it with our keys",
+		},
+		cli.StringFlag{
+			Name:  "multisig-sign",
+			Usage: "Add our signatures to a partially signed `transaction`",
+		},
+		cli.StringSliceFlag{
+			Name:  "multisig-combine",
+			Usage: "Merge the signatures of several copies of a partially signed `transaction`. Can be given multiple times",
+		},
+		cli.StringFlag{
+			Name:  "script-address",
+			Usage: "Show the address of the outs locked by a `script`, like 'OP_DUP OP_SHA256 <pubHash> OP_EQUALVERIFY OP_CHECKSIG' with hex data",
+		},
+		cli.StringFlag{
+			Name:  "htlc-initiate",
+			Usage: "Lock coins in a hash time-locked contract. Must be of the form 'amount:recipientAddress:timeout[:hash]', a secret is generated without hash",
+		},
+		cli.StringFlag{
+			Name:  "htlc-redeem",
+			Usage: "Claim the coins of a contract as its recipient. Must be of the form 'contract[:secret]'",
+		},
+		cli.StringFlag{
+			Name:  "htlc-refund",
+			Usage: "Take back the coins of a `contract` after its timeout",
+		},
+		cli.StringFlag{
+			Name:  "htlc-audit",
+			Usage: "Show the terms and the locked amount of a `contract`",
+		},
+		cli.StringFlag{
+			Name:  "sweep",
+			Usage: "Move all the outs of the wallet to one address. Must be of the form 'destAddress[:threshold]' to only sweep outs below threshold. Each sweep transaction pays --fee",
+		},
+		cli.StringFlag{
+			Name:  "coin-selection",
+			Usage: "Coin selection `strategy` used to send: bnb, largest, smallest or random",
+			Value: "bnb",
+		},
+		cli.StringFlag{
+			Name:  "wallet",
+			Usage: "Wallet `name` to use",
+			Value: "main.key",
+		},
+		cli.BoolFlag{
+			Name:  "H, history",
+			Usage: "Show the wallet transaction history",
+		},
+		cli.IntFlag{
+			Name:  "page",
+			Value: 0,
+			Usage: "History `page` to show, most recent first",
+		},
+		cli.IntFlag{
+			Name:  "page-size",
+			Value: 20,
+			Usage: "Number of history `entries` by page",
+		},
+		cli.BoolFlag{
+			Name:  "supply",
+			Usage: "Show the current block reward, the coins created so far and the max supply",
+		},
+		cli.BoolFlag{
+			Name:  "light",
+			Usage: "Keep only the block headers and check transactions with Merkel proofs. Cannot mine nor send",
+		},
+		cli.StringFlag{
+			Name:  "tx-proof",
+			Usage: "Show the proof that a transaction is in a block, fetched from the DHT. Must be of the form 'txHash:height'",
+		},
+		cli.StringFlag{
+			Name:  "verify-proof",
+			Usage: "Check a transaction `proof` against our headers, and show the confirmations and the outs of the transaction",
+		},
+		cli.StringFlag{
+			Name:  "utxo-proof",
+			Usage: "Show the proof that an out is unspent at our last block. Must be of the form 'txHash:outIdx'",
+		},
+		cli.StringFlag{
+			Name:  "verify-utxo-proof",
+			Usage: "Check an unspent out `proof` against our headers, and show the out",
+		},
+		cli.StringFlag{
+			Name:  "snapshot-dump",
+			Usage: "Write the unspent outs at the last block to `file`, and show the snapshot hash",
+		},
+		cli.StringFlag{
+			Name:  "snapshot-load",
+			Usage: "Start a node without blocks from the snapshot in `file`, then follow the chain from its last block",
+		},
+		cli.StringFlag{
+			Name:  "snapshot-hash",
+			Usage: "Expected `hash` of the loaded snapshot, when the chain does not know it",
+		},
+		cli.IntFlag{
+			Name:  "mempool-max-size",
+			Value: blockchain.MEMPOOL_MAX_SIZE,
+			Usage: "Max size of the pending transactions, in `bytes`",
+		},
+		cli.IntFlag{
+			Name:  "mempool-max-count",
+			Value: blockchain.MEMPOOL_MAX_COUNT,
+			Usage: "Max `number` of pending transactions",
+		},
+		cli.DurationFlag{
+			Name:  "mempool-expiry",
+			Value: blockchain.MEMPOOL_EXPIRY,
+			Usage: "Drop the pending transactions older than `duration`",
 		},
 		cli.IntFlag{
 			Name:  "n, network",
 			Value: 0,
 			Usage: "Spawn X new `nodes` network. If -b is not specified, a new network is created.",
 		},
+		cli.BoolFlag{
+			Name:  "swap-demo",
+			Usage: "Spawn two local networks and run an atomic swap between them",
+		},
 		cli.IntFlag{
 			Name:  "v, verbose",
 			Value: 3,
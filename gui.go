@@ -3,6 +3,8 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"strconv"
+	"time"
 
 	astilectron "github.com/asticode/go-astilectron"
 	bootstrap "github.com/asticode/go-astilectron-bootstrap"
@@ -36,12 +38,53 @@ type BaseInfo struct {
 	StoredKeys         int                    `json:"storedKeys"`
 	History            []blockchain.HistoryTx `json:"history"`
 	OwnWaitingTx       []blockchain.HistoryTx `json:"ownWaitingTx"`
+	OwnTransactions    []blockchain.OwnTx     `json:"ownTransactions"`
+}
+
+type HistoryRequest struct {
+	Wallet string `json:"wallet"`
+	Offset int    `json:"offset"`
+	Limit  int    `json:"limit"`
+}
+
+type HistoryPage struct {
+	Total int                    `json:"total"`
+	Txs   []blockchain.HistoryTx `json:"txs"`
+}
+
+type SendRequest struct {
+	Value       string   `json:"value"`
+	Values      []string `json:"values"`
+	Strategy    string   `json:"strategy"`
+	Fee         int      `json:"fee"`
+	Replaceable bool     `json:"replaceable"`
+	LockTime    int64    `json:"lockTime"`
+	Sequence    int64    `json:"sequence"`
+	Memo        string   `json:"memo"`
+}
+
+type SearchDataRequest struct {
+	Query string `json:"query"`
+	Limit int    `json:"limit"`
+}
+
+type ReplaceRequest struct {
+	TxHash string `json:"txHash"`
+	Fee    int    `json:"fee"`
+}
+
+type SweepRequest struct {
+	Wallet    string `json:"wallet"`
+	Address   string `json:"address"`
+	Threshold int    `json:"threshold"`
+	Fee       int    `json:"fee"`
 }
 
 type WalletClient struct {
-	Name    string `json:"name"`
-	Address string `json:"address"`
-	Amount  int    `json:"amount"`
+	Name     string `json:"name"`
+	Address  string `json:"address"`
+	Amount   int    `json:"amount"`
+	Immature int    `json:"immature"`
 }
 
 func GetBaseInfos() BaseInfo {
@@ -50,9 +93,10 @@ func GetBaseInfos() BaseInfo {
 
 	for _, wallet := range wallets {
 		walletsRes = append(walletsRes, WalletClient{
-			Name:    wallet.Name(),
-			Address: blockchain.SanitizePubKey(wallet.Pub()),
-			Amount:  bc.GetAvailableFunds(wallet.Pub()),
+			Name:     wallet.Name(),
+			Address:  blockchain.SanitizePubKey(wallet.Pub()),
+			Amount:   bc.GetAvailableFunds(wallet.Pub()),
+			Immature: bc.GetImmatureFunds(wallet.Pub()),
 		})
 	}
 
@@ -75,6 +119,7 @@ func GetBaseInfos() BaseInfo {
 		TimeSinceLastBlock: bc.TimeSinceLastBlock(),
 		History:            bc.GetOwnHistory(),
 		OwnWaitingTx:       bc.GetOwnWaitingTx(),
+		OwnTransactions:    bc.GetOwnTransactions(),
 		MinerInfo: MinerInfo{
 			Hashrate:               hashRate,
 			Running:                bc.Running(),
@@ -89,17 +134,100 @@ func handleMessages(w *astilectron.Window, m bootstrap.MessageIn) (payload inter
 	case "getInfos":
 		payload = GetBaseInfos()
 
+	case "getHistory":
+		r := HistoryRequest{
+			Wallet: "main.key",
+		}
+
+		json.Unmarshal(m.Payload, &r)
+
+		txs, total := bc.GetHistory(r.Wallet, r.Offset, r.Limit)
+
+		payload = HistoryPage{
+			Total: total,
+			Txs:   txs,
+		}
+
 	case "send":
-		var r string
+		var r SendRequest
+
+		json.Unmarshal(m.Payload, &r)
+
+		if len(r.Value) > 0 {
+			r.Values = append(r.Values, r.Value)
+		}
+
+		tx, err := bc.SendTo(r.Values, blockchain.SendOptions{
+			Strategy:    r.Strategy,
+			Fee:         r.Fee,
+			Replaceable: r.Replaceable,
+			LockTime:    r.LockTime,
+			Sequence:    r.Sequence,
+			Data:        []byte(r.Memo),
+		})
+
+		payload = ""
+
+		if err != nil {
+			payload = err.Error()
+		} else if !tx.IsFinal(bc.BlocksHeight()+1, time.Now().Unix()) {
+			raw, _ := blockchain.EncodeRawTransaction(tx)
+
+			payload = "Locked transaction, broadcast it later: " + raw
+		}
+
+	case "searchData":
+		var r SearchDataRequest
+
+		json.Unmarshal(m.Payload, &r)
+
+		payload = bc.SearchData(r.Query, r.Limit)
+
+	case "broadcast":
+		var raw string
+
+		json.Unmarshal(m.Payload, &raw)
+
+		payload = ""
+
+		if err := bc.BroadcastRawTransaction(raw); err != nil {
+			payload = err.Error()
+		}
+
+	case "bumpfee", "cancel":
+		var r ReplaceRequest
 
 		json.Unmarshal(m.Payload, &r)
 
-		err := bc.SendTo(r)
+		order := r.TxHash
+
+		if r.Fee > 0 {
+			order += ":" + strconv.Itoa(r.Fee)
+		}
+
+		replace := bc.BumpFeeTo
+
+		if m.Name == "cancel" {
+			replace = bc.CancelTo
+		}
 
 		payload = ""
 
+		if err := replace(order); err != nil {
+			payload = err.Error()
+		}
+
+	case "sweep":
+		var r SweepRequest
+
+		json.Unmarshal(m.Payload, &r)
+
+		txs, err := bc.Sweep(r.Wallet, []byte(r.Address), r.Threshold, r.Fee)
+
 		if err != nil {
 			payload = err.Error()
+		} else {
+			payload = fmt.Sprintf("Sweeping in %d transactions", len(txs))
 		}
 	}
 	return
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"os"
 	"os/signal"
 	"strconv"
@@ -13,7 +14,9 @@ import (
 
 func main() {
 	parseArgs(func(options blockchain.BlockchainOptions) {
-		if options.Cluster > 0 {
+		if options.SwapDemo {
+			swapDemo(options)
+		} else if options.Cluster > 0 {
 			cluster(options)
 		} else {
 			node := startOne(options)
@@ -48,7 +51,13 @@ func exitProperly(client *blockchain.Blockchain) {
 }
 
 func startOne(options blockchain.BlockchainOptions) *blockchain.Blockchain {
-	client := blockchain.New(options)
+	client, err := blockchain.New(options)
+
+	if err != nil {
+		fmt.Println(err)
+
+		os.Exit(1)
+	}
 
 	if err := client.Start(); err != nil {
 		client.Logger().Critical(err)
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/champii/crypto-dht/blockchain"
+)
+
+var (
+	// Coins exchanged by each side of the demo swap
+	SWAP_AMOUNT = 100
+
+	// Blocks before the participant contract can be refunded. The initiator one
+	// lasts twice as long, so the participant always has time to redeem it
+	SWAP_TIMEOUT int64 = 20
+
+	// Max time to wait for each step of the demo
+	SWAP_STEP_TIMEOUT = 5 * time.Minute
+)
+
+// Each side of the swap runs its own regtest chain, with its own ID and genesis,
+// so that nothing sent on one is valid on the other
+func swapChain(name string, idx uint32) *blockchain.ChainParams {
+	params := blockchain.RegtestParams
+
+	params.Name = "swap-" + name
+	params.ID = blockchain.RegtestParams.ID + idx<<8
+	params.GenesisTimestamp += int64(idx)
+
+	return &params
+}
+
+// Run an atomic swap between two local networks: alice trades coins of the
+// network A for coins of the network B owned by bob
+func swapDemo(options blockchain.BlockchainOptions) {
+	options.NoGui = true
+	options.Stats = false
+	options.Wallets = false
+	options.BootstrapAddr = ""
+
+	chainA := swapChain("a", 1)
+	chainB := swapChain("b", 2)
+
+	addrPort := strings.Split(options.ListenAddr, ":")
+	port, _ := strconv.Atoi(addrPort[1])
+
+	startNode := func(name string, offset int, chain *blockchain.ChainParams, bootstrap *blockchain.BlockchainOptions, mine bool) (*blockchain.Blockchain, blockchain.BlockchainOptions) {
+		nodeOptions := options
+		nodeOptions.Params = chain
+		nodeOptions.ListenAddr = addrPort[0] + ":" + strconv.Itoa(port+offset)
+		nodeOptions.Folder = options.Folder + "-swap-" + name
+		nodeOptions.Mine = mine
+
+		if bootstrap != nil {
+			nodeOptions.BootstrapAddr = bootstrap.ListenAddr
+		}
+
+		return startOne(nodeOptions), nodeOptions
+	}
+
+	// Alice mines on A and bob mines on B
+	aliceA, networkA := startNode("alice-a", 0, chainA, nil, true)
+	bobA, _ := startNode("bob-a", 1, chainA, &networkA, false)
+	bobB, networkB := startNode("bob-b", 2, chainB, nil, true)
+	aliceB, _ := startNode("alice-b", 3, chainB, &networkB, false)
+
+	nodes := []*blockchain.Blockchain{aliceA, bobA, bobB, aliceB}
+
+	waitFor := func(what string, cond func() bool) {
+		if !waitUntil(what, cond) {
+			swapFailed(nodes, "Timeout waiting for "+what, nil)
+		}
+	}
+
+	waitFor("the nodes to sync", func() bool {
+		for _, node := range nodes {
+			if !node.Synced() {
+				return false
+			}
+		}
+
+		return true
+	})
+
+	waitFor("alice to mine on A", func() bool {
+		return funds(aliceA) >= SWAP_AMOUNT
+	})
+
+	waitFor("bob to mine on B", func() bool {
+		return funds(bobB) >= SWAP_AMOUNT
+	})
+
+	// Alice locks coins for bob on A, with a secret nobody else knows
+	contractA, _, err := aliceA.InitiateHTLC(SWAP_AMOUNT, address(bobA), aliceA.BlocksHeight()+2*SWAP_TIMEOUT, nil, 0)
+
+	if err != nil {
+		swapFailed(nodes, "Alice cannot initiate", err)
+	}
+
+	secret := aliceA.GetSecret(contractA.Hash)
+
+	fmt.Println("Alice locked", SWAP_AMOUNT, "on A for bob, hash", hex.EncodeToString(contractA.Hash))
+
+	// Bob checks the contract of alice, then locks coins for alice on B with the same hash
+	waitFor("bob to see the contract of alice on A", func() bool {
+		amount, _ := bobA.AuditHTLC(contractA)
+
+		return amount >= SWAP_AMOUNT
+	})
+
+	contractB, _, err := bobB.InitiateHTLC(SWAP_AMOUNT, address(aliceB), bobB.BlocksHeight()+SWAP_TIMEOUT, contractA.Hash, 0)
+
+	if err != nil {
+		swapFailed(nodes, "Bob cannot initiate", err)
+	}
+
+	fmt.Println("Bob locked", SWAP_AMOUNT, "on B for alice")
+
+	// Alice redeems on B, revealing the secret
+	waitFor("alice to see the contract of bob on B", func() bool {
+		amount, _ := aliceB.AuditHTLC(contractB)
+
+		return amount >= SWAP_AMOUNT
+	})
+
+	if _, err := aliceB.RedeemHTLC(contractB, secret, 0); err != nil {
+		swapFailed(nodes, "Alice cannot redeem", err)
+	}
+
+	fmt.Println("Alice redeemed on B")
+
+	// Bob learns the secret from the redeem of alice, and claims the coins on A
+	var revealed []byte
+
+	waitFor("bob to see the secret on B", func() bool {
+		revealed = bobB.GetSecret(contractA.Hash)
+
+		return revealed != nil
+	})
+
+	if _, err := bobA.RedeemHTLC(contractA, revealed, 0); err != nil {
+		swapFailed(nodes, "Bob cannot redeem", err)
+	}
+
+	fmt.Println("Bob redeemed on A")
+
+	waitFor("both redeems to be mined", func() bool {
+		amountA, _ := bobA.AuditHTLC(contractA)
+		amountB, _ := aliceB.AuditHTLC(contractB)
+
+		return amountA == 0 && amountB == 0 && funds(aliceB) >= SWAP_AMOUNT && funds(bobA) >= SWAP_AMOUNT
+	})
+
+	fmt.Println("Swap done: alice has", funds(aliceB), "on B, bob has", funds(bobA), "on A")
+
+	for _, node := range nodes {
+		node.Stop()
+	}
+
+	os.Exit(0)
+}
+
+func funds(node *blockchain.Blockchain) int {
+	return node.GetAvailableFunds(node.Wallets()["main.key"].Pub())
+}
+
+func address(node *blockchain.Blockchain) string {
+	return blockchain.SanitizePubKey(node.Wallets()["main.key"].Pub())
+}
+
+// Returns false if `cond` is still false after SWAP_STEP_TIMEOUT
+func waitUntil(what string, cond func() bool) bool {
+	fmt.Println("Waiting for", what)
+
+	deadline := time.Now().Add(SWAP_STEP_TIMEOUT)
+
+	for !cond() {
+		if time.Now().After(deadline) {
+			return false
+		}
+
+		time.Sleep(time.Second)
+	}
+
+	return true
+}
+
+func swapFailed(nodes []*blockchain.Blockchain, msg string, err error) {
+	if err != nil {
+		fmt.Println("Swap failed:", msg, err)
+	} else {
+		fmt.Println("Swap failed:", msg)
+	}
+
+	for _, node := range nodes {
+		node.Stop()
+	}
+
+	os.Exit(1)
+}